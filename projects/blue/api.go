@@ -0,0 +1,75 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	ffclient "github.com/thomaspoignant/go-feature-flag"
+)
+
+// variationSummary is how many users got one variation.
+type variationSummary struct {
+	Count      int     `json:"count"`
+	Percentage float64 `json:"percentage"`
+}
+
+// colorsSummary is the distribution of a flag across all users.
+type colorsSummary struct {
+	Flag        string                      `json:"flag"`
+	Version     string                      `json:"version,omitempty"`
+	RefreshedAt time.Time                   `json:"refreshedAt"`
+	Users       int                         `json:"users"`
+	Variations  map[string]variationSummary `json:"variations"`
+}
+
+// colorsHandler returns the color of every user for the flag given with
+// ?flag=, as rendered in its grid.
+func colorsHandler(c echo.Context) error {
+	colors, err := evaluateFlag(requestedFlag(c))
+	if err != nil {
+		return c.JSON(flagErrorStatus(err), map[string]string{"error": err.Error()})
+	}
+	return c.JSON(http.StatusOK, colors)
+}
+
+// colorsSummaryHandler returns how many users got each color, along with
+// the version of the flag and when its config was last loaded.
+func colorsSummaryHandler(c echo.Context) error {
+	key := requestedFlag(c)
+	summary := colorsSummary{
+		Flag:        key,
+		RefreshedAt: ffclient.GetCacheRefreshDate(),
+	}
+	colors, err := evaluateFlag(key)
+	if err != nil {
+		return c.JSON(flagErrorStatus(err), map[string]string{"error": err.Error()})
+	}
+	summary.Users = len(colors)
+	summary.Variations = summarize(colors)
+
+	flags, err := ffclient.GetFlagsFromCache()
+	if err != nil {
+		return c.JSON(http.StatusServiceUnavailable, map[string]string{"error": err.Error()})
+	}
+	if f, ok := flags[key]; ok {
+		summary.Version = f.GetVersion()
+	}
+	return c.JSON(http.StatusOK, summary)
+}
+
+// summarize counts users per color. Percentages are rounded to two decimals.
+func summarize(colors map[string]string) map[string]variationSummary {
+	counts := make(map[string]int)
+	for _, color := range colors {
+		counts[color]++
+	}
+	out := make(map[string]variationSummary, len(counts))
+	for color, n := range counts {
+		out[color] = variationSummary{
+			Count:      n,
+			Percentage: round2(float64(n) / float64(len(colors)) * 100),
+		}
+	}
+	return out
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// appMetrics are the metrics served at /metrics. Every color service
+// exposes the same set, so one dashboard covers them all.
+type appMetrics struct {
+	registry *prometheus.Registry
+
+	renderDuration   prometheus.Histogram
+	httpRequests     *prometheus.CounterVec
+	httpDuration     *prometheus.HistogramVec
+	evaluations      *prometheus.CounterVec
+	evaluationErrors *prometheus.CounterVec
+}
+
+// newMetrics registers the metrics on a fresh registry. refreshed reports
+// when the flag config was last loaded.
+func newMetrics(refreshed func() time.Time) *appMetrics {
+	m := &appMetrics{
+		registry: prometheus.NewRegistry(),
+		renderDuration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "http_render_duration_seconds",
+			Help:    "Time spent evaluating the flag for every user of a page render.",
+			Buckets: []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1},
+		}),
+		httpRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "http_requests_total",
+			Help: "HTTP requests by route, method and status code.",
+		}, []string{"route", "method", "status"}),
+		httpDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "http_request_duration_seconds",
+			Help:    "HTTP request duration by route and method.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"route", "method"}),
+		evaluations: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "feature_flag_evaluations_total",
+			Help: "Flag evaluations by flag and resulting value.",
+		}, []string{"flag", "value"}),
+		evaluationErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "feature_flag_evaluation_errors_total",
+			Help: "Flag evaluations that failed and returned the default value.",
+		}, []string{"flag"}),
+	}
+	lastRefresh := prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "feature_flag_config_last_refresh_timestamp_seconds",
+		Help: "Unix time at which the flag config was last loaded.",
+	}, func() float64 {
+		t := refreshed()
+		if t.IsZero() {
+			return 0
+		}
+		return float64(t.UnixNano()) / 1e9
+	})
+
+	m.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.renderDuration, m.httpRequests, m.httpDuration, m.evaluations, m.evaluationErrors, lastRefresh,
+	)
+	return m
+}
+
+// countEvaluations records one evaluation of flag per entry of values, the
+// cell class each user got. Variations serving the same value share a series.
+func (m *appMetrics) countEvaluations(flag string, values map[string]string) {
+	counts := make(map[string]int)
+	for _, v := range values {
+		counts[v]++
+	}
+	for v, n := range counts {
+		m.evaluations.WithLabelValues(flag, v).Add(float64(n))
+	}
+}
+
+// middleware records every request by route template rather than by path,
+// which keeps the number of series bounded.
+func (m *appMetrics) middleware() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			start := time.Now()
+			err := next(c)
+
+			route := c.Path()
+			if route == "" {
+				route = "unmatched"
+			}
+			// The error handler, which writes the status of a failed
+			// request, only runs after the middleware chain
+			status := c.Response().Status
+			if err != nil {
+				var he *echo.HTTPError
+				if errors.As(err, &he) {
+					status = he.Code
+				} else {
+					status = http.StatusInternalServerError
+				}
+			}
+			method := c.Request().Method
+			m.httpRequests.WithLabelValues(route, method, strconv.Itoa(status)).Inc()
+			m.httpDuration.WithLabelValues(route, method).Observe(time.Since(start).Seconds())
+			return err
+		}
+	}
+}
+
+func (m *appMetrics) handler() echo.HandlerFunc {
+	return echo.WrapHandler(promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{}))
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// scrape returns the /metrics output of m.
+func scrape(t *testing.T, m *appMetrics) string {
+	t.Helper()
+	e := echo.New()
+	rec := httptest.NewRecorder()
+	e.GET("/metrics", m.handler())
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	body, err := io.ReadAll(rec.Body)
+	require.NoError(t, err)
+	return string(body)
+}
+
+func TestMetrics_RecordsRequestsByRoute(t *testing.T) {
+	m := newMetrics(func() time.Time { return time.Time{} })
+	e := echo.New()
+	e.Use(m.middleware())
+	e.GET("/api/colors", func(c echo.Context) error { return c.JSON(http.StatusOK, nil) })
+	e.GET("/boom", func(c echo.Context) error { return echo.NewHTTPError(http.StatusBadGateway) })
+
+	for _, path := range []string{"/api/colors", "/api/colors", "/boom", "/nowhere"} {
+		e.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	out := scrape(t, m)
+	assert.Contains(t, out, `http_requests_total{method="GET",route="/api/colors",status="200"} 2`)
+	assert.Contains(t, out, `http_requests_total{method="GET",route="/boom",status="502"} 1`)
+	assert.Contains(t, out, `status="404"} 1`)
+	assert.NotContains(t, out, "/nowhere", "unknown paths must not create series")
+	assert.Contains(t, out, `http_request_duration_seconds_count{method="GET",route="/api/colors"} 2`)
+	assert.Contains(t, out, "feature_flag_config_last_refresh_timestamp_seconds 0")
+}
+
+func TestMetrics_CountsEvaluations(t *testing.T) {
+	refreshed := time.Unix(1760000000, 0)
+	m := newMetrics(func() time.Time { return refreshed })
+	m.countEvaluations("color-box", map[string]string{"user0": "red", "user1": "red", "user2": "grey"})
+	m.evaluationErrors.WithLabelValues("color-box").Inc()
+	m.renderDuration.Observe(0.002)
+
+	out := scrape(t, m)
+	assert.Contains(t, out, `feature_flag_evaluations_total{flag="color-box",value="red"} 2`)
+	assert.Contains(t, out, `feature_flag_evaluations_total{flag="color-box",value="grey"} 1`)
+	assert.Contains(t, out, `feature_flag_evaluation_errors_total{flag="color-box"} 1`)
+	assert.Contains(t, out, "feature_flag_config_last_refresh_timestamp_seconds 1.76e+09")
+	assert.Contains(t, out, "http_render_duration_seconds_count 1")
+}
@@ -0,0 +1,334 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"maps"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/thomaspoignant/go-feature-flag/ffcontext"
+)
+
+// gridColumns is the number of cells per grid row.
+const gridColumns = 50
+
+// defaultAttributes are the distributions generated users get unless
+// -attributes says otherwise, so the targeting examples in the flag file
+// have something to match.
+const defaultAttributes = "country=FR:30,US:30,DE:20,JP:20;plan=free:70,pro:25,enterprise:5;beta=true:10,false:90;email=example.com:60,acme.io:30,corp.internal:10"
+
+// user is one member of the population shown in the grid. Name identifies
+// the cell (user0, user1, ...) and Context, which carries Attributes as
+// custom attributes, is what the flag is evaluated against.
+type user struct {
+	Name       string
+	Context    ffcontext.EvaluationContext
+	Attributes map[string]any
+}
+
+func newUser(i int, key string, attributes map[string]any) user {
+	b := ffcontext.NewEvaluationContextBuilder(key)
+	for name, value := range attributes {
+		b = b.AddCustom(name, value)
+	}
+	return user{Name: userName(i), Context: b.Build(), Attributes: attributes}
+}
+
+// distribution is the weighted set of values generated for one attribute.
+type distribution struct {
+	Name    string
+	Values  []any
+	Weights []float64
+	total   float64
+}
+
+// parseDistributions parses -attributes: attributes separated by ";", each
+// "name=value:weight,value:weight". "true" and "false" become booleans. The
+// values of "email" are domains, and users get name@domain addresses.
+func parseDistributions(spec string) ([]distribution, error) {
+	var dists []distribution
+	for part := range strings.SplitSeq(spec, ";") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		name, values, ok := strings.Cut(part, "=")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" || name == "key" {
+			return nil, fmt.Errorf("invalid attribute %q: want name=value:weight,...", part)
+		}
+		d := distribution{Name: name}
+		for v := range strings.SplitSeq(values, ",") {
+			value, weight, ok := strings.Cut(strings.TrimSpace(v), ":")
+			w, err := strconv.ParseFloat(weight, 64)
+			if !ok || value == "" || err != nil || w < 0 {
+				return nil, fmt.Errorf("invalid value %q for attribute %s: want value:weight", v, name)
+			}
+			d.Values = append(d.Values, parseValue(value))
+			d.Weights = append(d.Weights, w)
+			d.total += w
+		}
+		if d.total <= 0 {
+			return nil, fmt.Errorf("attribute %s has no positive weight", name)
+		}
+		dists = append(dists, d)
+	}
+	return dists, nil
+}
+
+// pick returns the value of d for user i, the same for a given seed.
+func (d distribution) pick(seed int64, i int) any {
+	h := fnv.New64a()
+	_, _ = fmt.Fprintf(h, "%d/%d/%s", seed, i, d.Name)
+	x := float64(h.Sum64()%1_000_000) / 1_000_000 * d.total
+	for j, w := range d.Weights {
+		if x < w {
+			return d.Values[j]
+		}
+		x -= w
+	}
+	return d.Values[len(d.Values)-1]
+}
+
+// generatePopulation returns size users whose keys and attributes are derived
+// from seed, so every replica and every restart started with the same seed
+// buckets users the same way.
+func generatePopulation(size int, seed int64, dists []distribution) []user {
+	users := make([]user, size)
+	for i := range users {
+		key := uuid.NewSHA1(uuid.NameSpaceOID, fmt.Appendf(nil, "webcolor/%d/%d", seed, i))
+		var attributes map[string]any
+		if len(dists) > 0 {
+			attributes = make(map[string]any, len(dists))
+			for _, d := range dists {
+				value := d.pick(seed, i)
+				if d.Name == "email" {
+					value = fmt.Sprintf("%s@%v", userName(i), value)
+				}
+				attributes[d.Name] = value
+			}
+		}
+		users[i] = newUser(i, key.String(), attributes)
+	}
+	return users
+}
+
+// loadPopulation reads users from path, depending on its extension:
+//   - .json: an array of keys, or of objects with a "key" and attributes
+//   - .csv: a header row with a "key" column, other columns are attributes
+//   - otherwise one key per line, skipping blank lines and # comments
+func loadPopulation(path string) ([]user, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read population file: %w", err)
+	}
+
+	var cohort []map[string]any
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		cohort, err = parseJSONCohort(data)
+	case ".csv":
+		cohort, err = parseCSVCohort(data)
+	default:
+		scanner := bufio.NewScanner(strings.NewReader(string(data)))
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if line != "" && !strings.HasPrefix(line, "#") {
+				cohort = append(cohort, map[string]any{"key": line})
+			}
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse population file %s: %w", path, err)
+	}
+	if len(cohort) == 0 {
+		return nil, fmt.Errorf("population file %s has no users", path)
+	}
+
+	users := make([]user, len(cohort))
+	seen := make(map[string]bool, len(cohort))
+	for i, entry := range cohort {
+		key, _ := entry["key"].(string)
+		if key == "" {
+			return nil, fmt.Errorf("population file %s: user %d has no key", path, i)
+		}
+		if seen[key] {
+			return nil, fmt.Errorf("population file %s: duplicate key %q", path, key)
+		}
+		seen[key] = true
+		delete(entry, "key")
+		if len(entry) == 0 {
+			entry = nil
+		}
+		users[i] = newUser(i, key, entry)
+	}
+	return users, nil
+}
+
+func parseJSONCohort(data []byte) ([]map[string]any, error) {
+	var keys []string
+	if err := json.Unmarshal(data, &keys); err == nil {
+		cohort := make([]map[string]any, len(keys))
+		for i, key := range keys {
+			cohort[i] = map[string]any{"key": key}
+		}
+		return cohort, nil
+	}
+	var cohort []map[string]any
+	if err := json.Unmarshal(data, &cohort); err != nil {
+		return nil, err
+	}
+	return cohort, nil
+}
+
+func parseCSVCohort(data []byte) ([]map[string]any, error) {
+	records, err := csv.NewReader(strings.NewReader(string(data))).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 || !slices.Contains(records[0], "key") {
+		return nil, fmt.Errorf(`missing header row with a "key" column`)
+	}
+	header := records[0]
+	cohort := make([]map[string]any, 0, len(records)-1)
+	for _, record := range records[1:] {
+		entry := make(map[string]any, len(header))
+		for j, column := range header {
+			// Empty cells leave the attribute unset
+			if record[j] == "" {
+				continue
+			}
+			if column == "key" {
+				entry[column] = record[j]
+			} else {
+				entry[column] = parseValue(record[j])
+			}
+		}
+		cohort = append(cohort, entry)
+	}
+	return cohort, nil
+}
+
+// parseValue turns "true" and "false" into booleans, so that rules such as
+// beta eq true match.
+func parseValue(s string) any {
+	if b, err := strconv.ParseBool(s); err == nil && (s == "true" || s == "false") {
+		return b
+	}
+	return s
+}
+
+func userName(i int) string {
+	return fmt.Sprintf("user%d", i)
+}
+
+// attributeNames returns the attribute names present in the population.
+func attributeNames(users []user) []string {
+	names := make(map[string]bool)
+	for _, u := range users {
+		for name := range u.Attributes {
+			names[name] = true
+		}
+	}
+	return slices.Sorted(maps.Keys(names))
+}
+
+// gridCell is one user in the grid, with a tooltip listing its attributes.
+type gridCell struct {
+	Name  string
+	Title string
+}
+
+// gridGroup is a block of rows. Label is empty unless the grid is grouped.
+type gridGroup struct {
+	Label string
+	Count int
+	Rows  [][]gridCell
+}
+
+// gridGroups splits users into rows of gridColumns cells. With groupBy set,
+// users are first grouped by the value of that attribute, in order of first
+// appearance, and users without it come last.
+func gridGroups(users []user, groupBy string) []gridGroup {
+	if groupBy == "" {
+		return []gridGroup{{Count: len(users), Rows: gridRows(users)}}
+	}
+
+	var labels []string
+	members := make(map[string][]user)
+	for _, u := range users {
+		label := fmt.Sprintf("%s = %v", groupBy, u.Attributes[groupBy])
+		if _, ok := u.Attributes[groupBy]; !ok {
+			label = "no " + groupBy
+		}
+		if _, ok := members[label]; !ok {
+			labels = append(labels, label)
+		}
+		members[label] = append(members[label], u)
+	}
+	// Keep the group of users without the attribute at the end
+	if i := slices.Index(labels, "no "+groupBy); i >= 0 {
+		labels = append(slices.Delete(labels, i, i+1), "no "+groupBy)
+	}
+
+	groups := make([]gridGroup, len(labels))
+	for i, label := range labels {
+		groups[i] = gridGroup{Label: label, Count: len(members[label]), Rows: gridRows(members[label])}
+	}
+	return groups
+}
+
+// groupLink is one entry of the "group by" menu of the grid.
+type groupLink struct {
+	Label   string
+	Href    string
+	Current bool
+}
+
+// groupLinks returns the "group by" menu, none then one entry per attribute,
+// or nothing if users have no attributes. Links keep the parameters of query.
+func groupLinks(query url.Values, attributes []string, groupBy string) []groupLink {
+	if len(attributes) == 0 {
+		return nil
+	}
+	q := url.Values{}
+	maps.Copy(q, query)
+	q.Del("groupBy")
+	links := []groupLink{{Label: "none", Href: "?" + q.Encode(), Current: groupBy == ""}}
+	for _, name := range attributes {
+		q.Set("groupBy", name)
+		links = append(links, groupLink{Label: name, Href: "?" + q.Encode(), Current: name == groupBy})
+	}
+	return links
+}
+
+func gridRows(users []user) [][]gridCell {
+	var rows [][]gridCell
+	for i := 0; i < len(users); i += gridColumns {
+		row := make([]gridCell, 0, gridColumns)
+		for _, u := range users[i:min(i+gridColumns, len(users))] {
+			row = append(row, gridCell{Name: u.Name, Title: cellTitle(u)})
+		}
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+// cellTitle is the tooltip of a cell: the user's name, key and attributes.
+func cellTitle(u user) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s (%s)", u.Name, u.Context.GetKey())
+	for _, name := range slices.Sorted(maps.Keys(u.Attributes)) {
+		fmt.Fprintf(&b, "\n%s: %v", name, u.Attributes[name])
+	}
+	return b.String()
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const splitFlags = `
+color-box:
+  variations:
+    red_var: red
+    green_var: green
+    grey_var: grey
+    default_var: grey
+  defaultRule:
+    percentage:
+      red_var: 50
+      green_var: 20
+      grey_var: 10
+      default_var: 10
+`
+
+// population returns n users per color, all served by the default rule.
+func population(n map[string]int) map[string]splitOutcome {
+	outcomes := map[string]splitOutcome{}
+	for color, count := range n {
+		for i := 0; i < count; i++ {
+			outcomes[fmt.Sprintf("%s%d", color, i)] = splitOutcome{Color: color, Reason: "SPLIT"}
+		}
+	}
+	return outcomes
+}
+
+func TestParseSplit(t *testing.T) {
+	split, err := parseSplit([]byte(splitFlags), "color-box")
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []splitVariation{
+		{Color: "red", Variations: []string{"red_var"}, Weight: 50},
+		{Color: "green", Variations: []string{"green_var"}, Weight: 20},
+		{Color: "grey", Variations: []string{"default_var", "grey_var"}, Weight: 20},
+	}, split)
+
+	_, err = parseSplit([]byte("color-box:\n  defaultRule:\n    variation: red_var\n"), "color-box")
+	assert.ErrorIs(t, err, errNoSplit)
+	_, err = parseSplit([]byte(splitFlags), "other")
+	assert.ErrorContains(t, err, "not in the flag file")
+	_, err = parseSplit([]byte("color-box:\n  defaultRule:\n    percentage:\n      blue_var: 100\n"), "color-box")
+	assert.ErrorContains(t, err, "unknown variation")
+}
+
+func TestParseSplit_BooleanFlag(t *testing.T) {
+	split, err := parseSplit([]byte("dark-mode:\n  variations:\n    enabled: true\n    disabled: false\n  defaultRule:\n    percentage:\n      enabled: 30\n      disabled: 70\n"), "dark-mode")
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []splitVariation{
+		{Color: "on", Variations: []string{"enabled"}, Weight: 30},
+		{Color: "off", Variations: []string{"disabled"}, Weight: 70},
+	}, split, "booleans are named like their grid cells")
+}
+
+func TestCompareSplit_NormalisesAndTests(t *testing.T) {
+	split, err := parseSplit([]byte(splitFlags), "color-box")
+	require.NoError(t, err)
+
+	// Weights sum to 90: red is expected at 50/90
+	report := compareSplit(split, population(map[string]int{"red": 560, "green": 220, "grey": 220}))
+	assert.Equal(t, 90.0, report.WeightsTotal)
+	assert.Equal(t, []string{"percentages sum to 90, not 100"}, report.Warnings)
+	require.Len(t, report.Variations, 3)
+	red := report.Variations[0]
+	assert.Equal(t, "red", red.Color)
+	assert.Equal(t, 55.56, red.ExpectedPercentage)
+	assert.Equal(t, 56.0, red.ObservedPercentage)
+	assert.Equal(t, 0.44, red.Deviation)
+	assert.Equal(t, 2, report.DegreesOfFreedom)
+	assert.True(t, report.Fits)
+
+	// A skewed split does not fit
+	report = compareSplit(split, population(map[string]int{"red": 700, "green": 150, "grey": 150}))
+	assert.False(t, report.Fits)
+	assert.Less(t, report.PValue, significance)
+}
+
+func TestCompareSplit_ColorsOutsideSplit(t *testing.T) {
+	split := []splitVariation{{Color: "red", Variations: []string{"red_var"}, Weight: 100}}
+	report := compareSplit(split, population(map[string]int{"red": 90, "blue": 10}))
+	assert.Equal(t, []string{"10 users got blue, which is not in the split"}, report.Warnings)
+	assert.Equal(t, 0, report.DegreesOfFreedom)
+	assert.Equal(t, 1.0, report.PValue)
+	assert.Equal(t, "blue", report.Variations[1].Color)
+	assert.Equal(t, 10, report.Variations[1].Observed)
+}
+
+func TestCompareSplit_OnlyTestsDefaultRuleUsers(t *testing.T) {
+	split := []splitVariation{
+		{Color: "red", Variations: []string{"red_var"}, Weight: 50},
+		{Color: "green", Variations: []string{"green_var"}, Weight: 50},
+	}
+	outcomes := population(map[string]int{"red": 500, "green": 500})
+	// A targeting rule serving red to 300 more users would skew the test
+	for i := 0; i < 300; i++ {
+		outcomes[fmt.Sprintf("beta%d", i)] = splitOutcome{Color: "red", Reason: "TARGETING_MATCH"}
+	}
+	outcomes["broken"] = splitOutcome{Color: "grey", Reason: "ERROR"}
+
+	report := compareSplit(split, outcomes)
+	assert.Equal(t, 1301, report.Users)
+	assert.Equal(t, 1000, report.Tested)
+	assert.Equal(t, []string{
+		"1 users got their color with the reason ERROR and are not tested",
+		"300 users got their color with the reason TARGETING_MATCH and are not tested",
+	}, report.Warnings)
+	require.Len(t, report.Variations, 2)
+	assert.Equal(t, 500, report.Variations[0].Observed)
+	assert.Equal(t, 50.0, report.Variations[0].ObservedPercentage)
+	assert.True(t, report.Fits)
+}
+
+func TestChiSquaredSurvival(t *testing.T) {
+	// Critical values at the 5% level
+	assert.InDelta(t, 0.05, chiSquaredSurvival(3.841, 1), 1e-4)
+	assert.InDelta(t, 0.05, chiSquaredSurvival(5.991, 2), 1e-4)
+	assert.InDelta(t, 0.05, chiSquaredSurvival(18.307, 10), 1e-4)
+	assert.InDelta(t, 0.5, chiSquaredSurvival(0.4549, 1), 1e-4)
+	assert.Equal(t, 1.0, chiSquaredSurvival(0, 3))
+}
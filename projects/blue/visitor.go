@@ -0,0 +1,102 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"github.com/thomaspoignant/go-feature-flag/ffcontext"
+)
+
+// visitorCookie holds the identity of a browser, so that a visitor keeps
+// their variations from one visit to the next.
+const visitorCookie = "webcolor_visitor"
+
+// visitorCookieAge is how long a browser keeps its identity.
+const visitorCookieAge = 365 * 24 * time.Hour
+
+// visitorInfo is who the page is rendered for and the variations they get.
+type visitorInfo struct {
+	Key          string             `json:"key"`
+	User         string             `json:"user,omitempty"` // grid cell, if the key is in the population
+	Impersonated bool               `json:"impersonated"`
+	Attributes   map[string]any     `json:"attributes,omitempty"`
+	Variations   []visitorVariation `json:"variations"`
+}
+
+// visitorVariation is the variation of one flag for the visitor.
+type visitorVariation struct {
+	Flag  string `json:"flag"`
+	Color string `json:"color"`
+	Error string `json:"error,omitempty"`
+}
+
+// visitorKey returns the key of the visitor: the one given with ?as=, which
+// lets QA impersonate a user, or else the browser's own, which is created on
+// its first visit.
+func visitorKey(c echo.Context) (key string, impersonated bool) {
+	if as := c.QueryParam("as"); as != "" {
+		return as, true
+	}
+	if cookie, err := c.Cookie(visitorCookie); err == nil && cookie.Value != "" {
+		return cookie.Value, false
+	}
+	key = uuid.NewString()
+	c.SetCookie(&http.Cookie{
+		Name:     visitorCookie,
+		Value:    key,
+		Path:     "/",
+		MaxAge:   int(visitorCookieAge.Seconds()),
+		HttpOnly: true,
+		Secure:   c.Scheme() == "https",
+		SameSite: http.SameSiteLaxMode,
+	})
+	return key, false
+}
+
+// lookupUser finds a user of the population by grid name (user42) or key.
+func lookupUser(ref string) (user, bool) {
+	for _, u := range users {
+		if u.Name == ref || u.Context.GetKey() == ref {
+			return u, true
+		}
+	}
+	return user{}, false
+}
+
+// describeVisitor evaluates flags for the visitor of the request. A visitor
+// who is in the population, which is how ?as= is mostly used, is evaluated
+// with that user's attributes, and so gets the color of their grid cell.
+func describeVisitor(c echo.Context, flags []string) visitorInfo {
+	key, impersonated := visitorKey(c)
+	info := visitorInfo{Key: key, Impersonated: impersonated}
+	var ctx ffcontext.Context = ffcontext.NewEvaluationContext(key)
+	if u, ok := lookupUser(key); ok {
+		info.Key = u.Context.GetKey()
+		info.User = u.Name
+		info.Attributes = u.Attributes
+		ctx = u.Context
+	}
+
+	for _, flag := range flags {
+		v := visitorVariation{Flag: flag}
+		evaluate, err := cellEvaluator(flag)
+		if err == nil {
+			v.Color, err = evaluate(ctx)
+		}
+		if err != nil {
+			log.Printf("Feature flag evaluation error for visitor %s: %v", info.Key, err)
+			v.Error = err.Error()
+		}
+		info.Variations = append(info.Variations, v)
+	}
+	return info
+}
+
+// meHandler returns the variations of the visitor for the flags given with
+// ?flag=, as the visitor's own browser or as the user given with ?as=.
+func meHandler(c echo.Context) error {
+	return c.JSON(http.StatusOK, describeVisitor(c, requestedFlags(c)))
+}
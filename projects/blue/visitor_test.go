@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	"html/template"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVisitorKey_SetsCookieOnce(t *testing.T) {
+	e := echo.New()
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	rec := httptest.NewRecorder()
+	key, impersonated := visitorKey(e.NewContext(req, rec))
+	assert.False(t, impersonated)
+	require.NotEmpty(t, key)
+	cookies := rec.Result().Cookies()
+	require.Len(t, cookies, 1)
+	assert.Equal(t, visitorCookie, cookies[0].Name)
+	assert.Equal(t, key, cookies[0].Value)
+	assert.True(t, cookies[0].HttpOnly)
+
+	// The browser sends its identity back
+	req = httptest.NewRequest(http.MethodGet, "/", nil)
+	req.AddCookie(cookies[0])
+	rec = httptest.NewRecorder()
+	again, _ := visitorKey(e.NewContext(req, rec))
+	assert.Equal(t, key, again)
+	assert.Empty(t, rec.Result().Cookies(), "a known browser keeps its cookie")
+
+	// ?as= wins over the cookie, and leaves it alone
+	req = httptest.NewRequest(http.MethodGet, "/?as=qa-1", nil)
+	req.AddCookie(cookies[0])
+	rec = httptest.NewRecorder()
+	as, impersonated := visitorKey(e.NewContext(req, rec))
+	assert.Equal(t, "qa-1", as)
+	assert.True(t, impersonated)
+	assert.Empty(t, rec.Result().Cookies())
+}
+
+func TestDescribeVisitor_ImpersonatesPopulationUser(t *testing.T) {
+	saved := users
+	defer func() { users = saved }()
+	users = []user{
+		newUser(0, "alice", map[string]any{"country": "FR"}),
+		newUser(1, "bob", map[string]any{"country": "US"}),
+	}
+
+	e := echo.New()
+	for _, ref := range []string{"bob", "user1"} {
+		req := httptest.NewRequest(http.MethodGet, "/?as="+ref, nil)
+		info := describeVisitor(e.NewContext(req, httptest.NewRecorder()), nil)
+		assert.Equal(t, visitorInfo{
+			Key:          "bob",
+			User:         "user1",
+			Impersonated: true,
+			Attributes:   map[string]any{"country": "US"},
+		}, info, ref)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/?as=carol", nil)
+	info := describeVisitor(e.NewContext(req, httptest.NewRecorder()), nil)
+	assert.Equal(t, "carol", info.Key)
+	assert.Empty(t, info.User, "an unknown key is evaluated without attributes")
+}
+
+func TestTemplate_RendersVisitor(t *testing.T) {
+	tmpl := template.Must(template.ParseGlob("assets/view/*.html"))
+	users := []user{newUser(0, "a", nil), newUser(1, "b", nil)}
+	var out bytes.Buffer
+	require.NoError(t, tmpl.ExecuteTemplate(&out, "template.html", PageData{
+		Panels: []gridPanel{{Flag: "color-box", Users: map[string]string{"user0": "red", "user1": "grey"}}},
+		Groups: gridGroups(users, ""),
+		Visitor: visitorInfo{
+			Key:          "b",
+			User:         "user1",
+			Impersonated: true,
+			Variations:   []visitorVariation{{Flag: "color-box", Color: "grey"}},
+		},
+	}))
+	assert.Contains(t, out.String(), "Viewing as <strong>b</strong> (user1)")
+	assert.Contains(t, out.String(), `<span class="swatch grey"></span> color-box <strong>grey</strong>`)
+	assert.Contains(t, out.String(), `title="user1 (b)" aria-current="true">`)
+	assert.NotContains(t, out.String(), `title="user0 (a)" aria-current`)
+}
@@ -2,31 +2,40 @@ package main
 
 import (
 	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"html/template"
 	"io"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
+	"os/signal"
 	"runtime"
+	"slices"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/davidaparicio/microsvcs/projects/blue/internal/name"
 	"github.com/davidaparicio/microsvcs/projects/blue/internal/version"
-	"github.com/google/uuid"
 	"github.com/labstack/echo/v4"
 	ffclient "github.com/thomaspoignant/go-feature-flag"
-	"github.com/thomaspoignant/go-feature-flag/ffcontext"
-	"github.com/thomaspoignant/go-feature-flag/retriever/fileretriever"
+	"github.com/thomaspoignant/go-feature-flag/notifier"
 )
 
-var users = make(map[string]ffcontext.EvaluationContext, 2500)
+// users is the population shown in the grid, in grid order.
+var users []user
+
+var metrics = newMetrics(ffclient.GetCacheRefreshDate)
 
 // PageData holds all data to be rendered in the template
 type PageData struct {
-	Users      map[string]string
+	Panels     []gridPanel // one grid per requested flag
+	Groups     []gridGroup // layout shared by every panel
+	GroupLinks []groupLink
+	Visitor    visitorInfo // who the page is rendered for
 	SystemInfo SystemInfo
 }
 
@@ -47,43 +56,113 @@ type SystemInfo struct {
 func main() {
 	version.PrintVersion()
 
-	configFile := flag.String("configFile", "./demo-flags.goff.yaml", "flags.goff.yaml")
+	var source retrieverConfig
+	flag.StringVar(&source.Kind, "retriever", "file", "where to read the flag config: file, http, github, gitlab or configmap")
+	flag.StringVar(&source.File, "configFile", "./demo-flags.goff.yaml", "flags.goff.yaml")
+	flag.StringVar(&source.URL, "httpURL", "", "URL of the flag config, for -retriever=http")
+	flag.StringVar(&source.Repo, "gitRepo", "", "repository holding the flag config, as owner/name, for -retriever=github or gitlab")
+	flag.StringVar(&source.Branch, "gitBranch", "main", "branch of -gitRepo")
+	flag.StringVar(&source.Path, "gitPath", "", "path of the flag config in -gitRepo")
+	flag.StringVar(&source.BaseURL, "gitlabURL", "https://gitlab.com", "GitLab instance, for -retriever=gitlab")
+	flag.StringVar(&source.ConfigMap, "configMap", "", "ConfigMap holding the flag config, for -retriever=configmap")
+	flag.StringVar(&source.Key, "configMapKey", "demo-flags.goff.yaml", "key of the flag config in -configMap")
+	flag.StringVar(&source.Namespace, "configMapNamespace", "", "namespace of -configMap (default: the pod's)")
+	flag.DurationVar(&source.Timeout, "retrieverTimeout", 10*time.Second, "timeout of one read of the flag config, except from a file")
+	pollingInterval := flag.Duration("pollingInterval", time.Second, "how often the flag config is read")
+	populationSize := flag.Int("users", 2500, "number of generated users")
+	seed := flag.Int64("seed", 1, "seed for the generated user keys; replicas with the same seed show the same grid")
+	attributes := flag.String("attributes", defaultAttributes, "distributions of the generated user attributes, as name=value:weight,...;name=... (empty for none)")
+	usersFile := flag.String("usersFile", "", "file with the users: keys one per line, a JSON array of keys or objects, or a CSV with a key column (overrides -users, -seed and -attributes)")
+	flag.StringVar(&defaultFlag, "flag", defaultFlag, "flag rendered when a request does not name one with ?flag=")
 	flag.Parse()
 
+	if *pollingInterval < time.Second {
+		log.Fatalf("-pollingInterval must be at least 1s, got %s", *pollingInterval)
+	}
+	if source.Namespace == "" {
+		source.Namespace = name.GetNamespace()
+	}
+	retriever, err := source.retriever()
+	if err != nil {
+		log.Fatalf("Invalid flag config source: %v", err)
+	}
+	config := &recordingRetriever{Retriever: retriever}
+	fmt.Printf("Reading flags from %s every %s.\n", source, *pollingInterval)
+
+	grids := newGridRegistry(evaluateFlag)
 	if err := ffclient.Init(ffclient.Config{
-		PollingInterval: 1 * time.Second,
+		PollingInterval: *pollingInterval,
 		Context:         context.Background(),
-		Retriever: &fileretriever.Retriever{
-			Path: *configFile,
-		},
+		Retriever:       config,
+		Notifiers:       []notifier.Notifier{grids},
 	}); err != nil {
 		log.Fatalf("Failed to initialize feature flag client: %v", err)
 	}
 
 	e := echo.New()
 	e.HideBanner = true
+	e.Use(metrics.middleware())
 	e.Static("/js", "assets/js")
 	e.Static("/css", "assets/css")
 	// Instantiate a template registry and register all html files inside the view folder
 	e.Renderer = &TemplateRegistry{templates: template.Must(template.ParseGlob("assets/view/*.html"))}
 
 	// init users
-	for i := 0; i < 2500; i++ {
-		id := uuid.New()
-		u := ffcontext.NewEvaluationContext(id.String())
-		users[fmt.Sprintf("user%d", i)] = u
+	if *usersFile != "" {
+		var err error
+		if users, err = loadPopulation(*usersFile); err != nil {
+			log.Fatalf("Failed to load users: %v", err)
+		}
+	} else {
+		if *populationSize < 1 {
+			log.Fatalf("-users must be at least 1, got %d", *populationSize)
+		}
+		dists, err := parseDistributions(*attributes)
+		if err != nil {
+			log.Fatalf("Invalid -attributes: %v", err)
+		}
+		users = generatePopulation(*populationSize, *seed, dists)
+	}
+	fmt.Printf("Evaluating %s for %d users.\n", defaultFlag, len(users))
+	if _, err := grids.get(defaultFlag); err != nil {
+		log.Printf("Failed to evaluate the default flag: %v", err)
 	}
 
 	e.GET("/", apiHandler)
+	e.GET("/flags", flagsHandler)
 	e.GET("/version", versionHandler)
 	e.GET("/healthz", healthzHandler)
+	e.GET("/metrics", metrics.handler())
+	e.GET("/events", eventsHandler(grids))
+	e.GET("/api/colors", colorsHandler)
+	e.GET("/api/colors/summary", colorsSummaryHandler)
+	e.GET("/api/split", splitHandler(config.last))
+	e.GET("/api/me", meHandler)
+	e.GET("/api/explain", explainHandler)
 
 	port := os.Getenv("PORT")
 	if port == "" {
 		port = "8080"
 	}
 	fmt.Printf("Starting HTTP server (%s/%s) listening on port %s.\n", runtime.GOOS, runtime.GOARCH, port)
-	e.Logger.Fatal(e.Start(":" + port))
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		if err := e.Start(":" + port); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			e.Logger.Fatal(err)
+		}
+	}()
+	<-ctx.Done()
+
+	fmt.Println("Shutting down.")
+	// /events streams never end on their own, Shutdown would wait on them
+	grids.close()
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := e.Shutdown(shutdownCtx); err != nil {
+		log.Printf("Failed to shut down the HTTP server: %v", err)
+	}
+	ffclient.Close()
 }
 
 type TemplateRegistry struct {
@@ -114,17 +193,49 @@ func getCircle(color string) string {
 }
 
 func apiHandler(c echo.Context) error {
-	// Get user color variations
-	mapToRender := make(map[string]string, 2500)
-	for k, user := range users {
-		color, err := ffclient.StringVariation("color-box", user, "grey")
+	start := time.Now()
+
+	// Get the variations of every requested flag
+	flags := requestedFlags(c)
+	panels := make([]gridPanel, 0, len(flags))
+	for _, key := range flags {
+		colors, err := evaluateFlag(key)
 		if err != nil {
-			log.Printf("Feature flag evaluation error for %s: %v", k, err)
+			return echo.NewHTTPError(flagErrorStatus(err), err.Error())
 		}
-		mapToRender[k] = color
+		panels = append(panels, gridPanel{Flag: key, Users: colors})
 	}
+	metrics.renderDuration.Observe(time.Since(start).Seconds())
 
-	// Get system information
+	// Group the grid by a user attribute, to see targeting rules at work
+	attributes := attributeNames(users)
+	groupBy := c.QueryParam("groupBy")
+	if !slices.Contains(attributes, groupBy) {
+		groupBy = ""
+	}
+
+	// The group by links keep the requested flags
+	query := url.Values{}
+	if c.QueryParams().Has("flag") {
+		query["flag"] = flags
+	}
+	if as := c.QueryParam("as"); as != "" {
+		query.Set("as", as)
+	}
+
+	pageData := PageData{
+		Panels:     panels,
+		Groups:     gridGroups(users, groupBy),
+		GroupLinks: groupLinks(query, attributes, groupBy),
+		Visitor:    describeVisitor(c, flags),
+		SystemInfo: systemInfo(c),
+	}
+
+	return c.Render(http.StatusOK, "template.html", pageData)
+}
+
+// systemInfo describes the pod serving the request.
+func systemInfo(c echo.Context) SystemInfo {
 	hostname := name.GetHostname()
 	namespace := name.GetNamespace()
 	displayName := ""
@@ -136,7 +247,7 @@ func apiHandler(c echo.Context) error {
 	podColor := strings.SplitN(hostname, "-", 2)[0]
 	circles := getCircle(namespace) + getCircle(podColor)
 
-	sysInfo := SystemInfo{
+	return SystemInfo{
 		DisplayName:    displayName,
 		OS:             runtime.GOOS,
 		Arch:           runtime.GOARCH,
@@ -148,13 +259,6 @@ func apiHandler(c echo.Context) error {
 		ServiceVersion: version.Version,
 		ServiceCommit:  version.GitCommit,
 	}
-
-	pageData := PageData{
-		Users:      mapToRender,
-		SystemInfo: sysInfo,
-	}
-
-	return c.Render(http.StatusOK, "template.html", pageData)
 }
 
 func versionHandler(c echo.Context) error {
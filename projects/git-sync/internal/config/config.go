@@ -2,45 +2,240 @@ package config
 
 import (
 	"fmt"
+	"net/url"
 	"os"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
 )
 
 type Config struct {
+	// Source settings
+	SourceType  string // SOURCE_TYPE (git, http, s3 or oci, default: git)
+	SourceURL   string // SOURCE_URL (archive URL, s3://bucket/prefix or registry/repo:tag for non-git sources)
+	S3Endpoint  string // S3_ENDPOINT (host[:port] of the S3 API, default: s3.amazonaws.com)
+	S3Region    string // S3_REGION (bucket region, default: discovered)
+	S3Insecure  bool   // S3_INSECURE (use plain HTTP, e.g. for a local MinIO, default: false)
+	OCIInsecure bool   // OCI_INSECURE (allow a plain HTTP registry, default: false)
+
 	// Git repository settings
-	RepoURL    string // GIT_REPO_URL
-	Branch     string // GIT_BRANCH (default: main)
-	SourcePath string // GIT_SOURCE_PATH (path within repo, default: /)
+	RepoURL       string // GIT_REPO_URL
+	Branch        string // GIT_BRANCH (default: main)
+	BranchPattern string // GIT_BRANCH_PATTERN (glob such as flags/*; syncs each match into TARGET_PATH/<branch>/, default: disabled)
+	SourcePath    string // GIT_SOURCE_PATH (path within repo, default: /)
+
+	// HTTPS remote settings, for private CAs and corporate proxies
+	GitCABundle              string   // GIT_CA_BUNDLE (PEM file of CAs trusted in addition to the system pool)
+	GitClientCert            string   // GIT_CLIENT_CERT (PEM client certificate for mTLS, with GIT_CLIENT_KEY)
+	GitClientKey             string   // GIT_CLIENT_KEY (PEM private key of GIT_CLIENT_CERT)
+	GitProxy                 string   // GIT_PROXY (http:// or https:// proxy URL, default: HTTPS_PROXY/HTTP_PROXY from the environment)
+	GitNoProxy               []string // GIT_NO_PROXY (comma-separated hosts, .domains or CIDRs reached without GIT_PROXY)
+	GitInsecureSkipTLSVerify bool     // GIT_INSECURE_SKIP_TLS_VERIFY (do not verify the remote's certificate, for debugging only, default: false)
 
 	// File system settings
 	TargetPath string // TARGET_PATH (where to write files)
 
+	// Render settings
+	Environment        string   // SYNC_ENVIRONMENT (publish name.<env>.ext as name.ext, default: disabled)
+	Environments       []string // SYNC_ENVIRONMENTS (comma-separated, default: development,staging,production)
+	TemplateEnabled    bool     // TEMPLATE_ENABLED (expand *.tmpl files as Go templates, default: false)
+	TemplateValuesFile string   // TEMPLATE_VALUES_FILE (YAML file exposed to templates as .Values)
+	SymlinkPolicy      string   // SYMLINK_POLICY (dereference, preserve or refuse, default: dereference)
+	AgeKeyFile         string   // SOPS_AGE_KEY_FILE (age keys, file or mounted Secret directory; enables decryption)
+
 	// Sync settings
 	SyncInterval string // SYNC_INTERVAL (cron format, default: "*/5 * * * *" = every 5 min)
 	SyncOnce     bool   // SYNC_ONCE (run once and exit, default: false)
+	DryRun       bool   // DRY_RUN (report pending changes without writing, default: false)
+
+	// Resource limits, checked before anything is published (0 disables a limit)
+	MaxFetchSize int64 // MAX_FETCH_SIZE (checkout size on disk, default: 100Mi)
+	MaxFiles     int   // MAX_FILES (number of published files, default: 5000)
+	MaxFileSize  int64 // MAX_FILE_SIZE (size of a single published file, default: 5Mi)
+
+	// Drift settings
+	DriftPolicy   string // DRIFT_POLICY (off, report or restore, default: report)
+	DriftInterval string // DRIFT_CHECK_INTERVAL (cron format, default: "@every 30s")
+
+	// Restart settings, for consumers that only read config at startup
+	RestartTargets     []string      // RESTART_TARGETS (comma-separated [namespace/]kind/name, default: disabled)
+	RestartNamespace   string        // RESTART_NAMESPACE (namespace of targets without one, default: the pod's namespace)
+	RestartWatchPaths  []string      // RESTART_WATCH_PATHS (comma-separated published paths that trigger a restart, default: all)
+	RestartMinInterval time.Duration // RESTART_MIN_INTERVAL (minimum time between restarts of a workload, default: 5m)
+
+	// Rollback settings
+	SnapshotRetention int   // SNAPSHOT_RETENTION (published trees kept for rollback, default: 5)
+	SnapshotMaxBytes  int64 // SNAPSHOT_MAX_BYTES (file data of the retained trees, the current one aside, 0 disables, default: 32Mi)
 
 	// Server settings
-	Port string // PORT (default: 8080)
+	Port              string // PORT (default: 8080)
+	FileServerEnabled bool   // FILE_SERVER_ENABLED (serve the published tree under /files/, default: false)
+	AdminToken        string // ADMIN_TOKEN (bearer token for POST endpoints, which are disabled when empty)
+	EventBufferSize   int    // EVENT_BUFFER_SIZE (events kept for /events clients resuming with Last-Event-ID, default: 256)
+	TracesExporter    string // OTEL_TRACES_EXPORTER (where sync spans go: otlp, console or none, default: none)
 }
 
 func LoadFromEnv() *Config {
 	return &Config{
-		RepoURL:      os.Getenv("GIT_REPO_URL"),
-		Branch:       getEnvOrDefault("GIT_BRANCH", "main"),
-		SourcePath:   getEnvOrDefault("GIT_SOURCE_PATH", "/"),
-		TargetPath:   getEnvOrDefault("TARGET_PATH", "/data"),
+		SourceType:  getEnvOrDefault("SOURCE_TYPE", "git"),
+		SourceURL:   os.Getenv("SOURCE_URL"),
+		S3Endpoint:  getEnvOrDefault("S3_ENDPOINT", "s3.amazonaws.com"),
+		S3Region:    os.Getenv("S3_REGION"),
+		S3Insecure:  os.Getenv("S3_INSECURE") == "true",
+		OCIInsecure: os.Getenv("OCI_INSECURE") == "true",
+
+		RepoURL:       os.Getenv("GIT_REPO_URL"),
+		Branch:        getEnvOrDefault("GIT_BRANCH", "main"),
+		BranchPattern: os.Getenv("GIT_BRANCH_PATTERN"),
+		SourcePath:    getEnvOrDefault("GIT_SOURCE_PATH", "/"),
+		TargetPath:    getEnvOrDefault("TARGET_PATH", "/data"),
+
+		GitCABundle:              os.Getenv("GIT_CA_BUNDLE"),
+		GitClientCert:            os.Getenv("GIT_CLIENT_CERT"),
+		GitClientKey:             os.Getenv("GIT_CLIENT_KEY"),
+		GitProxy:                 os.Getenv("GIT_PROXY"),
+		GitNoProxy:               splitList(os.Getenv("GIT_NO_PROXY")),
+		GitInsecureSkipTLSVerify: os.Getenv("GIT_INSECURE_SKIP_TLS_VERIFY") == "true",
+
+		Environment:        os.Getenv("SYNC_ENVIRONMENT"),
+		Environments:       splitList(getEnvOrDefault("SYNC_ENVIRONMENTS", "development,staging,production")),
+		TemplateEnabled:    os.Getenv("TEMPLATE_ENABLED") == "true",
+		TemplateValuesFile: os.Getenv("TEMPLATE_VALUES_FILE"),
+		SymlinkPolicy:      getEnvOrDefault("SYMLINK_POLICY", "dereference"),
+		AgeKeyFile:         os.Getenv("SOPS_AGE_KEY_FILE"),
+
 		SyncInterval: getEnvOrDefault("SYNC_INTERVAL", "*/5 * * * *"),
 		SyncOnce:     os.Getenv("SYNC_ONCE") == "true",
-		Port:         getEnvOrDefault("PORT", "8080"),
+		DryRun:       os.Getenv("DRY_RUN") == "true",
+
+		MaxFetchSize: getEnvSizeOrDefault("MAX_FETCH_SIZE", 100<<20),
+		MaxFiles:     getEnvIntOrDefault("MAX_FILES", 5000),
+		MaxFileSize:  getEnvSizeOrDefault("MAX_FILE_SIZE", 5<<20),
+
+		DriftPolicy:   getEnvOrDefault("DRIFT_POLICY", "report"),
+		DriftInterval: getEnvOrDefault("DRIFT_CHECK_INTERVAL", "@every 30s"),
+
+		RestartTargets:     splitList(os.Getenv("RESTART_TARGETS")),
+		RestartNamespace:   os.Getenv("RESTART_NAMESPACE"),
+		RestartWatchPaths:  splitList(os.Getenv("RESTART_WATCH_PATHS")),
+		RestartMinInterval: getEnvDurationOrDefault("RESTART_MIN_INTERVAL", 5*time.Minute),
+
+		SnapshotRetention: getEnvIntOrDefault("SNAPSHOT_RETENTION", 5),
+		SnapshotMaxBytes:  getEnvSizeOrDefault("SNAPSHOT_MAX_BYTES", 32<<20),
+
+		Port:              getEnvOrDefault("PORT", "8080"),
+		FileServerEnabled: os.Getenv("FILE_SERVER_ENABLED") == "true",
+		AdminToken:        os.Getenv("ADMIN_TOKEN"),
+		EventBufferSize:   getEnvIntOrDefault("EVENT_BUFFER_SIZE", 256),
+		TracesExporter:    getEnvOrDefault("OTEL_TRACES_EXPORTER", "none"),
 	}
 }
 
 func (c *Config) Validate() error {
-	if c.RepoURL == "" {
-		return fmt.Errorf("GIT_REPO_URL is required")
+	switch c.SourceType {
+	case "", "git":
+		if c.RepoURL == "" {
+			return fmt.Errorf("GIT_REPO_URL is required")
+		}
+	case "http", "s3", "oci":
+		if c.SourceURL == "" {
+			return fmt.Errorf("SOURCE_URL is required when SOURCE_TYPE is %s", c.SourceType)
+		}
+	default:
+		return fmt.Errorf("SOURCE_TYPE must be one of git, http, s3 or oci, got %q", c.SourceType)
 	}
 	if c.TargetPath == "" {
 		return fmt.Errorf("TARGET_PATH is required")
 	}
+	if c.BranchPattern != "" {
+		if err := c.validateBranchPattern(); err != nil {
+			return err
+		}
+	}
+	if err := c.validateRemoteTLS(); err != nil {
+		return err
+	}
+	if c.Environment != "" && !slices.Contains(c.Environments, c.Environment) {
+		return fmt.Errorf("SYNC_ENVIRONMENT %q is not listed in SYNC_ENVIRONMENTS", c.Environment)
+	}
+	switch c.SymlinkPolicy {
+	case "", "dereference", "preserve", "refuse":
+	default:
+		return fmt.Errorf("SYMLINK_POLICY must be one of dereference, preserve or refuse, got %q", c.SymlinkPolicy)
+	}
+	if c.MaxFetchSize < 0 {
+		return fmt.Errorf("MAX_FETCH_SIZE must be a size such as 100Mi or 500000")
+	}
+	if c.MaxFiles < 0 {
+		return fmt.Errorf("MAX_FILES must be a number such as 5000")
+	}
+	if c.MaxFileSize < 0 {
+		return fmt.Errorf("MAX_FILE_SIZE must be a size such as 5Mi or 500000")
+	}
+	if c.RestartMinInterval < 0 {
+		return fmt.Errorf("RESTART_MIN_INTERVAL must be a duration such as 5m or 30s")
+	}
+	if c.SnapshotRetention < 1 {
+		return fmt.Errorf("SNAPSHOT_RETENTION must be a number of at least 1, such as 5")
+	}
+	if c.SnapshotMaxBytes < 0 {
+		return fmt.Errorf("SNAPSHOT_MAX_BYTES must be a size such as 32Mi or 500000")
+	}
+	if c.EventBufferSize < 1 {
+		return fmt.Errorf("EVENT_BUFFER_SIZE must be a number of at least 1, such as 256")
+	}
+	switch c.TracesExporter {
+	case "", "none", "otlp", "console":
+	default:
+		return fmt.Errorf("OTEL_TRACES_EXPORTER must be one of otlp, console or none, got %q", c.TracesExporter)
+	}
+	switch c.DriftPolicy {
+	case "", "off", "report", "restore":
+	default:
+		return fmt.Errorf("DRIFT_POLICY must be one of off, report or restore, got %q", c.DriftPolicy)
+	}
+	return nil
+}
+
+// validateBranchPattern checks multi-branch mode against the features that
+// assume a single published tree.
+func (c *Config) validateBranchPattern() error {
+	if _, err := path.Match(c.BranchPattern, ""); err != nil {
+		return fmt.Errorf("GIT_BRANCH_PATTERN %q is not a valid glob: %w", c.BranchPattern, err)
+	}
+	switch {
+	case c.SourceType != "" && c.SourceType != "git":
+		return fmt.Errorf("GIT_BRANCH_PATTERN requires SOURCE_TYPE git, got %q", c.SourceType)
+	case c.DryRun:
+		return fmt.Errorf("GIT_BRANCH_PATTERN cannot be combined with DRY_RUN")
+	case len(c.RestartTargets) > 0:
+		return fmt.Errorf("GIT_BRANCH_PATTERN cannot be combined with RESTART_TARGETS")
+	case c.FileServerEnabled:
+		return fmt.Errorf("GIT_BRANCH_PATTERN cannot be combined with FILE_SERVER_ENABLED")
+	}
+	return nil
+}
+
+// validateRemoteTLS checks the HTTPS remote settings. The files themselves
+// are read on every fetch, so rotated certificates apply without a restart.
+func (c *Config) validateRemoteTLS() error {
+	if (c.GitClientCert == "") != (c.GitClientKey == "") {
+		return fmt.Errorf("GIT_CLIENT_CERT and GIT_CLIENT_KEY must be set together")
+	}
+	if c.GitInsecureSkipTLSVerify && c.GitCABundle != "" {
+		return fmt.Errorf("GIT_INSECURE_SKIP_TLS_VERIFY and GIT_CA_BUNDLE are mutually exclusive")
+	}
+	if c.GitProxy != "" {
+		u, err := url.Parse(c.GitProxy)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("GIT_PROXY must be an http:// or https:// URL")
+		}
+	}
+	if len(c.GitNoProxy) > 0 && c.GitProxy == "" {
+		return fmt.Errorf("GIT_NO_PROXY requires GIT_PROXY (use NO_PROXY with the environment's proxy)")
+	}
 	return nil
 }
 
@@ -50,3 +245,72 @@ func getEnvOrDefault(key, defaultValue string) string {
 	}
 	return defaultValue
 }
+
+// getEnvIntOrDefault parses a non-negative integer. Invalid values yield -1
+// so Validate can report them.
+func getEnvIntOrDefault(key string, defaultValue int) int {
+	v := os.Getenv(key)
+	if v == "" {
+		return defaultValue
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		return -1
+	}
+	return n
+}
+
+// getEnvDurationOrDefault parses a Go duration. Invalid values yield -1 so
+// Validate can report them.
+func getEnvDurationOrDefault(key string, defaultValue time.Duration) time.Duration {
+	v := os.Getenv(key)
+	if v == "" {
+		return defaultValue
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d < 0 {
+		return -1
+	}
+	return d
+}
+
+// getEnvSizeOrDefault parses a byte size with an optional Ki, Mi or Gi
+// suffix. Invalid values yield -1 so Validate can report them.
+func getEnvSizeOrDefault(key string, defaultValue int64) int64 {
+	v := os.Getenv(key)
+	if v == "" {
+		return defaultValue
+	}
+	n, err := ParseSize(v)
+	if err != nil {
+		return -1
+	}
+	return n
+}
+
+// ParseSize parses a byte size such as "500000", "512Ki", "100Mi" or "1Gi".
+func ParseSize(v string) (int64, error) {
+	multiplier := int64(1)
+	for suffix, m := range map[string]int64{"Ki": 1 << 10, "Mi": 1 << 20, "Gi": 1 << 30} {
+		if strings.HasSuffix(v, suffix) {
+			v, multiplier = strings.TrimSuffix(v, suffix), m
+			break
+		}
+	}
+	n, err := strconv.ParseInt(strings.TrimSpace(v), 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", v)
+	}
+	return n * multiplier, nil
+}
+
+// splitList parses a comma-separated value, dropping blanks.
+func splitList(v string) []string {
+	var out []string
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			out = append(out, item)
+		}
+	}
+	return out
+}
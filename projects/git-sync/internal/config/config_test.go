@@ -0,0 +1,35 @@
+package config_test
+
+import (
+	"testing"
+
+	"github.com/davidaparicio/microsvcs/projects/git-sync/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidate_RejectsUnparsableNumbers(t *testing.T) {
+	for _, key := range []string{"MAX_FILES", "SNAPSHOT_RETENTION", "EVENT_BUFFER_SIZE"} {
+		for _, value := range []string{"5k", "1.5", "-3"} {
+			t.Run(key+"="+value, func(t *testing.T) {
+				t.Setenv("GIT_REPO_URL", "https://github.com/davidaparicio/microsvcs.git")
+				t.Setenv("TARGET_PATH", t.TempDir())
+				t.Setenv(key, value)
+
+				err := config.LoadFromEnv().Validate()
+				assert.ErrorContains(t, err, key+" must be a number")
+			})
+		}
+	}
+}
+
+func TestValidate_Defaults(t *testing.T) {
+	t.Setenv("GIT_REPO_URL", "https://github.com/davidaparicio/microsvcs.git")
+	t.Setenv("TARGET_PATH", t.TempDir())
+
+	c := config.LoadFromEnv()
+	require.NoError(t, c.Validate())
+	assert.Equal(t, 5000, c.MaxFiles)
+	assert.Equal(t, 5, c.SnapshotRetention)
+	assert.Equal(t, 256, c.EventBufferSize)
+}
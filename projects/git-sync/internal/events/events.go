@@ -0,0 +1,163 @@
+// Package events broadcasts the sync lifecycle to live subscribers and keeps
+// a short history so reconnecting clients can catch up.
+package events
+
+import (
+	"sync"
+	"time"
+)
+
+// Event types published by the syncer.
+const (
+	SyncStarted      = "sync.started"
+	SyncSucceeded    = "sync.succeeded"
+	SyncFailed       = "sync.failed"
+	SyncNoop         = "sync.noop"
+	ValidationFailed = "validation.failed"
+)
+
+// DefaultBufferSize is the history kept when no size is configured.
+const DefaultBufferSize = 256
+
+// subscriberQueue is how many events a subscriber may lag behind before it
+// is dropped. A dropped client reconnects and replays from the buffer.
+const subscriberQueue = 64
+
+// Event is one entry of the stream. IDs increase by one per event and
+// restart at 1 when the process does.
+type Event struct {
+	ID   uint64    `json:"id"`
+	Type string    `json:"type"`
+	Time time.Time `json:"time"`
+	Data any       `json:"data"`
+}
+
+// Started is the payload of sync.started.
+type Started struct {
+	Source string `json:"source"`
+	URL    string `json:"url"`
+	Ref    string `json:"ref,omitempty"`
+}
+
+// Succeeded is the payload of sync.succeeded, sent when a publish or a
+// rollback changed the target.
+type Succeeded struct {
+	Ref          string   `json:"ref,omitempty"`
+	OldCommit    string   `json:"oldCommit,omitempty"`
+	NewCommit    string   `json:"newCommit"`
+	ChangedFiles []string `json:"changedFiles"`
+	Rollback     bool     `json:"rollback,omitempty"`
+}
+
+// Noop is the payload of sync.noop, sent when a sync left the target as it
+// was. Reason is "unchanged", "pinned" or "dry-run".
+type Noop struct {
+	Ref    string `json:"ref,omitempty"`
+	Commit string `json:"commit"`
+	Reason string `json:"reason"`
+}
+
+// Failed is the payload of sync.failed and validation.failed.
+type Failed struct {
+	Ref    string `json:"ref,omitempty"`
+	Commit string `json:"commit,omitempty"`
+	Class  string `json:"class"`
+	Error  string `json:"error"`
+}
+
+// Bus fans events out to subscribers and retains the most recent ones.
+// The zero value is not usable; call NewBus.
+type Bus struct {
+	mu     sync.Mutex
+	buf    []Event // oldest first, at most size entries
+	size   int
+	lastID uint64
+	subs   map[chan Event]struct{}
+	closed bool
+	now    func() time.Time
+}
+
+// NewBus returns a bus retaining size events, or DefaultBufferSize if size
+// is not positive.
+func NewBus(size int) *Bus {
+	if size < 1 {
+		size = DefaultBufferSize
+	}
+	return &Bus{size: size, subs: map[chan Event]struct{}{}, now: time.Now}
+}
+
+// Publish records an event and delivers it to every subscriber. It never
+// blocks: a subscriber whose queue is full is disconnected.
+func (b *Bus) Publish(typ string, data any) Event {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.lastID++
+	ev := Event{ID: b.lastID, Type: typ, Time: b.now(), Data: data}
+	if len(b.buf) == b.size {
+		b.buf = append(b.buf[:0], b.buf[1:]...)
+	}
+	b.buf = append(b.buf, ev)
+
+	for ch := range b.subs {
+		select {
+		case ch <- ev:
+		default:
+			delete(b.subs, ch)
+			close(ch)
+		}
+	}
+	return ev
+}
+
+// Subscribe returns the buffered events after lastID and a channel of the
+// events that follow. An ID the bus never issued, as sent by a client that
+// outlived a restart, replays the whole buffer. The channel is closed when
+// cancel is called or the subscriber falls too far behind.
+func (b *Bus) Subscribe(lastID uint64) (backlog []Event, ch <-chan Event, cancel func()) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if lastID > b.lastID {
+		lastID = 0
+	}
+	for _, ev := range b.buf {
+		if ev.ID > lastID {
+			backlog = append(backlog, ev)
+		}
+	}
+
+	c := make(chan Event, subscriberQueue)
+	if b.closed {
+		close(c)
+		return backlog, c, func() {}
+	}
+	b.subs[c] = struct{}{}
+	return backlog, c, func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		if _, ok := b.subs[c]; ok {
+			delete(b.subs, c)
+			close(c)
+		}
+	}
+}
+
+// Close ends every subscription, so streaming handlers return and the HTTP
+// server can shut down without waiting for clients to hang up.
+func (b *Bus) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.closed = true
+	for ch := range b.subs {
+		delete(b.subs, ch)
+		close(ch)
+	}
+}
+
+// Subscribers reports how many clients are connected.
+func (b *Bus) Subscribers() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.subs)
+}
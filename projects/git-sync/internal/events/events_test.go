@@ -0,0 +1,174 @@
+package events_test
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/davidaparicio/microsvcs/projects/git-sync/internal/events"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func ids(evs []events.Event) []uint64 {
+	var out []uint64
+	for _, ev := range evs {
+		out = append(out, ev.ID)
+	}
+	return out
+}
+
+func TestBus_ReplaysFromBuffer(t *testing.T) {
+	bus := events.NewBus(3)
+	for range 5 {
+		bus.Publish(events.SyncStarted, nil)
+	}
+
+	backlog, _, cancel := bus.Subscribe(0)
+	cancel()
+	assert.Equal(t, []uint64{3, 4, 5}, ids(backlog), "only the last 3 are kept")
+
+	backlog, _, cancel = bus.Subscribe(4)
+	cancel()
+	assert.Equal(t, []uint64{5}, ids(backlog))
+
+	backlog, _, cancel = bus.Subscribe(5)
+	cancel()
+	assert.Empty(t, backlog)
+
+	// An ID from before a restart of git-sync replays everything
+	backlog, _, cancel = bus.Subscribe(900)
+	cancel()
+	assert.Equal(t, []uint64{3, 4, 5}, ids(backlog))
+}
+
+func TestBus_DeliversAndDropsSlowSubscribers(t *testing.T) {
+	bus := events.NewBus(0)
+	_, ch, cancel := bus.Subscribe(0)
+	defer cancel()
+
+	bus.Publish(events.SyncNoop, events.Noop{Commit: "abc", Reason: "unchanged"})
+	ev := <-ch
+	assert.Equal(t, events.SyncNoop, ev.Type)
+	assert.Equal(t, uint64(1), ev.ID)
+
+	// Publishing never blocks on a subscriber that stopped reading
+	for range 200 {
+		bus.Publish(events.SyncStarted, nil)
+	}
+	assert.Equal(t, 0, bus.Subscribers())
+	n := 0
+	for range ch {
+		n++
+	}
+	assert.Less(t, n, 200)
+}
+
+// stream opens /events on srv and returns a reader over the response body.
+func stream(t *testing.T, url, lastID string) *bufio.Reader {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url+"/events", nil)
+	require.NoError(t, err)
+	if lastID != "" {
+		req.Header.Set("Last-Event-ID", lastID)
+	}
+	res, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = res.Body.Close() })
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, "text/event-stream", res.Header.Get("Content-Type"))
+	return bufio.NewReader(res.Body)
+}
+
+// next reads one event from an SSE stream, skipping comments and retry hints.
+func next(t *testing.T, r *bufio.Reader) (id, typ string, ev events.Event) {
+	t.Helper()
+	for {
+		line, err := r.ReadString('\n')
+		require.NoError(t, err)
+		line = strings.TrimSuffix(line, "\n")
+		switch {
+		case strings.HasPrefix(line, "id: "):
+			id = strings.TrimPrefix(line, "id: ")
+		case strings.HasPrefix(line, "event: "):
+			typ = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			require.NoError(t, json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &ev))
+		case line == "" && typ != "":
+			return id, typ, ev
+		}
+	}
+}
+
+func TestHandler_StreamsAndResumes(t *testing.T) {
+	bus := events.NewBus(0)
+	e := echo.New()
+	e.GET("/events", events.Handler(bus))
+	srv := httptest.NewServer(e)
+	defer srv.Close()
+	defer bus.Close() // lets the open streams end before srv.Close waits on them
+
+	bus.Publish(events.SyncStarted, events.Started{Source: "git", URL: "https://example.com/repo.git", Ref: "main"})
+	bus.Publish(events.SyncSucceeded, events.Succeeded{NewCommit: "abc", ChangedFiles: []string{"flags.yaml"}})
+
+	r := stream(t, srv.URL, "")
+	id, typ, _ := next(t, r)
+	assert.Equal(t, "1", id)
+	assert.Equal(t, events.SyncStarted, typ)
+	id, typ, ev := next(t, r)
+	assert.Equal(t, "2", id)
+	assert.Equal(t, events.SyncSucceeded, typ)
+	assert.Equal(t, map[string]any{"newCommit": "abc", "changedFiles": []any{"flags.yaml"}}, ev.Data)
+
+	// Live events follow the backlog
+	require.Eventually(t, func() bool { return bus.Subscribers() == 1 }, time.Second, 10*time.Millisecond)
+	bus.Publish(events.SyncFailed, events.Failed{Class: "fetch", Error: "connection refused"})
+	id, typ, _ = next(t, r)
+	assert.Equal(t, "3", id)
+	assert.Equal(t, events.SyncFailed, typ)
+
+	// A reconnecting client only gets what it missed
+	r = stream(t, srv.URL, "2")
+	id, typ, _ = next(t, r)
+	assert.Equal(t, "3", id)
+	assert.Equal(t, events.SyncFailed, typ)
+}
+
+func TestHandler_RejectsInvalidLastEventID(t *testing.T) {
+	e := echo.New()
+	e.GET("/events", events.Handler(events.NewBus(0)))
+
+	req := httptest.NewRequest(http.MethodGet, "/events?lastEventId=latest", nil)
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+}
+
+func TestHandler_ReturnsWhenBusCloses(t *testing.T) {
+	bus := events.NewBus(0)
+	e := echo.New()
+	e.GET("/events", events.Handler(bus))
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		e.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/events", nil))
+	}()
+	require.Eventually(t, func() bool { return bus.Subscribers() == 1 }, time.Second, 10*time.Millisecond)
+
+	bus.Close()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("handler kept streaming after Close")
+	}
+}
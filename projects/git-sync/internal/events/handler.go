@@ -0,0 +1,99 @@
+package events
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// keepAlive is how often an idle stream gets a comment line, so proxies
+// and the ingress do not time the connection out.
+var keepAlive = 15 * time.Second
+
+// retryMillis is the reconnection delay suggested to EventSource clients.
+const retryMillis = 3000
+
+// Handler streams events as server-sent events. A client resuming with a
+// Last-Event-ID header (or ?lastEventId= where headers cannot be set) first
+// receives the buffered events it missed.
+func Handler(bus *Bus) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		lastID, err := lastEventID(c.Request())
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+		}
+
+		backlog, ch, cancel := bus.Subscribe(lastID)
+		defer cancel()
+
+		res := c.Response()
+		res.Header().Set(echo.HeaderContentType, "text/event-stream")
+		res.Header().Set(echo.HeaderCacheControl, "no-cache")
+		res.Header().Set("X-Accel-Buffering", "no")
+		res.WriteHeader(http.StatusOK)
+
+		if _, err := fmt.Fprintf(res, "retry: %d\n\n", retryMillis); err != nil {
+			return nil
+		}
+		for _, ev := range backlog {
+			if err := write(res, ev); err != nil {
+				return nil
+			}
+		}
+		res.Flush()
+
+		ticker := time.NewTicker(keepAlive)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-c.Request().Context().Done():
+				return nil
+			case ev, ok := <-ch:
+				if !ok {
+					// Too slow to keep up; the client reconnects and replays
+					return nil
+				}
+				if err := write(res, ev); err != nil {
+					return nil
+				}
+			case <-ticker.C:
+				if _, err := io.WriteString(res, ": keep-alive\n\n"); err != nil {
+					return nil
+				}
+			}
+			res.Flush()
+		}
+	}
+}
+
+// lastEventID reads the ID to resume after, 0 for a fresh stream.
+func lastEventID(r *http.Request) (uint64, error) {
+	v := r.Header.Get("Last-Event-ID")
+	if v == "" {
+		v = r.URL.Query().Get("lastEventId")
+	}
+	if v == "" {
+		return 0, nil
+	}
+	id, err := strconv.ParseUint(v, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid Last-Event-ID %q", v)
+	}
+	return id, nil
+}
+
+// write encodes ev in the text/event-stream format. The data field is the
+// whole event as a single JSON line, so it needs no splitting.
+func write(w io.Writer, ev Event) error {
+	data, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", ev.ID, ev.Type, data)
+	return err
+}
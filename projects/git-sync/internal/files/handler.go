@@ -0,0 +1,238 @@
+// Package files serves published trees read-only over HTTP, so consumers
+// can fetch config from a central git-sync instead of sharing a volume.
+package files
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/davidaparicio/microsvcs/projects/git-sync/internal/publish"
+	"github.com/davidaparicio/microsvcs/projects/git-sync/internal/sync"
+	"github.com/labstack/echo/v4"
+)
+
+// Trees gives access to the published tree and retained snapshots.
+type Trees interface {
+	Tree(commit string) (*publish.Manifest, []publish.File, error)
+}
+
+// CommitHeader names the commit a response was served from.
+const CommitHeader = "X-Git-Sync-Commit"
+
+// maxLinkHops bounds link resolution; preserved links are already checked
+// to stay inside the tree, this only guards against cycles.
+const maxLinkHops = 8
+
+// Entry is one item of a directory listing.
+type Entry struct {
+	Name   string `json:"name"`
+	Type   string `json:"type"` // "file", "dir" or "link"
+	Size   int64  `json:"size,omitempty"`
+	SHA256 string `json:"sha256,omitempty"`
+	Link   string `json:"link,omitempty"`
+}
+
+// Listing is the JSON body returned for a directory.
+type Listing struct {
+	Path    string  `json:"path"`
+	Commit  string  `json:"commit"`
+	Entries []Entry `json:"entries"`
+}
+
+// Handler serves GET and HEAD requests under a wildcard route. Files carry
+// an ETag derived from their content hash; directories are listed as JSON.
+// ?commit= serves from a retained snapshot. Decrypted secrets are never
+// served or listed.
+func Handler(trees Trees) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		commit := c.QueryParam("commit")
+		m, files, err := trees.Tree(commit)
+		switch {
+		case errors.Is(err, sync.ErrAmbiguousSnapshot):
+			return c.JSON(http.StatusConflict, map[string]string{"error": err.Error()})
+		case errors.Is(err, sync.ErrSnapshotNotFound) && commit == "":
+			return c.JSON(http.StatusServiceUnavailable, map[string]string{"error": err.Error()})
+		case err != nil:
+			return c.JSON(http.StatusNotFound, map[string]string{"error": err.Error()})
+		}
+
+		rel, err := url.PathUnescape(c.Param("*"))
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid path"})
+		}
+		rel = strings.TrimPrefix(path.Clean("/"+rel), "/")
+
+		t := newTree(m, files)
+		rel, f, isDir, ok := t.resolve(rel)
+		if !ok {
+			return c.JSON(http.StatusNotFound, map[string]string{"error": "not found: /" + rel})
+		}
+
+		h := c.Response().Header()
+		h.Set(CommitHeader, m.Commit)
+		h.Set("Cache-Control", "no-cache")
+		modified := lastModified(m)
+		h.Set("Last-Modified", modified.Format(http.TimeFormat))
+
+		if isDir {
+			body, err := json.Marshal(t.list(rel))
+			if err != nil {
+				return err
+			}
+			sum := sha256.Sum256(body)
+			if notModified(c, `"`+hex.EncodeToString(sum[:])+`"`, modified) {
+				return c.NoContent(http.StatusNotModified)
+			}
+			return c.JSONBlob(http.StatusOK, body)
+		}
+
+		if notModified(c, `"`+m.Files[f.Path].SHA256+`"`, modified) {
+			return c.NoContent(http.StatusNotModified)
+		}
+		return c.Blob(http.StatusOK, contentType(f), f.Data)
+	}
+}
+
+// tree indexes the servable files of a snapshot.
+type tree struct {
+	manifest *publish.Manifest
+	files    map[string]publish.File
+}
+
+func newTree(m *publish.Manifest, files []publish.File) *tree {
+	t := &tree{manifest: m, files: make(map[string]publish.File, len(files))}
+	for _, f := range files {
+		if !f.Secret {
+			t.files[f.Path] = f
+		}
+	}
+	return t
+}
+
+// resolve finds rel, following preserved links. It returns the resolved
+// path and the file, or isDir when it names a directory.
+func (t *tree) resolve(rel string) (resolved string, f publish.File, isDir, ok bool) {
+	for range maxLinkHops {
+		if rel == "" || t.isDir(rel) {
+			return rel, publish.File{}, true, true
+		}
+		f, ok = t.files[rel]
+		switch {
+		case ok && f.Link == "":
+			return rel, f, false, true
+		case ok:
+			rel = path.Join(path.Dir(rel), f.Link)
+		default:
+			// A parent directory may be a link (current -> releases/v2)
+			if rel, ok = t.throughLink(rel); !ok {
+				return "", publish.File{}, false, false
+			}
+		}
+	}
+	return "", publish.File{}, false, false
+}
+
+// throughLink rewrites rel when one of its parent directories is a link.
+func (t *tree) throughLink(rel string) (string, bool) {
+	parts := strings.Split(rel, "/")
+	for i := 1; i < len(parts); i++ {
+		dir := strings.Join(parts[:i], "/")
+		if f, ok := t.files[dir]; ok && f.Link != "" {
+			return path.Join(path.Dir(dir), f.Link, strings.Join(parts[i:], "/")), true
+		}
+	}
+	return "", false
+}
+
+func (t *tree) isDir(rel string) bool {
+	for p := range t.files {
+		if strings.HasPrefix(p, rel+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// list returns the direct children of dir.
+func (t *tree) list(dir string) Listing {
+	prefix := ""
+	if dir != "" {
+		prefix = dir + "/"
+	}
+
+	entries := []Entry{}
+	seen := map[string]bool{}
+	for p, f := range t.files {
+		rest, ok := strings.CutPrefix(p, prefix)
+		if !ok {
+			continue
+		}
+		if name, _, nested := strings.Cut(rest, "/"); nested {
+			if !seen[name] {
+				seen[name] = true
+				entries = append(entries, Entry{Name: name, Type: "dir"})
+			}
+			continue
+		}
+		if f.Link != "" {
+			entries = append(entries, Entry{Name: rest, Type: "link", Link: f.Link})
+			continue
+		}
+		e := t.manifest.Files[p]
+		entries = append(entries, Entry{Name: rest, Type: "file", Size: e.Size, SHA256: e.SHA256})
+	}
+	slices.SortFunc(entries, func(a, b Entry) int { return strings.Compare(a.Name, b.Name) })
+
+	return Listing{Path: "/" + prefix, Commit: t.manifest.Commit, Entries: entries}
+}
+
+// lastModified is the time the tree was published. Not the commit time: a
+// rollback publishes an older commit, which clients must not take as
+// unchanged since the newer one.
+func lastModified(m *publish.Manifest) time.Time {
+	return m.SyncTime
+}
+
+// notModified sets the ETag and evaluates the request's conditional
+// headers. If-None-Match takes precedence over If-Modified-Since (RFC 9110).
+func notModified(c echo.Context, etag string, modified time.Time) bool {
+	c.Response().Header().Set("ETag", etag)
+
+	req := c.Request()
+	if inm := req.Header.Get("If-None-Match"); inm != "" {
+		for _, candidate := range strings.Split(inm, ",") {
+			candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+			if candidate == etag || candidate == "*" {
+				return true
+			}
+		}
+		return false
+	}
+	if ims, err := http.ParseTime(req.Header.Get("If-Modified-Since")); err == nil {
+		return !modified.Truncate(time.Second).After(ims)
+	}
+	return false
+}
+
+func contentType(f publish.File) string {
+	switch ext := path.Ext(f.Path); ext {
+	case ".yaml", ".yml":
+		return "application/yaml"
+	case ".json":
+		return "application/json"
+	default:
+		if t := mime.TypeByExtension(ext); t != "" {
+			return t
+		}
+	}
+	return http.DetectContentType(f.Data)
+}
@@ -0,0 +1,219 @@
+package files_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/davidaparicio/microsvcs/projects/git-sync/internal/files"
+	"github.com/davidaparicio/microsvcs/projects/git-sync/internal/publish"
+	"github.com/davidaparicio/microsvcs/projects/git-sync/internal/sync"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// snapshots is a fake Trees: the last entry is the current tree.
+type snapshots []struct {
+	manifest *publish.Manifest
+	files    []publish.File
+}
+
+func (s snapshots) Tree(commit string) (*publish.Manifest, []publish.File, error) {
+	if len(s) == 0 {
+		return nil, nil, fmt.Errorf("%w: nothing published yet", sync.ErrSnapshotNotFound)
+	}
+	if commit == "" {
+		last := s[len(s)-1]
+		return last.manifest, last.files, nil
+	}
+	var found []int
+	for i, sn := range s {
+		if strings.HasPrefix(sn.manifest.Commit, commit) {
+			found = append(found, i)
+		}
+	}
+	switch len(found) {
+	case 0:
+		return nil, nil, fmt.Errorf("%w: %s", sync.ErrSnapshotNotFound, commit)
+	case 1:
+		return s[found[0]].manifest, s[found[0]].files, nil
+	}
+	return nil, nil, fmt.Errorf("%w: %s", sync.ErrAmbiguousSnapshot, commit)
+}
+
+var commitTime = time.Date(2026, 1, 29, 10, 0, 0, 0, time.UTC)
+
+func (s *snapshots) add(commit string, fs ...publish.File) {
+	m := publish.NewManifest(publish.Metadata{Commit: commit, CommitTime: commitTime}, fs)
+	*s = append(*s, struct {
+		manifest *publish.Manifest
+		files    []publish.File
+	}{m, fs})
+}
+
+func file(p, content string) publish.File {
+	return publish.File{Path: p, Mode: 0644, Data: []byte(content)}
+}
+
+func newServer(trees files.Trees) *echo.Echo {
+	e := echo.New()
+	e.GET("/files/*", files.Handler(trees))
+	e.HEAD("/files/*", files.Handler(trees))
+	return e
+}
+
+func get(t *testing.T, e *echo.Echo, target string, header ...string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodGet, target, nil)
+	for i := 0; i+1 < len(header); i += 2 {
+		req.Header.Set(header[i], header[i+1])
+	}
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	return rec
+}
+
+func testTrees() snapshots {
+	var s snapshots
+	s.add("1111111aaaa", file("flags.yaml", "v1"))
+	s.add("2222222bbbb",
+		file("flags.yaml", "v2"),
+		file("nested/app.json", `{"a":1}`),
+		file("nested/deeper/x.txt", "x"),
+		publish.File{Path: "alias.yaml", Link: "flags.yaml"},
+		publish.File{Path: "current", Link: "nested"},
+		publish.File{Path: "secrets.yaml", Mode: publish.SecretMode, Data: []byte("apiKey: s3cr3t"), Secret: true},
+	)
+	return s
+}
+
+func TestHandler_ServesFileWithETag(t *testing.T) {
+	trees := testTrees()
+	e := newServer(trees)
+
+	rec := get(t, e, "/files/flags.yaml")
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "v2", rec.Body.String())
+	assert.Equal(t, "application/yaml", rec.Header().Get("Content-Type"))
+	assert.Equal(t, "2222222bbbb", rec.Header().Get(files.CommitHeader))
+	m, _, _ := trees.Tree("")
+	assert.Equal(t, m.SyncTime.Format(http.TimeFormat), rec.Header().Get("Last-Modified"))
+
+	etag := rec.Header().Get("ETag")
+	assert.Equal(t, `"`+m.Files["flags.yaml"].SHA256+`"`, etag)
+
+	assert.Equal(t, http.StatusNotModified, get(t, e, "/files/flags.yaml", "If-None-Match", etag).Code)
+	assert.Equal(t, http.StatusNotModified, get(t, e, "/files/flags.yaml", "If-None-Match", `"other", W/`+etag).Code)
+	assert.Equal(t, http.StatusOK, get(t, e, "/files/flags.yaml", "If-None-Match", `"other"`).Code)
+
+	assert.Equal(t, http.StatusNotModified,
+		get(t, e, "/files/flags.yaml", "If-Modified-Since", m.SyncTime.Format(http.TimeFormat)).Code)
+	assert.Equal(t, http.StatusOK,
+		get(t, e, "/files/flags.yaml", "If-Modified-Since", m.SyncTime.Add(-time.Hour).Format(http.TimeFormat)).Code)
+}
+
+func TestHandler_RollbackIsModified(t *testing.T) {
+	var trees snapshots
+	trees.add("2222222bbbb", file("flags.yaml", "v2"))
+	trees[0].manifest.SyncTime = commitTime.Add(time.Hour)
+	e := newServer(&trees)
+	seen := get(t, e, "/files/flags.yaml").Header().Get("Last-Modified")
+
+	// Rolling back republishes an older commit, later
+	trees.add("1111111aaaa", file("flags.yaml", "v1"))
+	trees[1].manifest.CommitTime = commitTime.Add(-24 * time.Hour)
+	trees[1].manifest.SyncTime = commitTime.Add(2 * time.Hour)
+
+	rec := get(t, e, "/files/flags.yaml", "If-Modified-Since", seen)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "v1", rec.Body.String())
+}
+
+func TestHandler_DirectoryListing(t *testing.T) {
+	e := newServer(testTrees())
+
+	rec := get(t, e, "/files/")
+	require.Equal(t, http.StatusOK, rec.Code)
+	var root files.Listing
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &root))
+	assert.Equal(t, "/", root.Path)
+	assert.Equal(t, "2222222bbbb", root.Commit)
+
+	names := map[string]string{}
+	for _, entry := range root.Entries {
+		names[entry.Name] = entry.Type
+	}
+	assert.Equal(t, map[string]string{
+		"alias.yaml": "link",
+		"current":    "link",
+		"flags.yaml": "file",
+		"nested":     "dir",
+	}, names, "secrets are never listed")
+
+	etag := rec.Header().Get("ETag")
+	require.NotEmpty(t, etag)
+	assert.Equal(t, http.StatusNotModified, get(t, e, "/files/", "If-None-Match", etag).Code)
+
+	rec = get(t, e, "/files/nested")
+	require.Equal(t, http.StatusOK, rec.Code)
+	var nested files.Listing
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &nested))
+	assert.Equal(t, "/nested/", nested.Path)
+	assert.Equal(t, []files.Entry{
+		{Name: "app.json", Type: "file", Size: 7, SHA256: nested.Entries[0].SHA256},
+		{Name: "deeper", Type: "dir"},
+	}, nested.Entries)
+}
+
+func TestHandler_FollowsLinks(t *testing.T) {
+	e := newServer(testTrees())
+
+	rec := get(t, e, "/files/alias.yaml")
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "v2", rec.Body.String())
+
+	rec = get(t, e, "/files/current/app.json")
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, `{"a":1}`, rec.Body.String())
+
+	rec = get(t, e, "/files/current")
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), `"path":"/nested/"`)
+}
+
+func TestHandler_NotFound(t *testing.T) {
+	e := newServer(testTrees())
+
+	for _, p := range []string{"/files/missing.yaml", "/files/secrets.yaml", "/files/../../etc/passwd", "/files/%2e%2e/etc/passwd"} {
+		t.Run(p, func(t *testing.T) {
+			rec := get(t, e, p)
+			assert.Equal(t, http.StatusNotFound, rec.Code)
+			assert.NotContains(t, rec.Body.String(), "s3cr3t")
+		})
+	}
+}
+
+func TestHandler_CommitQuery(t *testing.T) {
+	trees := testTrees()
+	trees.add("2222222cccc", file("flags.yaml", "v3"))
+	e := newServer(trees)
+
+	rec := get(t, e, "/files/flags.yaml?commit=1111111")
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "v1", rec.Body.String())
+	assert.Equal(t, "1111111aaaa", rec.Header().Get(files.CommitHeader))
+
+	assert.Equal(t, http.StatusNotFound, get(t, e, "/files/nested/app.json?commit=1111111").Code)
+	assert.Equal(t, http.StatusNotFound, get(t, e, "/files/flags.yaml?commit=deadbeef").Code)
+	assert.Equal(t, http.StatusConflict, get(t, e, "/files/flags.yaml?commit=2222222").Code)
+}
+
+func TestHandler_NothingPublished(t *testing.T) {
+	e := newServer(snapshots{})
+	assert.Equal(t, http.StatusServiceUnavailable, get(t, e, "/files/flags.yaml").Code)
+}
@@ -0,0 +1,63 @@
+package git
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/davidaparicio/microsvcs/projects/git-sync/internal/config"
+	"github.com/go-git/go-git/v5"
+	gitconfig "github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/storage/memory"
+)
+
+// Branch is a branch head advertised by a remote.
+type Branch struct {
+	Name string // without the refs/heads/ prefix
+	Hash string
+}
+
+// ListBranches returns the branches of cfg.RepoURL whose names match
+// cfg.BranchPattern, sorted by name. The pattern uses path.Match syntax, so
+// "flags/*" matches flags/new-color but not flags/team/new-color.
+func ListBranches(ctx context.Context, cfg *config.Config) ([]Branch, error) {
+	pattern := cfg.BranchPattern
+	if _, err := path.Match(pattern, ""); err != nil {
+		return nil, fmt.Errorf("invalid branch pattern %q: %w", pattern, err)
+	}
+
+	insecureWarning(cfg)
+	opts, err := loadRemoteOptions(cfg)
+	if err != nil {
+		return nil, err
+	}
+	remote := git.NewRemote(memory.NewStorage(), &gitconfig.RemoteConfig{
+		Name: "origin",
+		URLs: []string{cfg.RepoURL},
+	})
+	refs, err := remote.ListContext(ctx, &git.ListOptions{
+		CABundle:        opts.caBundle,
+		ClientCert:      opts.clientCert,
+		ClientKey:       opts.clientKey,
+		InsecureSkipTLS: opts.insecureSkipTLS,
+		ProxyOptions:    opts.proxy,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list remote branches: %w", err)
+	}
+
+	var branches []Branch
+	for _, ref := range refs {
+		if !ref.Name().IsBranch() {
+			continue
+		}
+		name := ref.Name().Short()
+		if ok, _ := path.Match(pattern, name); ok {
+			branches = append(branches, Branch{Name: name, Hash: ref.Hash().String()})
+		}
+	}
+	slices.SortFunc(branches, func(a, b Branch) int { return strings.Compare(a.Name, b.Name) })
+	return branches, nil
+}
@@ -3,13 +3,32 @@ package git
 import (
 	"context"
 	"fmt"
+	"io/fs"
+	"net"
 	"os"
+	"path/filepath"
+	"time"
 
 	"github.com/davidaparicio/microsvcs/projects/git-sync/internal/config"
+	"github.com/davidaparicio/microsvcs/projects/git-sync/internal/tracing"
 	"github.com/go-git/go-git/v5"
 	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.41.0"
+	"go.opentelemetry.io/otel/trace"
 )
 
+var tracer = otel.Tracer("github.com/davidaparicio/microsvcs/projects/git-sync/internal/git")
+
+// Commit describes the revision checked out by the last Sync.
+type Commit struct {
+	Hash   string
+	Author string
+	Time   time.Time
+}
+
 type Client struct {
 	cfg     *config.Config
 	workDir string
@@ -35,57 +54,163 @@ func NewClient(cfg *config.Config) (*Client, error) {
 	}, nil
 }
 
-func (c *Client) Sync(ctx context.Context) (string, error) {
+func (c *Client) Sync(ctx context.Context) (Commit, error) {
 	if c.repo == nil {
 		return c.clone(ctx)
 	}
 	return c.pull(ctx)
 }
 
-func (c *Client) clone(ctx context.Context) (string, error) {
+// clone fetches the branch without a worktree and checks it out
+// separately, so traces show the two phases apart.
+func (c *Client) clone(ctx context.Context) (Commit, error) {
 	fmt.Printf("Cloning repository: %s (branch: %s)\n", c.cfg.RepoURL, c.cfg.Branch)
+	insecureWarning(c.cfg)
 
-	repo, err := git.PlainCloneContext(ctx, c.workDir, false, &git.CloneOptions{
-		URL:           c.cfg.RepoURL,
-		ReferenceName: plumbing.NewBranchReferenceName(c.cfg.Branch),
-		SingleBranch:  true,
-		Depth:         1, // Shallow clone for efficiency
+	opts, err := loadRemoteOptions(c.cfg)
+	if err != nil {
+		return Commit{}, err
+	}
+	c.lookupHost(ctx, opts)
+
+	fetchCtx, span := tracer.Start(ctx, "git.fetch", trace.WithAttributes(
+		semconv.VCSRefHeadName(c.cfg.Branch), attribute.Bool("git.shallow", true)))
+	repo, err := git.PlainCloneContext(fetchCtx, c.workDir, false, &git.CloneOptions{
+		URL:             c.cfg.RepoURL,
+		ReferenceName:   plumbing.NewBranchReferenceName(c.cfg.Branch),
+		SingleBranch:    true,
+		Depth:           1, // Shallow clone for efficiency
+		NoCheckout:      true,
+		CABundle:        opts.caBundle,
+		ClientCert:      opts.clientCert,
+		ClientKey:       opts.clientKey,
+		InsecureSkipTLS: opts.insecureSkipTLS,
+		ProxyOptions:    opts.proxy,
 	})
+	tracing.End(span, err)
+	if err != nil {
+		return Commit{}, fmt.Errorf("clone failed: %w", err)
+	}
+
+	_, span = tracer.Start(ctx, "git.checkout", trace.WithAttributes(semconv.VCSRefHeadName(c.cfg.Branch)))
+	w, err := repo.Worktree()
+	if err == nil {
+		err = w.Checkout(&git.CheckoutOptions{Branch: plumbing.NewBranchReferenceName(c.cfg.Branch), Force: true})
+	}
+	tracing.End(span, err)
 	if err != nil {
-		return "", fmt.Errorf("clone failed: %w", err)
+		return Commit{}, fmt.Errorf("checkout failed: %w", err)
 	}
 
 	c.repo = repo
 	return c.getHeadCommit()
 }
 
-func (c *Client) pull(ctx context.Context) (string, error) {
+// pull fetches the branch and, when it moved, resets the worktree to the
+// fetched commit. Only the fetch goes over the network.
+func (c *Client) pull(ctx context.Context) (Commit, error) {
 	fmt.Printf("Pulling latest changes from branch: %s\n", c.cfg.Branch)
+	insecureWarning(c.cfg)
 
 	w, err := c.repo.Worktree()
 	if err != nil {
-		return "", fmt.Errorf("failed to get worktree: %w", err)
+		return Commit{}, fmt.Errorf("failed to get worktree: %w", err)
 	}
 
-	err = w.PullContext(ctx, &git.PullOptions{
-		ReferenceName: plumbing.NewBranchReferenceName(c.cfg.Branch),
-		SingleBranch:  true,
+	opts, err := loadRemoteOptions(c.cfg)
+	if err != nil {
+		return Commit{}, err
+	}
+	c.lookupHost(ctx, opts)
+
+	fetchCtx, span := tracer.Start(ctx, "git.fetch", trace.WithAttributes(semconv.VCSRefHeadName(c.cfg.Branch)))
+	err = c.repo.FetchContext(fetchCtx, &git.FetchOptions{
+		CABundle:        opts.caBundle,
+		ClientCert:      opts.clientCert,
+		ClientKey:       opts.clientKey,
+		InsecureSkipTLS: opts.insecureSkipTLS,
+		ProxyOptions:    opts.proxy,
 	})
+	// "already up to date" is not an error
+	if err == git.NoErrAlreadyUpToDate {
+		err = nil
+	}
+	tracing.End(span, err)
+	if err != nil {
+		return Commit{}, fmt.Errorf("fetch failed: %w", err)
+	}
 
-	// Ignore "already up to date" errors
-	if err != nil && err != git.NoErrAlreadyUpToDate {
-		return "", fmt.Errorf("pull failed: %w", err)
+	head, err := c.repo.Head()
+	if err != nil {
+		return Commit{}, fmt.Errorf("failed to get HEAD: %w", err)
+	}
+	remote, err := c.repo.Reference(plumbing.NewRemoteReferenceName(git.DefaultRemoteName, c.cfg.Branch), true)
+	if err != nil {
+		return Commit{}, fmt.Errorf("failed to resolve fetched branch: %w", err)
+	}
+	if remote.Hash() == head.Hash() {
+		return c.getHeadCommit()
+	}
+
+	// The commit is already fetched: move the branch and worktree to it locally
+	_, span = tracer.Start(ctx, "git.checkout", trace.WithAttributes(semconv.VCSRefHeadName(c.cfg.Branch)))
+	err = w.Reset(&git.ResetOptions{Commit: remote.Hash(), Mode: git.HardReset})
+	tracing.End(span, err)
+	if err != nil {
+		return Commit{}, fmt.Errorf("checkout failed: %w", err)
 	}
 
 	return c.getHeadCommit()
 }
 
-func (c *Client) getHeadCommit() (string, error) {
+// lookupHost resolves the remote's host in its own span, so slow DNS shows
+// apart from the fetch. The result is not used: go-git resolves again and
+// reports any failure. Behind a proxy the proxy resolves, so it is skipped.
+func (c *Client) lookupHost(ctx context.Context, opts remoteOptions) {
+	ep, err := transport.NewEndpoint(c.cfg.RepoURL)
+	if err != nil || ep.Host == "" || opts.proxy.URL != "" || net.ParseIP(ep.Host) != nil {
+		return
+	}
+	ctx, span := tracer.Start(ctx, "dns", trace.WithAttributes(semconv.ServerAddress(ep.Host)))
+	addrs, err := net.DefaultResolver.LookupHost(ctx, ep.Host)
+	span.SetAttributes(attribute.Int("dns.addresses", len(addrs)))
+	tracing.End(span, err)
+}
+
+func (c *Client) getHeadCommit() (Commit, error) {
 	ref, err := c.repo.Head()
 	if err != nil {
-		return "", fmt.Errorf("failed to get HEAD: %w", err)
+		return Commit{}, fmt.Errorf("failed to get HEAD: %w", err)
 	}
-	return ref.Hash().String(), nil
+
+	obj, err := c.repo.CommitObject(ref.Hash())
+	if err != nil {
+		return Commit{}, fmt.Errorf("failed to read HEAD commit: %w", err)
+	}
+	return Commit{
+		Hash:   ref.Hash().String(),
+		Author: fmt.Sprintf("%s <%s>", obj.Author.Name, obj.Author.Email),
+		Time:   obj.Committer.When.UTC(),
+	}, nil
+}
+
+// DiskUsage returns the size of the checkout, including the .git directory.
+func (c *Client) DiskUsage() (int64, error) {
+	var total int64
+	err := filepath.WalkDir(c.workDir, func(_ string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.Type().IsRegular() {
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			total += info.Size()
+		}
+		return nil
+	})
+	return total, err
 }
 
 func (c *Client) WorkDir() string {
@@ -1,6 +1,7 @@
 package git_test
 
 import (
+	"context"
 	"testing"
 
 	"github.com/davidaparicio/microsvcs/projects/git-sync/internal/config"
@@ -33,3 +34,11 @@ func TestClientClose(t *testing.T) {
 	assert.NoError(t, client.Close())
 	assert.NoDirExists(t, client.WorkDir())
 }
+
+func TestListBranches_InvalidPattern(t *testing.T) {
+	_, err := git.ListBranches(context.Background(), &config.Config{
+		RepoURL:       "https://github.com/test/repo.git",
+		BranchPattern: "flags/[",
+	})
+	assert.ErrorContains(t, err, "invalid branch pattern")
+}
@@ -0,0 +1,85 @@
+package git
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/davidaparicio/microsvcs/projects/git-sync/internal/config"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"golang.org/x/net/http/httpproxy"
+)
+
+// remoteOptions are the HTTPS settings passed to go-git for one operation.
+type remoteOptions struct {
+	caBundle        []byte
+	clientCert      []byte
+	clientKey       []byte
+	insecureSkipTLS bool
+	proxy           transport.ProxyOptions
+}
+
+// loadRemoteOptions reads the CA bundle and client certificate files, so a
+// rotated Secret applies on the next fetch, and resolves the proxy for the
+// repository URL against GIT_NO_PROXY.
+func loadRemoteOptions(cfg *config.Config) (remoteOptions, error) {
+	opts := remoteOptions{insecureSkipTLS: cfg.GitInsecureSkipTLSVerify}
+
+	var err error
+	if cfg.GitCABundle != "" {
+		if opts.caBundle, err = os.ReadFile(cfg.GitCABundle); err != nil {
+			return remoteOptions{}, fmt.Errorf("failed to read GIT_CA_BUNDLE: %w", err)
+		}
+	}
+	if cfg.GitClientCert != "" {
+		if opts.clientCert, err = os.ReadFile(cfg.GitClientCert); err != nil {
+			return remoteOptions{}, fmt.Errorf("failed to read GIT_CLIENT_CERT: %w", err)
+		}
+		if opts.clientKey, err = os.ReadFile(cfg.GitClientKey); err != nil {
+			return remoteOptions{}, fmt.Errorf("failed to read GIT_CLIENT_KEY: %w", err)
+		}
+	}
+
+	if cfg.GitProxy != "" {
+		proxy, err := proxyFor(cfg)
+		if err != nil {
+			return remoteOptions{}, err
+		}
+		if proxy != nil {
+			opts.proxy.URL = (&url.URL{Scheme: proxy.Scheme, Host: proxy.Host}).String()
+			opts.proxy.Username = proxy.User.Username()
+			opts.proxy.Password, _ = proxy.User.Password()
+		}
+	}
+	return opts, nil
+}
+
+// proxyFor returns the proxy for the repository URL, or nil when GIT_NO_PROXY
+// matches it. Matching follows NO_PROXY conventions, including that
+// localhost and loopback addresses are never proxied.
+func proxyFor(cfg *config.Config) (*url.URL, error) {
+	target, err := url.Parse(cfg.RepoURL)
+	if err != nil || (target.Scheme != "http" && target.Scheme != "https") {
+		// SSH and local remotes do not go through an HTTP proxy
+		return nil, nil
+	}
+	proxy := (&httpproxy.Config{
+		HTTPProxy:  cfg.GitProxy,
+		HTTPSProxy: cfg.GitProxy,
+		NoProxy:    strings.Join(cfg.GitNoProxy, ","),
+	}).ProxyFunc()
+	u, err := proxy(target)
+	if err != nil {
+		return nil, fmt.Errorf("invalid GIT_PROXY: %w", err)
+	}
+	return u, nil
+}
+
+// insecureWarning is logged on every fetch while verification is disabled,
+// so it cannot go unnoticed in the logs.
+func insecureWarning(cfg *config.Config) {
+	if cfg.GitInsecureSkipTLSVerify {
+		fmt.Fprintln(os.Stderr, "WARNING: GIT_INSECURE_SKIP_TLS_VERIFY is set, the remote's TLS certificate is NOT verified and the connection can be intercepted")
+	}
+}
@@ -0,0 +1,213 @@
+package git_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/cgi"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/davidaparicio/microsvcs/projects/git-sync/internal/config"
+	"github.com/davidaparicio/microsvcs/projects/git-sync/internal/git"
+	gogit "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newRepo creates a repository with one commit on main and returns its
+// directory and commit hash.
+func newRepo(t *testing.T) (string, string) {
+	t.Helper()
+	dir := t.TempDir()
+	repo, err := gogit.PlainInitWithOptions(dir, &gogit.PlainInitOptions{
+		InitOptions: gogit.InitOptions{DefaultBranch: plumbing.NewBranchReferenceName("main")},
+	})
+	require.NoError(t, err)
+	w, err := repo.Worktree()
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "flags.yaml"), []byte("color-box: {}\n"), 0644))
+	_, err = w.Add("flags.yaml")
+	require.NoError(t, err)
+	hash, err := w.Commit("flags", &gogit.CommitOptions{
+		Author: &object.Signature{Name: "Test", Email: "test@example.com", When: time.Now()},
+	})
+	require.NoError(t, err)
+	return dir, hash.String()
+}
+
+// smartHTTP serves the repository in dir read-only over git's smart HTTP
+// protocol at /<base name of dir>, using git http-backend as a CGI. go-git's
+// own server cannot answer shallow clones.
+func smartHTTP(t *testing.T, dir string) (http.Handler, string) {
+	t.Helper()
+	bin, err := exec.LookPath("git")
+	if err != nil {
+		t.Skip("git binary not available for http-backend")
+	}
+	return &cgi.Handler{
+		Path: bin,
+		Args: []string{"http-backend"},
+		Env:  []string{"GIT_PROJECT_ROOT=" + filepath.Dir(dir), "GIT_HTTP_EXPORT_ALL=1"},
+	}, "/" + filepath.Base(dir)
+}
+
+// writePEM writes a PEM block to a new file and returns its path.
+func writePEM(t *testing.T, name, typ string, der []byte) string {
+	t.Helper()
+	p := filepath.Join(t.TempDir(), name)
+	require.NoError(t, os.WriteFile(p, pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: der}), 0600))
+	return p
+}
+
+// clientCert issues a client certificate from a fresh CA and returns the CA
+// pool along with the certificate and key files.
+func clientCert(t *testing.T) (*x509.CertPool, string, string) {
+	t.Helper()
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	ca := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, ca, ca, &caKey.PublicKey, caKey)
+	require.NoError(t, err)
+	ca, err = x509.ParseCertificate(caDER)
+	require.NoError(t, err)
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	leaf := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "git-sync"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	leafDER, err := x509.CreateCertificate(rand.Reader, leaf, ca, &key.PublicKey, caKey)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	pool := x509.NewCertPool()
+	pool.AddCert(ca)
+	return pool, writePEM(t, "client.crt", "CERTIFICATE", leafDER), writePEM(t, "client.key", "EC PRIVATE KEY", keyDER)
+}
+
+// clone runs a first Sync of cfg and returns the checked out commit.
+func clone(t *testing.T, cfg *config.Config) (string, error) {
+	t.Helper()
+	cfg.Branch = "main"
+	client, err := git.NewClient(cfg)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = client.Close() })
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	commit, err := client.Sync(ctx)
+	return commit.Hash, err
+}
+
+func TestClient_TrustsCABundle(t *testing.T) {
+	dir, head := newRepo(t)
+	handler, path := smartHTTP(t, dir)
+	srv := httptest.NewTLSServer(handler)
+	defer srv.Close()
+	url := srv.URL + path
+
+	_, err := clone(t, &config.Config{RepoURL: url})
+	assert.ErrorContains(t, err, "certificate", "the test CA is not in the system pool")
+
+	bundle := writePEM(t, "ca.pem", "CERTIFICATE", srv.Certificate().Raw)
+	got, err := clone(t, &config.Config{RepoURL: url, GitCABundle: bundle})
+	require.NoError(t, err)
+	assert.Equal(t, head, got)
+
+	_, err = clone(t, &config.Config{RepoURL: url, GitCABundle: filepath.Join(t.TempDir(), "missing.pem")})
+	assert.ErrorContains(t, err, "GIT_CA_BUNDLE")
+}
+
+func TestClient_InsecureSkipTLSVerify(t *testing.T) {
+	dir, head := newRepo(t)
+	handler, path := smartHTTP(t, dir)
+	srv := httptest.NewTLSServer(handler)
+	defer srv.Close()
+
+	got, err := clone(t, &config.Config{RepoURL: srv.URL + path, GitInsecureSkipTLSVerify: true})
+	require.NoError(t, err)
+	assert.Equal(t, head, got)
+}
+
+func TestClient_PresentsClientCertificate(t *testing.T) {
+	dir, head := newRepo(t)
+	pool, certFile, keyFile := clientCert(t)
+	handler, path := smartHTTP(t, dir)
+	srv := httptest.NewUnstartedServer(handler)
+	srv.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: pool}
+	srv.StartTLS()
+	defer srv.Close()
+
+	url := srv.URL + path
+	bundle := writePEM(t, "ca.pem", "CERTIFICATE", srv.Certificate().Raw)
+
+	_, err := clone(t, &config.Config{RepoURL: url, GitCABundle: bundle})
+	assert.Error(t, err, "the server requires a client certificate")
+
+	cfg := &config.Config{RepoURL: url, GitCABundle: bundle, GitClientCert: certFile, GitClientKey: keyFile}
+	got, err := clone(t, cfg)
+	require.NoError(t, err)
+	assert.Equal(t, head, got)
+
+	// Branch listing for multi-branch mode uses the same settings
+	cfg.BranchPattern = "*"
+	branches, err := git.ListBranches(context.Background(), cfg)
+	require.NoError(t, err)
+	assert.Equal(t, []git.Branch{{Name: "main", Hash: head}}, branches)
+}
+
+func TestClient_UsesProxyUnlessExcluded(t *testing.T) {
+	dir, head := newRepo(t)
+	var proxied atomic.Int32
+	handler, path := smartHTTP(t, dir)
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// A forward proxy receives the absolute URL of the remote
+		if r.URL.Host != "git.internal.test" {
+			http.Error(w, "unexpected host "+r.URL.Host, http.StatusBadGateway)
+			return
+		}
+		proxied.Add(1)
+		handler.ServeHTTP(w, r)
+	}))
+	defer proxy.Close()
+
+	url := "http://git.internal.test" + path
+	got, err := clone(t, &config.Config{RepoURL: url, GitProxy: proxy.URL})
+	require.NoError(t, err)
+	assert.Equal(t, head, got)
+	assert.Positive(t, proxied.Load())
+
+	proxied.Store(0)
+	_, err = clone(t, &config.Config{RepoURL: url, GitProxy: proxy.URL, GitNoProxy: []string{".internal.test"}})
+	assert.Error(t, err, "git.internal.test only resolves through the proxy")
+	assert.Zero(t, proxied.Load())
+}
@@ -0,0 +1,163 @@
+package publish
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"filippo.io/age"
+	"filippo.io/age/armor"
+)
+
+// AgeSuffix marks age-encrypted files. They are decrypted when Options.Keys
+// is set and published without the suffix.
+const AgeSuffix = ".age"
+
+// SecretMode is the permission of decrypted files: readable by the owner
+// and by a consumer sharing the pod's fsGroup, nobody else.
+const SecretMode os.FileMode = 0640
+
+// ErrDecrypt is matched by every decryption failure.
+var ErrDecrypt = errors.New("decryption failed")
+
+// decryptError wraps a decryption failure for one file. Messages never
+// include key material or plaintext.
+type decryptError struct {
+	path   string
+	reason string
+}
+
+func (e *decryptError) Error() string {
+	return fmt.Sprintf("failed to decrypt %s: %s", e.path, e.reason)
+}
+
+func (e *decryptError) Is(target error) bool {
+	return target == ErrDecrypt
+}
+
+// Keys holds the age identities used to decrypt secrets. It deliberately
+// formats without its content, so it is safe to log by accident.
+type Keys struct {
+	identities []age.Identity
+	digestKey  []byte // derived from the identities, see digest
+}
+
+// LoadKeys reads age identities (AGE-SECRET-KEY-... lines) from path, which
+// may be a file or a directory such as a mounted Secret. Every regular file
+// in a directory is read; Kubernetes' ..data links and dotfiles are skipped.
+func LoadKeys(path string) (*Keys, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read age keys: %w", err)
+	}
+
+	files := []string{path}
+	if info.IsDir() {
+		entries, err := os.ReadDir(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read age keys: %w", err)
+		}
+		files = files[:0]
+		for _, e := range entries {
+			if e.Name()[0] == '.' {
+				continue
+			}
+			// Secret volumes expose keys as links into ..data
+			if fi, err := os.Stat(filepath.Join(path, e.Name())); err == nil && fi.Mode().IsRegular() {
+				files = append(files, filepath.Join(path, e.Name()))
+			}
+		}
+	}
+
+	k := &Keys{}
+	for _, f := range files {
+		data, err := os.ReadFile(f)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read age keys: %w", err)
+		}
+		ids, err := age.ParseIdentities(bytes.NewReader(data))
+		if err != nil {
+			// The parser's message may quote part of the key
+			return nil, fmt.Errorf("failed to parse age keys in %s", f)
+		}
+		k.identities = append(k.identities, ids...)
+	}
+	if len(k.identities) == 0 {
+		return nil, fmt.Errorf("no age keys found in %s", path)
+	}
+	k.digestKey = digestKey(k.identities)
+	return k, nil
+}
+
+// digestKey derives the HMAC key of secret digests from the identities, so
+// digests stay the same across restarts and cannot be checked against a
+// guessed plaintext without the keys.
+func digestKey(ids []age.Identity) []byte {
+	var lines []string
+	for _, id := range ids {
+		if s, ok := id.(fmt.Stringer); ok {
+			lines = append(lines, s.String())
+		}
+	}
+	slices.Sort(lines)
+	h := sha256.New()
+	h.Write([]byte("git-sync secret digest\n"))
+	for _, l := range lines {
+		h.Write([]byte(l + "\n"))
+	}
+	return h.Sum(nil)
+}
+
+// digest is the keyed hash the manifest records for a decrypted file in
+// place of its SHA-256.
+func (k *Keys) digest(data []byte) string {
+	mac := hmac.New(sha256.New, k.digestKey)
+	mac.Write(data)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (k *Keys) String() string {
+	return fmt.Sprintf("age keys (%d)", len(k.identities))
+}
+
+func (k *Keys) GoString() string {
+	return k.String()
+}
+
+// decrypt opens an age file, armored or binary.
+func (k *Keys) decrypt(data []byte) ([]byte, error) {
+	var r io.Reader = bytes.NewReader(data)
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte(armor.Header)) {
+		r = armor.NewReader(bytes.NewReader(bytes.TrimSpace(data)))
+	}
+	dec, err := age.Decrypt(r, k.identities...)
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(dec)
+}
+
+// decryptAge decrypts an *.age source file.
+func (k *Keys) decryptAge(name string, data []byte) ([]byte, error) {
+	plain, err := k.decrypt(data)
+	if err != nil {
+		return nil, &decryptError{path: name, reason: ageReason(err)}
+	}
+	return plain, nil
+}
+
+// ageReason describes an age failure without echoing its input.
+func ageReason(err error) string {
+	var noMatch *age.NoIdentityMatchError
+	if errors.As(err, &noMatch) {
+		return "no configured age key matches its recipients"
+	}
+	return "not a valid age file"
+}
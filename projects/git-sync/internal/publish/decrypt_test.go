@@ -0,0 +1,260 @@
+package publish_test
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"filippo.io/age"
+	"filippo.io/age/armor"
+	"github.com/davidaparicio/microsvcs/projects/git-sync/internal/publish"
+	"github.com/getsops/sops/v3"
+	sopsaes "github.com/getsops/sops/v3/aes"
+	sopsage "github.com/getsops/sops/v3/age"
+	"github.com/getsops/sops/v3/cmd/sops/common"
+	"github.com/getsops/sops/v3/cmd/sops/formats"
+	"github.com/getsops/sops/v3/config"
+	"github.com/getsops/sops/v3/version"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+)
+
+// newKeys generates an age identity, stores it like a mounted Secret and
+// loads it back.
+func newKeys(t *testing.T) (*age.X25519Identity, *publish.Keys) {
+	t.Helper()
+	id, err := age.GenerateX25519Identity()
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "keys.txt"), []byte("# created: test\n"+id.String()+"\n"), 0600))
+	keys, err := publish.LoadKeys(dir)
+	require.NoError(t, err)
+	return id, keys
+}
+
+func ageEncrypt(t *testing.T, to age.Recipient, plain string) string {
+	t.Helper()
+	var buf bytes.Buffer
+	a := armor.NewWriter(&buf)
+	w, err := age.Encrypt(a, to)
+	require.NoError(t, err)
+	_, err = w.Write([]byte(plain))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	require.NoError(t, a.Close())
+	return buf.String()
+}
+
+// sopsEncrypt encrypts a document as `sops --encrypt --age` does.
+func sopsEncrypt(t *testing.T, to *age.X25519Recipient, format formats.Format, plain string) string {
+	t.Helper()
+	store := common.StoreForFormat(format, config.NewStoresConfig())
+	branches, err := store.LoadPlainFile([]byte(plain))
+	require.NoError(t, err)
+	key, err := sopsage.MasterKeyFromRecipient(to.String())
+	require.NoError(t, err)
+
+	tree := sops.Tree{
+		Branches: branches,
+		Metadata: sops.Metadata{KeyGroups: []sops.KeyGroup{{key}}, Version: version.Version},
+	}
+	dataKey, errs := tree.GenerateDataKey()
+	require.Empty(t, errs)
+	require.NoError(t, common.EncryptTree(common.EncryptTreeOpts{Tree: &tree, Cipher: sopsaes.NewCipher(), DataKey: dataKey}))
+	out, err := store.EmitEncryptedFile(tree)
+	require.NoError(t, err)
+	return string(out)
+}
+
+func marshal(t *testing.T, doc *yaml.Node) string {
+	t.Helper()
+	out, err := yaml.Marshal(doc)
+	require.NoError(t, err)
+	return string(out)
+}
+
+const secretsYAML = `# exporter credentials
+exporter:
+  endpoint: https://collector.example.com
+  apiKey: sk-12345
+  batch: 100
+  ratio: 0.25
+  enabled: true
+tokens:
+  - first
+  # the fallback one
+  - second
+empty: ""
+`
+
+func TestBuild_DecryptsAgeFiles(t *testing.T) {
+	id, keys := newKeys(t)
+	root := writeTree(t, map[string]string{
+		"flags.yaml":                    "public",
+		"secrets.yaml.age":              ageEncrypt(t, id.Recipient(), "apiKey: s3cr3t\n"),
+		"exporter.production.json.age":  ageEncrypt(t, id.Recipient(), `{"prod":true}`),
+		"exporter.development.json.age": ageEncrypt(t, id.Recipient(), `{"dev":true}`),
+	})
+
+	files, err := publish.Build(root, publish.Options{Environment: "production", Environments: envs, Keys: keys})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		"exporter.json": `{"prod":true}`,
+		"flags.yaml":    "public",
+		"secrets.yaml":  "apiKey: s3cr3t\n",
+	}, paths(files))
+
+	for _, f := range files {
+		if f.Path == "flags.yaml" {
+			assert.Equal(t, os.FileMode(0644), f.Mode)
+		} else {
+			assert.Equal(t, publish.SecretMode, f.Mode, f.Path)
+			assert.True(t, f.Secret, f.Path)
+		}
+	}
+}
+
+func TestBuild_EncryptedFilesVerbatimWithoutKeys(t *testing.T) {
+	id, _ := newKeys(t)
+	encrypted := ageEncrypt(t, id.Recipient(), "apiKey: s3cr3t\n")
+	root := writeTree(t, map[string]string{"secrets.yaml.age": encrypted})
+
+	files, err := publish.Build(root, publish.Options{})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"secrets.yaml.age": encrypted}, paths(files))
+}
+
+func TestBuild_AgeWrongKey(t *testing.T) {
+	other, _ := newKeys(t)
+	id, keys := newKeys(t)
+	root := writeTree(t, map[string]string{"secrets.yaml.age": ageEncrypt(t, other.Recipient(), "apiKey: s3cr3t\n")})
+
+	_, err := publish.Build(root, publish.Options{Keys: keys})
+	require.ErrorIs(t, err, publish.ErrDecrypt)
+	assert.ErrorContains(t, err, "secrets.yaml.age: no configured age key matches")
+	assert.NotContains(t, err.Error(), id.String())
+}
+
+func TestBuild_DecryptsSOPSYAML(t *testing.T) {
+	id, keys := newKeys(t)
+	root := writeTree(t, map[string]string{
+		"secrets.yaml": sopsEncrypt(t, id.Recipient(), formats.Yaml, secretsYAML),
+		"plain.yaml":   "sops: is just a word here\n",
+	})
+
+	files, err := publish.Build(root, publish.Options{Keys: keys})
+	require.NoError(t, err)
+	published := paths(files)
+	assert.Equal(t, "sops: is just a word here\n", published["plain.yaml"])
+
+	var got, want map[string]any
+	require.NoError(t, yaml.Unmarshal([]byte(published["secrets.yaml"]), &got))
+	require.NoError(t, yaml.Unmarshal([]byte(secretsYAML), &want))
+	assert.Equal(t, want, got)
+	assert.NotContains(t, published["secrets.yaml"], "ENC[")
+	assert.Contains(t, published["secrets.yaml"], "# the fallback one")
+
+	for _, f := range files {
+		if f.Path == "secrets.yaml" {
+			assert.Equal(t, publish.SecretMode, f.Mode)
+		}
+	}
+}
+
+func TestBuild_DecryptsSOPSJSON(t *testing.T) {
+	id, keys := newKeys(t)
+	plain := `{"zeta": "last?", "alpha": 1, "nested": {"on": true, "list": ["a", 2.5]}}`
+	root := writeTree(t, map[string]string{"secrets.json": sopsEncrypt(t, id.Recipient(), formats.Json, plain)})
+	files, err := publish.Build(root, publish.Options{Keys: keys})
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"zeta": "last?", "alpha": 1, "nested": {"on": true, "list": ["a", 2.5]}}`, paths(files)["secrets.json"])
+}
+
+func TestBuild_SOPSTampering(t *testing.T) {
+	id, keys := newKeys(t)
+
+	tamper := map[string]func(root *yaml.Node){
+		"value moved to another key": func(root *yaml.Node) {
+			exporter := root.Content[1]
+			exporter.Content[3].Value, exporter.Content[1].Value = exporter.Content[1].Value, exporter.Content[3].Value
+		},
+		"value removed": func(root *yaml.Node) {
+			exporter := root.Content[1]
+			exporter.Content = exporter.Content[2:]
+		},
+		"plain value added": func(root *yaml.Node) {
+			exporter := root.Content[1]
+			exporter.Content = append(exporter.Content,
+				&yaml.Node{Kind: yaml.ScalarNode, Value: "extra"}, &yaml.Node{Kind: yaml.ScalarNode, Value: "injected"})
+		},
+	}
+
+	for name, fn := range tamper {
+		t.Run(name, func(t *testing.T) {
+			var doc yaml.Node
+			require.NoError(t, yaml.Unmarshal([]byte(sopsEncrypt(t, id.Recipient(), formats.Yaml, secretsYAML)), &doc))
+			fn(doc.Content[0])
+			root := writeTree(t, map[string]string{"secrets.yaml": marshal(t, &doc)})
+
+			_, err := publish.Build(root, publish.Options{Keys: keys})
+			assert.ErrorIs(t, err, publish.ErrDecrypt)
+		})
+	}
+}
+
+func TestBuild_SOPSIgnoresKeysFromTheEnvironment(t *testing.T) {
+	id, keys := newKeys(t)
+	_, otherKeys := newKeys(t)
+	secrets := sopsEncrypt(t, id.Recipient(), formats.Yaml, secretsYAML)
+
+	// Only the configured keys are used, whatever the sops variables say
+	t.Setenv(sopsage.SopsAgeKeyEnv, id.String())
+	t.Setenv(sopsage.SopsAgeKeyFileEnv, filepath.Join(t.TempDir(), "missing.txt"))
+	_, err := publish.Build(writeTree(t, map[string]string{"secrets.yaml": secrets}), publish.Options{Keys: keys})
+	require.NoError(t, err)
+
+	_, err = publish.Build(writeTree(t, map[string]string{"secrets.yaml": secrets}), publish.Options{Keys: otherKeys})
+	assert.ErrorContains(t, err, "secrets.yaml: no configured age key matches its recipients")
+	assert.Equal(t, id.String(), os.Getenv(sopsage.SopsAgeKeyEnv))
+}
+
+func TestBuild_SOPSWithoutAgeRecipient(t *testing.T) {
+	_, keys := newKeys(t)
+	root := writeTree(t, map[string]string{"secrets.yaml": `apiKey: ENC[AES256_GCM,data:AAAA,iv:AAAA,tag:AAAA,type:str]
+sops:
+  kms:
+    - arn: arn:aws:kms:eu-west-1:111122223333:key/example
+      created_at: "2026-01-29T10:00:00Z"
+      enc: AAAA
+      aws_profile: ""
+  lastmodified: "2026-01-29T10:00:00Z"
+  mac: ENC[AES256_GCM,data:AAAA,iv:AAAA,tag:AAAA,type:str]
+  version: 3.13.3
+`})
+
+	_, err := publish.Build(root, publish.Options{Keys: keys})
+	assert.ErrorContains(t, err, "not encrypted for any age recipient")
+}
+
+func TestLoadKeys_NeverEchoesKeyMaterial(t *testing.T) {
+	id, keys := newKeys(t)
+	assert.NotContains(t, fmt.Sprintf("%v %+v %#v %s", keys, keys, keys, keys), "AGE-SECRET-KEY")
+
+	corrupted := strings.TrimSuffix(id.String(), id.String()[len(id.String())-6:]) + "XXXXXX"
+	p := filepath.Join(t.TempDir(), "keys.txt")
+	require.NoError(t, os.WriteFile(p, []byte(corrupted+"\n"), 0600))
+
+	_, err := publish.LoadKeys(p)
+	require.Error(t, err)
+	assert.NotContains(t, err.Error(), "AGE-SECRET-KEY")
+}
+
+func TestLoadKeys_Empty(t *testing.T) {
+	_, err := publish.LoadKeys(t.TempDir())
+	assert.ErrorContains(t, err, "no age keys found")
+}
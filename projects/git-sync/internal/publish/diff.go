@@ -0,0 +1,100 @@
+package publish
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// Change is one file in a ChangeSet.
+type Change struct {
+	Path    string `json:"path"`
+	Size    int64  `json:"size"`              // size after publishing (current size for deletions)
+	OldSize int64  `json:"oldSize,omitempty"` // size currently in the target, for modifications
+}
+
+// ChangeSet is what publishing a tree would do to a target directory.
+type ChangeSet struct {
+	Commit   string   `json:"commit"`
+	Added    []Change `json:"added"`
+	Modified []Change `json:"modified"`
+	Deleted  []Change `json:"deleted"`
+}
+
+// Empty reports whether publishing would leave the target unchanged.
+func (c *ChangeSet) Empty() bool {
+	return len(c.Added) == 0 && len(c.Modified) == 0 && len(c.Deleted) == 0
+}
+
+// Diff computes the changes Publish would make to target without writing
+// anything. Deletions only cover files the target's manifest says git-sync
+// published, mirroring what Publish prunes.
+func Diff(target string, files []File) (*ChangeSet, error) {
+	cs := &ChangeSet{Added: []Change{}, Modified: []Change{}, Deleted: []Change{}}
+
+	next := make(map[string]bool, len(files))
+	for _, f := range files {
+		next[f.Path] = true
+		// Secrets are compared by content too, as the manifest has no hash
+		// of them
+		want := contentEntry(f)
+
+		got, err := entryOnDisk(filepath.Join(target, filepath.FromSlash(f.Path)))
+		switch {
+		case errors.Is(err, os.ErrNotExist):
+			cs.Added = append(cs.Added, Change{Path: f.Path, Size: want.Size})
+		case err != nil:
+			return nil, err
+		case got != want:
+			cs.Modified = append(cs.Modified, Change{Path: f.Path, Size: want.Size, OldSize: got.Size})
+		}
+	}
+
+	prev, err := ReadManifest(target)
+	if err != nil {
+		return nil, err
+	}
+	if prev != nil {
+		for p := range prev.Files {
+			if next[p] {
+				continue
+			}
+			got, err := entryOnDisk(filepath.Join(target, filepath.FromSlash(p)))
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			cs.Deleted = append(cs.Deleted, Change{Path: p, Size: got.Size})
+		}
+	}
+
+	byPath := func(a, b Change) int { return strings.Compare(a.Path, b.Path) }
+	slices.SortFunc(cs.Added, byPath)
+	slices.SortFunc(cs.Modified, byPath)
+	slices.SortFunc(cs.Deleted, byPath)
+	return cs, nil
+}
+
+// ChangedPaths lists the paths added, modified or removed between two
+// manifests, sorted. A nil prev means everything in next is new.
+func ChangedPaths(prev, next *Manifest) []string {
+	var changed []string
+	for p, e := range next.Files {
+		if prev == nil || prev.Files[p] != e {
+			changed = append(changed, p)
+		}
+	}
+	if prev != nil {
+		for p := range prev.Files {
+			if _, ok := next.Files[p]; !ok {
+				changed = append(changed, p)
+			}
+		}
+	}
+	slices.Sort(changed)
+	return changed
+}
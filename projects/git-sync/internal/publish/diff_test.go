@@ -0,0 +1,71 @@
+package publish_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/davidaparicio/microsvcs/projects/git-sync/internal/publish"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiff(t *testing.T) {
+	target := t.TempDir()
+	_, err := publish.Publish(target, testMeta, []publish.File{
+		{Path: "same.yaml", Mode: 0644, Data: []byte("same")},
+		{Path: "changed.yaml", Mode: 0644, Data: []byte("old")},
+		{Path: "removed.yaml", Mode: 0644, Data: []byte("bye")},
+	})
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(target, "not-ours.txt"), []byte("x"), 0644))
+
+	cs, err := publish.Diff(target, []publish.File{
+		{Path: "same.yaml", Mode: 0644, Data: []byte("same")},
+		{Path: "changed.yaml", Mode: 0644, Data: []byte("newer")},
+		{Path: "nested/added.yaml", Mode: 0644, Data: []byte("hello")},
+	})
+	require.NoError(t, err)
+
+	assert.False(t, cs.Empty())
+	assert.Equal(t, []publish.Change{{Path: "nested/added.yaml", Size: 5}}, cs.Added)
+	assert.Equal(t, []publish.Change{{Path: "changed.yaml", Size: 5, OldSize: 3}}, cs.Modified)
+	assert.Equal(t, []publish.Change{{Path: "removed.yaml", Size: 3}}, cs.Deleted)
+
+	// Nothing was written
+	assert.NoFileExists(t, filepath.Join(target, "nested", "added.yaml"))
+	assertFile(t, filepath.Join(target, "changed.yaml"), "old")
+}
+
+func TestDiff_EmptyTarget(t *testing.T) {
+	cs, err := publish.Diff(filepath.Join(t.TempDir(), "missing"), []publish.File{
+		{Path: "a.yaml", Mode: 0644, Data: []byte("a")},
+	})
+	require.NoError(t, err)
+	assert.Len(t, cs.Added, 1)
+	assert.Empty(t, cs.Deleted)
+}
+
+func assertFile(t *testing.T, path, want string) {
+	t.Helper()
+	content, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, want, string(content))
+}
+
+func TestChangedPaths(t *testing.T) {
+	prev := publish.NewManifest(publish.Metadata{}, []publish.File{
+		{Path: "same.yaml", Data: []byte("same")},
+		{Path: "edited.yaml", Data: []byte("old")},
+		{Path: "removed.yaml", Data: []byte("gone")},
+	})
+	next := publish.NewManifest(publish.Metadata{}, []publish.File{
+		{Path: "same.yaml", Data: []byte("same")},
+		{Path: "edited.yaml", Data: []byte("new")},
+		{Path: "added.yaml", Data: []byte("new")},
+	})
+
+	assert.Equal(t, []string{"added.yaml", "edited.yaml", "removed.yaml"}, publish.ChangedPaths(prev, next))
+	assert.Equal(t, []string{"added.yaml", "edited.yaml", "same.yaml"}, publish.ChangedPaths(nil, next))
+	assert.Empty(t, publish.ChangedPaths(next, next))
+}
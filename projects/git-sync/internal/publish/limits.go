@@ -0,0 +1,44 @@
+package publish
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrLimitExceeded is matched by every LimitError.
+var ErrLimitExceeded = errors.New("resource limit exceeded")
+
+// LimitError reports a resource guard tripping before anything was published.
+type LimitError struct {
+	Limit  string // "fetch-size", "file-count" or "file-size"
+	Path   string // offending file, for file-size
+	Max    int64
+	Actual int64
+}
+
+func (e *LimitError) Error() string {
+	if e.Path != "" {
+		return fmt.Sprintf("%s: %s limit exceeded (%d > %d)", e.Path, e.Limit, e.Actual, e.Max)
+	}
+	return fmt.Sprintf("%s limit exceeded (%d > %d)", e.Limit, e.Actual, e.Max)
+}
+
+func (e *LimitError) Is(target error) bool {
+	return target == ErrLimitExceeded
+}
+
+// checkFileSize enforces Options.MaxFileSize (0 disables it).
+func (o Options) checkFileSize(rel string, size int64) error {
+	if o.MaxFileSize > 0 && size > o.MaxFileSize {
+		return &LimitError{Limit: "file-size", Path: rel, Max: o.MaxFileSize, Actual: size}
+	}
+	return nil
+}
+
+// checkFileCount enforces Options.MaxFiles (0 disables it).
+func (o Options) checkFileCount(n int) error {
+	if o.MaxFiles > 0 && n > o.MaxFiles {
+		return &LimitError{Limit: "file-count", Max: int64(o.MaxFiles), Actual: int64(n)}
+	}
+	return nil
+}
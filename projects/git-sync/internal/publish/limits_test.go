@@ -0,0 +1,53 @@
+package publish_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/davidaparicio/microsvcs/projects/git-sync/internal/publish"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuild_MaxFiles(t *testing.T) {
+	root := writeTree(t, map[string]string{"a.yaml": "a", "b.yaml": "b", "c.yaml": "c"})
+
+	_, err := publish.Build(root, publish.Options{MaxFiles: 3})
+	require.NoError(t, err)
+
+	_, err = publish.Build(root, publish.Options{MaxFiles: 2})
+	assert.ErrorIs(t, err, publish.ErrLimitExceeded)
+
+	var limitErr *publish.LimitError
+	require.True(t, errors.As(err, &limitErr))
+	assert.Equal(t, "file-count", limitErr.Limit)
+	assert.Equal(t, int64(3), limitErr.Actual)
+}
+
+func TestBuild_MaxFileSize(t *testing.T) {
+	root := writeTree(t, map[string]string{
+		"small.yaml":      "ok",
+		"big/binary.blob": strings.Repeat("x", 1024),
+	})
+
+	_, err := publish.Build(root, publish.Options{MaxFileSize: 100})
+	assert.ErrorIs(t, err, publish.ErrLimitExceeded)
+	assert.ErrorContains(t, err, "big/binary.blob")
+}
+
+func TestBuild_MaxFileSizeAfterTemplateExpansion(t *testing.T) {
+	root := writeTree(t, map[string]string{"flags.yaml.tmpl": `{{ range .Values.items }}{{ . }}{{ end }}`})
+
+	items := make([]string, 100)
+	for i := range items {
+		items[i] = "0123456789"
+	}
+	_, err := publish.Build(root, publish.Options{
+		Templates:   true,
+		MaxFileSize: 100,
+		Data:        map[string]any{"Values": map[string]any{"items": items}},
+	})
+	assert.ErrorIs(t, err, publish.ErrLimitExceeded)
+	assert.ErrorContains(t, err, "flags.yaml:")
+}
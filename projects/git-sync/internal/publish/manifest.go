@@ -0,0 +1,379 @@
+package publish
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// ManifestName is the metadata file written at the root of the target directory.
+const ManifestName = ".git-sync.json"
+
+// Metadata identifies the revision a tree was published from.
+type Metadata struct {
+	RepoURL    string    `json:"repoURL"`
+	Ref        string    `json:"ref"`
+	Commit     string    `json:"commit"`
+	CommitTime time.Time `json:"commitTime"`
+	Author     string    `json:"author"`
+}
+
+// Manifest records what was published, so consumers can tell which commit
+// they are running and the tree can be checked for tampering.
+type Manifest struct {
+	Metadata
+	SyncTime time.Time            `json:"syncTime"`
+	Files    map[string]FileEntry `json:"files"`
+
+	// PinnedAt is set while a rollback pins the tree. git-sync does not
+	// publish over a pinned tree, across restarts, until it is unpinned.
+	PinnedAt *time.Time `json:"pinnedAt,omitempty"`
+}
+
+// FileEntry describes one published file.
+type FileEntry struct {
+	SHA256 string `json:"sha256,omitempty"`
+	Size   int64  `json:"size,omitempty"`
+	Link   string `json:"link,omitempty"`
+
+	// Secret marks decrypted content, see File.Secret. The manifest is world
+	// readable, so its hash and size are not recorded, only an HMAC keyed
+	// by the age keys.
+	Secret bool   `json:"secret,omitempty"`
+	HMAC   string `json:"hmac,omitempty"`
+}
+
+// NewManifest hashes files and records them with meta.
+func NewManifest(meta Metadata, files []File) *Manifest {
+	m := &Manifest{
+		Metadata: meta,
+		SyncTime: time.Now().UTC(),
+		Files:    make(map[string]FileEntry, len(files)),
+	}
+	for _, f := range files {
+		m.Files[f.Path] = entryFor(f)
+	}
+	return m
+}
+
+func entryFor(f File) FileEntry {
+	if f.Secret {
+		return FileEntry{Secret: true, HMAC: f.Digest}
+	}
+	return contentEntry(f)
+}
+
+// contentEntry describes f the way entryOnDisk would. For a secret, it must
+// not leave the process.
+func contentEntry(f File) FileEntry {
+	if f.Link != "" {
+		return FileEntry{Link: f.Link}
+	}
+	sum := sha256.Sum256(f.Data)
+	return FileEntry{SHA256: hex.EncodeToString(sum[:]), Size: int64(len(f.Data))}
+}
+
+// ReadManifest loads the manifest from target. It returns nil without an
+// error when nothing has been published yet.
+func ReadManifest(target string) (*Manifest, error) {
+	data, err := os.ReadFile(filepath.Join(target, ManifestName))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var m Manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", ManifestName, err)
+	}
+	return &m, nil
+}
+
+// WriteManifest atomically replaces the manifest in target.
+func WriteManifest(target string, m *Manifest) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return WriteAtomic(filepath.Join(target, ManifestName), append(data, '\n'), 0644)
+}
+
+// WriteAtomic writes data to a temporary file next to dst and renames it into
+// place, so readers never observe a partially written file.
+func WriteAtomic(dst string, data []byte, mode os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(dst), "."+filepath.Base(dst)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer func() { _ = os.Remove(tmp.Name()) }()
+
+	if _, err := tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(mode); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), dst)
+}
+
+// Publish writes files to target, removes files that the previous manifest
+// lists but that are no longer published, and records a new manifest.
+func Publish(target string, meta Metadata, files []File) (*Manifest, error) {
+	prev, err := ReadManifest(target)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := Write(target, files); err != nil {
+		return nil, err
+	}
+
+	m := NewManifest(meta, files)
+	if prev != nil {
+		if err := prune(target, prev, m); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := WriteManifest(target, m); err != nil {
+		return nil, fmt.Errorf("failed to write manifest: %w", err)
+	}
+	return m, nil
+}
+
+// prune removes files published by prev that next no longer contains, along
+// with directories left empty. Files git-sync did not publish are untouched.
+func prune(target string, prev, next *Manifest) error {
+	for p := range prev.Files {
+		if _, ok := next.Files[p]; ok {
+			continue
+		}
+		dst, err := prepare(target, p)
+		if err != nil {
+			return err
+		}
+		if err := os.Remove(dst); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		for dir := path.Dir(p); dir != "."; dir = path.Dir(dir) {
+			// Fails harmlessly once a directory still has content
+			if os.Remove(filepath.Join(target, filepath.FromSlash(dir))) != nil {
+				break
+			}
+		}
+	}
+	return nil
+}
+
+// Report lists the differences between a target tree and its manifest.
+type Report struct {
+	Commit     string   `json:"commit"`
+	Modified   []string `json:"modified"`
+	Missing    []string `json:"missing"`
+	Unexpected []string `json:"unexpected"`
+}
+
+// Clean reports whether the tree matches the manifest.
+func (r *Report) Clean() bool {
+	return len(r.Modified) == 0 && len(r.Missing) == 0 && len(r.Unexpected) == 0
+}
+
+// Verify checks the files in target against the manifest stored there.
+// keys may be nil, see Compare.
+func Verify(target string, keys *Keys) (*Report, error) {
+	m, err := ReadManifest(target)
+	if err != nil {
+		return nil, err
+	}
+	if m == nil {
+		return nil, fmt.Errorf("no %s found in %s", ManifestName, target)
+	}
+	return Compare(target, m, keys)
+}
+
+// Compare checks the files in target against m. Secret files are checked
+// against their HMAC with keys; without keys, they are only reported when
+// missing or no longer regular files.
+func Compare(target string, m *Manifest, keys *Keys) (*Report, error) {
+	r := &Report{Commit: m.Commit, Modified: []string{}, Missing: []string{}, Unexpected: []string{}}
+
+	for p, want := range m.Files {
+		var (
+			got FileEntry
+			err error
+		)
+		if want.Secret {
+			got, err = secretOnDisk(filepath.Join(target, filepath.FromSlash(p)), keys, want)
+		} else {
+			got, err = entryOnDisk(filepath.Join(target, filepath.FromSlash(p)))
+		}
+		switch {
+		case errors.Is(err, os.ErrNotExist):
+			r.Missing = append(r.Missing, p)
+		case err != nil:
+			return nil, err
+		case got != want:
+			r.Modified = append(r.Modified, p)
+		}
+	}
+
+	err := filepath.WalkDir(target, func(p string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(target, p)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if rel == ManifestName || strings.HasPrefix(d.Name(), "."+ManifestName+".tmp-") {
+			return nil
+		}
+		if _, ok := m.Files[rel]; !ok {
+			r.Unexpected = append(r.Unexpected, rel)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	slices.Sort(r.Modified)
+	slices.Sort(r.Missing)
+	slices.Sort(r.Unexpected)
+	return r, nil
+}
+
+// LoadTree reads back the files m records in target, so a tree published
+// before a restart can be served and repaired again. A file that no longer
+// matches m is an error, and secret files cannot be checked without keys.
+func LoadTree(target string, m *Manifest, keys *Keys) ([]File, error) {
+	files := make([]File, 0, len(m.Files))
+	for _, p := range slices.Sorted(maps.Keys(m.Files)) {
+		want := m.Files[p]
+		abs := filepath.Join(target, filepath.FromSlash(p))
+		if want.Link != "" {
+			link, err := os.Readlink(abs)
+			if err != nil {
+				return nil, err
+			}
+			if link != want.Link {
+				return nil, fmt.Errorf("%s: modified since it was published", p)
+			}
+			files = append(files, File{Path: p, Link: link})
+			continue
+		}
+
+		info, err := os.Lstat(abs)
+		if err != nil {
+			return nil, err
+		}
+		if !info.Mode().IsRegular() {
+			return nil, fmt.Errorf("%s: modified since it was published", p)
+		}
+		data, err := os.ReadFile(abs)
+		if err != nil {
+			return nil, err
+		}
+		f := File{Path: p, Mode: info.Mode().Perm(), Data: data, Secret: want.Secret}
+		if want.Secret {
+			if keys == nil {
+				return nil, fmt.Errorf("%s: cannot check a secret without age keys", p)
+			}
+			f.Digest = keys.digest(data)
+		}
+		if got := entryFor(f); got != want {
+			return nil, fmt.Errorf("%s: modified since it was published", p)
+		}
+		files = append(files, f)
+	}
+	return files, nil
+}
+
+// Repair rewrites the files r reports as modified or missing. Unexpected
+// files are left alone: git-sync cannot tell whether someone else owns them.
+func Repair(target string, files []File, r *Report) error {
+	broken := make(map[string]bool, len(r.Modified)+len(r.Missing))
+	for _, p := range r.Modified {
+		broken[p] = true
+	}
+	for _, p := range r.Missing {
+		broken[p] = true
+	}
+
+	var restore []File
+	for _, f := range files {
+		if broken[f.Path] {
+			restore = append(restore, f)
+		}
+	}
+	return Write(target, restore)
+}
+
+// secretOnDisk describes the secret file at p the way the manifest would.
+// Without keys, any regular file passes for want.
+func secretOnDisk(p string, keys *Keys, want FileEntry) (FileEntry, error) {
+	info, err := os.Lstat(p)
+	if err != nil {
+		return FileEntry{}, err
+	}
+	if !info.Mode().IsRegular() {
+		return FileEntry{}, nil
+	}
+	if keys == nil {
+		return want, nil
+	}
+	data, err := os.ReadFile(p)
+	if err != nil {
+		return FileEntry{}, err
+	}
+	return FileEntry{Secret: true, HMAC: keys.digest(data)}, nil
+}
+
+// entryOnDisk describes the file at p the way the manifest would.
+func entryOnDisk(p string) (FileEntry, error) {
+	info, err := os.Lstat(p)
+	if err != nil {
+		return FileEntry{}, err
+	}
+	if info.Mode()&os.ModeSymlink != 0 {
+		link, err := os.Readlink(p)
+		return FileEntry{Link: link}, err
+	}
+	if info.IsDir() {
+		return FileEntry{}, nil
+	}
+
+	f, err := os.Open(p)
+	if err != nil {
+		return FileEntry{}, err
+	}
+	defer func() { _ = f.Close() }()
+
+	h := sha256.New()
+	n, err := io.Copy(h, f)
+	if err != nil {
+		return FileEntry{}, err
+	}
+	return FileEntry{SHA256: hex.EncodeToString(h.Sum(nil)), Size: n}, nil
+}
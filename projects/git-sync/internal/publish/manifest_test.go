@@ -0,0 +1,216 @@
+package publish_test
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"filippo.io/age"
+	"github.com/davidaparicio/microsvcs/projects/git-sync/internal/publish"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testMeta = publish.Metadata{
+	RepoURL:    "https://github.com/davidaparicio/microsvcs.git",
+	Ref:        "main",
+	Commit:     "54a8d74ea3cf6fdcadfac10ee4a4f2553d4562f6",
+	CommitTime: time.Date(2026, 1, 29, 10, 0, 0, 0, time.UTC),
+	Author:     "David Aparicio <david.aparicio@free.fr>",
+}
+
+func TestPublish_WritesManifest(t *testing.T) {
+	target := t.TempDir()
+	files := []publish.File{
+		{Path: "demo-flags.goff.yaml", Mode: 0644, Data: []byte("hello")},
+	}
+
+	_, err := publish.Publish(target, testMeta, files)
+	require.NoError(t, err)
+
+	data, err := os.ReadFile(filepath.Join(target, publish.ManifestName))
+	require.NoError(t, err)
+
+	var m publish.Manifest
+	require.NoError(t, json.Unmarshal(data, &m))
+	assert.Equal(t, testMeta, m.Metadata)
+	assert.False(t, m.SyncTime.IsZero())
+	assert.Equal(t, publish.FileEntry{
+		// sha256("hello")
+		SHA256: "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824",
+		Size:   5,
+	}, m.Files["demo-flags.goff.yaml"])
+}
+
+func TestPublish_PrunesFilesFromPreviousManifest(t *testing.T) {
+	target := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(target, "not-ours.txt"), []byte("keep"), 0644))
+
+	_, err := publish.Publish(target, testMeta, []publish.File{
+		{Path: "keep.yaml", Mode: 0644, Data: []byte("a")},
+		{Path: "old/gone.yaml", Mode: 0644, Data: []byte("b")},
+	})
+	require.NoError(t, err)
+
+	_, err = publish.Publish(target, testMeta, []publish.File{
+		{Path: "keep.yaml", Mode: 0644, Data: []byte("a2")},
+	})
+	require.NoError(t, err)
+
+	assert.FileExists(t, filepath.Join(target, "keep.yaml"))
+	assert.FileExists(t, filepath.Join(target, "not-ours.txt"))
+	assert.NoFileExists(t, filepath.Join(target, "old", "gone.yaml"))
+	assert.NoDirExists(t, filepath.Join(target, "old"))
+}
+
+func TestVerify(t *testing.T) {
+	target := t.TempDir()
+	_, err := publish.Publish(target, testMeta, []publish.File{
+		{Path: "a.yaml", Mode: 0644, Data: []byte("a")},
+		{Path: "b.yaml", Mode: 0644, Data: []byte("b")},
+		{Path: "nested/c.yaml", Mode: 0644, Data: []byte("c")},
+	})
+	require.NoError(t, err)
+
+	report, err := publish.Verify(target, nil)
+	require.NoError(t, err)
+	assert.True(t, report.Clean())
+	assert.Equal(t, testMeta.Commit, report.Commit)
+
+	// Tamper with the tree
+	require.NoError(t, os.WriteFile(filepath.Join(target, "a.yaml"), []byte("tampered"), 0644))
+	require.NoError(t, os.Remove(filepath.Join(target, "nested", "c.yaml")))
+	require.NoError(t, os.WriteFile(filepath.Join(target, "extra.yaml"), []byte("x"), 0644))
+
+	report, err = publish.Verify(target, nil)
+	require.NoError(t, err)
+	assert.False(t, report.Clean())
+	assert.Equal(t, []string{"a.yaml"}, report.Modified)
+	assert.Equal(t, []string{"nested/c.yaml"}, report.Missing)
+	assert.Equal(t, []string{"extra.yaml"}, report.Unexpected)
+}
+
+// buildSecrets builds a tree whose files are all age-encrypted for keys.
+func buildSecrets(t *testing.T, id *age.X25519Identity, keys *publish.Keys, plain map[string]string) []publish.File {
+	t.Helper()
+	encrypted := map[string]string{}
+	for p, content := range plain {
+		encrypted[p+publish.AgeSuffix] = ageEncrypt(t, id.Recipient(), content)
+	}
+	files, err := publish.Build(writeTree(t, encrypted), publish.Options{Keys: keys})
+	require.NoError(t, err)
+	return files
+}
+
+func TestLoadTree(t *testing.T) {
+	id, keys := newKeys(t)
+	target := t.TempDir()
+	files := append([]publish.File{
+		{Path: "a/flags.yaml", Mode: 0644, Data: []byte("flags")},
+		{Path: "alias.yaml", Link: "a/flags.yaml"},
+	}, buildSecrets(t, id, keys, map[string]string{"secret.yaml": "apiKey: x"})...)
+	for i := range files {
+		files[i].Source = ""
+	}
+	m, err := publish.Publish(target, testMeta, files)
+	require.NoError(t, err)
+
+	loaded, err := publish.LoadTree(target, m, keys)
+	require.NoError(t, err)
+	assert.Equal(t, files, loaded)
+
+	_, err = publish.LoadTree(target, m, nil)
+	assert.ErrorContains(t, err, "secret.yaml: cannot check a secret without age keys")
+
+	require.NoError(t, os.WriteFile(filepath.Join(target, "secret.yaml"), []byte("apiKey: y"), 0640))
+	_, err = publish.LoadTree(target, m, keys)
+	assert.ErrorContains(t, err, "secret.yaml: modified since it was published")
+
+	require.NoError(t, os.WriteFile(filepath.Join(target, "a", "flags.yaml"), []byte("edited"), 0644))
+	_, err = publish.LoadTree(target, m, keys)
+	assert.ErrorContains(t, err, "a/flags.yaml: modified since it was published")
+}
+
+func TestPublish_ManifestOmitsSecretHashes(t *testing.T) {
+	id, keys := newKeys(t)
+	target := t.TempDir()
+	_, err := publish.Publish(target, testMeta, buildSecrets(t, id, keys, map[string]string{"secret.yaml": "apiKey: x"}))
+	require.NoError(t, err)
+
+	data, err := os.ReadFile(filepath.Join(target, publish.ManifestName))
+	require.NoError(t, err)
+	var raw struct {
+		Files map[string]map[string]any `json:"files"`
+	}
+	require.NoError(t, json.Unmarshal(data, &raw))
+	entry := raw.Files["secret.yaml"]
+	assert.Equal(t, true, entry["secret"])
+	assert.Len(t, entry["hmac"], 64)
+	assert.NotContains(t, entry, "sha256")
+	assert.NotContains(t, entry, "size")
+	sum := sha256.Sum256([]byte("apiKey: x"))
+	assert.NotContains(t, string(data), hex.EncodeToString(sum[:]))
+}
+
+func TestChangedPaths_SecretRotation(t *testing.T) {
+	id, keys := newKeys(t)
+	before := publish.NewManifest(testMeta, buildSecrets(t, id, keys, map[string]string{"a.yaml": "apiKey: x", "b.yaml": "token: t"}))
+	after := publish.NewManifest(testMeta, buildSecrets(t, id, keys, map[string]string{"a.yaml": "apiKey: rotated", "b.yaml": "token: t"}))
+
+	assert.Equal(t, []string{"a.yaml"}, publish.ChangedPaths(before, after))
+}
+
+func TestDiff_Secrets(t *testing.T) {
+	id, keys := newKeys(t)
+	target := t.TempDir()
+	_, err := publish.Publish(target, testMeta, buildSecrets(t, id, keys, map[string]string{"a.yaml": "apiKey: x", "b.yaml": "token: t"}))
+	require.NoError(t, err)
+
+	cs, err := publish.Diff(target, buildSecrets(t, id, keys, map[string]string{"a.yaml": "apiKey: x", "b.yaml": "token: t"}))
+	require.NoError(t, err)
+	assert.True(t, cs.Empty(), "unchanged secrets are not listed")
+
+	cs, err = publish.Diff(target, buildSecrets(t, id, keys, map[string]string{"a.yaml": "apiKey: rotated", "b.yaml": "token: t"}))
+	require.NoError(t, err)
+	assert.Equal(t, []publish.Change{{Path: "a.yaml", Size: 15, OldSize: 9}}, cs.Modified)
+}
+
+func TestVerify_Secrets(t *testing.T) {
+	id, keys := newKeys(t)
+	target := t.TempDir()
+	_, err := publish.Publish(target, testMeta, buildSecrets(t, id, keys, map[string]string{"a.yaml": "apiKey: x", "b.yaml": "token: t"}))
+	require.NoError(t, err)
+
+	report, err := publish.Verify(target, keys)
+	require.NoError(t, err)
+	assert.True(t, report.Clean())
+
+	require.NoError(t, os.WriteFile(filepath.Join(target, "a.yaml"), []byte("apiKey: tampered"), 0640))
+	require.NoError(t, os.Remove(filepath.Join(target, "b.yaml")))
+	report, err = publish.Verify(target, keys)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a.yaml"}, report.Modified)
+	assert.Equal(t, []string{"b.yaml"}, report.Missing)
+
+	// Without the keys, only the missing secret can be told
+	report, err = publish.Verify(target, nil)
+	require.NoError(t, err)
+	assert.Empty(t, report.Modified)
+	assert.Equal(t, []string{"b.yaml"}, report.Missing)
+}
+
+func TestVerify_NoManifest(t *testing.T) {
+	_, err := publish.Verify(t.TempDir(), nil)
+	assert.ErrorContains(t, err, publish.ManifestName)
+}
+
+func TestBuild_ManifestNameIsReserved(t *testing.T) {
+	root := writeTree(t, map[string]string{publish.ManifestName: "{}"})
+
+	_, err := publish.Build(root, publish.Options{})
+	assert.ErrorContains(t, err, "reserved")
+}
@@ -0,0 +1,307 @@
+package publish
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"text/template"
+)
+
+// TemplateSuffix marks files that are expanded as Go templates when
+// Options.Templates is enabled. The suffix is stripped from the published name.
+const TemplateSuffix = ".tmpl"
+
+// Options controls how a source tree is turned into published files.
+type Options struct {
+	// Environment selects name.<env>.ext variants over name.ext (empty disables selection)
+	Environment string
+	// Environments lists every known environment name, so variants for the
+	// other environments can be recognised and left out
+	Environments []string
+	// Templates enables Go template expansion of *.tmpl files
+	Templates bool
+	// Data is passed to templates as the dot value
+	Data map[string]any
+	// Symlinks is the policy for links in the source tree (default: dereference)
+	Symlinks SymlinkPolicy
+	// RepoRoot bounds link resolution; defaults to the source path itself
+	RepoRoot string
+	// MaxFiles caps the number of published files (0 disables the limit)
+	MaxFiles int
+	// MaxFileSize caps the size of a single file, before and after
+	// template expansion (0 disables the limit)
+	MaxFileSize int64
+	// Keys decrypts *.age files and SOPS-encrypted YAML/JSON files (nil
+	// publishes them as they are)
+	Keys *Keys
+}
+
+// File is a single file ready to be written to the target directory.
+type File struct {
+	Path   string      // slash-separated path relative to the target root
+	Source string      // slash-separated path relative to the source root
+	Mode   os.FileMode // permission bits copied from the source
+	Data   []byte
+	Link   string // symlink target when the file is a preserved link
+	Secret bool   // decrypted content, never served over HTTP
+	Digest string // keyed digest of decrypted content, see Keys
+}
+
+// candidate is a source file competing for a published path.
+type candidate struct {
+	source   string
+	abs      string
+	link     string
+	mode     os.FileMode
+	size     int64
+	template bool
+	age      bool // an *.age file to decrypt
+	specific bool // an environment-specific variant (name.<env>.ext)
+}
+
+// Build walks root and returns the files to publish, sorted by path.
+// If root is a regular file, it is published under its base name.
+func Build(root string, opts Options) ([]File, error) {
+	candidates := make(map[string][]candidate)
+	add := func(abs, rel, link string, info os.FileInfo) {
+		name, c, ok := opts.resolve(path.Base(rel))
+		if !ok {
+			return
+		}
+		c.source, c.abs, c.link = rel, abs, link
+		if info != nil {
+			c.mode, c.size = info.Mode().Perm(), info.Size()
+		}
+		target := path.Join(path.Dir(rel), name)
+		candidates[target] = append(candidates[target], c)
+	}
+
+	w, resolvedRoot, err := newWalker(root, opts, add)
+	if err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(resolvedRoot)
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat source path: %w", err)
+	}
+
+	switch {
+	case info.IsDir():
+		if err := w.walk(resolvedRoot, ""); err != nil {
+			return nil, err
+		}
+	case info.Mode().IsRegular():
+		add(resolvedRoot, filepath.Base(root), "", info)
+	default:
+		return nil, fmt.Errorf("source path %s: unsupported file type %s", root, info.Mode().Type())
+	}
+
+	// Guard the file count before reading anything into memory
+	if err := opts.checkFileCount(len(candidates)); err != nil {
+		return nil, err
+	}
+
+	files := make([]File, 0, len(candidates))
+	for target, cs := range candidates {
+		if target == ManifestName {
+			return nil, fmt.Errorf("%s is reserved for the sync manifest", ManifestName)
+		}
+		c, err := pick(target, cs)
+		if err != nil {
+			return nil, err
+		}
+		if c.link != "" {
+			files = append(files, File{Path: target, Source: c.source, Link: c.link})
+			continue
+		}
+
+		if err := opts.checkFileSize(c.source, c.size); err != nil {
+			return nil, err
+		}
+		data, err := os.ReadFile(c.abs)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", c.source, err)
+		}
+		mode, secret := c.mode, false
+		switch {
+		case c.template:
+			if data, err = expand(c.source, data, opts.Data); err != nil {
+				return nil, err
+			}
+			if err := opts.checkFileSize(target, int64(len(data))); err != nil {
+				return nil, err
+			}
+		case c.age:
+			if data, err = opts.Keys.decryptAge(c.source, data); err != nil {
+				return nil, err
+			}
+			mode, secret = SecretMode, true
+		case opts.Keys != nil:
+			plain, ok, err := opts.Keys.decryptSOPS(c.source, data)
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				data, mode, secret = plain, SecretMode, true
+			}
+		}
+
+		f := File{Path: target, Source: c.source, Mode: mode, Data: data, Secret: secret}
+		if secret {
+			f.Digest = opts.Keys.digest(data)
+		}
+		files = append(files, f)
+	}
+
+	slices.SortFunc(files, func(a, b File) int { return strings.Compare(a.Path, b.Path) })
+	return files, nil
+}
+
+// resolve maps a source file name to its published name. ok is false when the
+// file is a variant for another environment and must not be published.
+func (o Options) resolve(name string) (string, candidate, bool) {
+	var c candidate
+
+	switch {
+	case o.Templates && strings.HasSuffix(name, TemplateSuffix) && name != TemplateSuffix:
+		name = strings.TrimSuffix(name, TemplateSuffix)
+		c.template = true
+	case o.Keys != nil && strings.HasSuffix(name, AgeSuffix) && name != AgeSuffix:
+		name = strings.TrimSuffix(name, AgeSuffix)
+		c.age = true
+	}
+
+	if o.Environment == "" {
+		return name, c, true
+	}
+
+	ext := path.Ext(name)
+	stem := strings.TrimSuffix(name, ext)
+	envExt := path.Ext(stem)
+	if envExt == "" || envExt == stem {
+		return name, c, true
+	}
+
+	env := envExt[1:]
+	switch {
+	case env == o.Environment:
+		c.specific = true
+		return strings.TrimSuffix(stem, envExt) + ext, c, true
+	case slices.Contains(o.Environments, env):
+		return "", c, false
+	default:
+		return name, c, true
+	}
+}
+
+// pick chooses the source for a published path: an environment-specific
+// variant wins over the generic file, anything else is ambiguous.
+func pick(target string, cs []candidate) (candidate, error) {
+	if len(cs) == 1 {
+		return cs[0], nil
+	}
+
+	var specific []candidate
+	for _, c := range cs {
+		if c.specific {
+			specific = append(specific, c)
+		}
+	}
+	if len(specific) == 1 {
+		return specific[0], nil
+	}
+
+	sources := make([]string, 0, len(cs))
+	for _, c := range cs {
+		sources = append(sources, c.source)
+	}
+	slices.Sort(sources)
+	return candidate{}, fmt.Errorf("conflicting sources for %s: %s", target, strings.Join(sources, ", "))
+}
+
+// expand renders data as a Go template. Missing keys are an error rather than
+// "<no value>" so a typo never silently reaches the published tree.
+func expand(name string, data []byte, values map[string]any) ([]byte, error) {
+	tmpl, err := template.New(name).Option("missingkey=error").Parse(string(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse template %s: %w", name, err)
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, values); err != nil {
+		return nil, fmt.Errorf("failed to render template %s: %w", name, err)
+	}
+	return buf.Bytes(), nil
+}
+
+// Write writes files under target, creating directories as needed. Each file
+// is replaced atomically, so consumers never read a half-written file. It never
+// writes through a symlink already present in target, so a link left over
+// from an earlier publish cannot redirect a write outside the directory.
+func Write(target string, files []File) error {
+	if err := os.MkdirAll(target, 0755); err != nil {
+		return fmt.Errorf("failed to create target directory: %w", err)
+	}
+
+	for _, f := range files {
+		dst, err := prepare(target, f.Path)
+		if err != nil {
+			return err
+		}
+
+		if f.Link != "" {
+			if err := os.Remove(dst); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+			if err := os.Symlink(f.Link, dst); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := WriteAtomic(dst, f.Data, f.Mode); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// prepare creates the parent directories of rel under target and removes an
+// existing link at the destination. It fails if rel escapes target or any
+// parent directory is a symlink.
+func prepare(target, rel string) (string, error) {
+	clean := path.Clean(rel)
+	if clean == ".." || strings.HasPrefix(clean, "../") || path.IsAbs(clean) {
+		return "", fmt.Errorf("%s: %w", rel, ErrOutsideSource)
+	}
+
+	dir := target
+	parts := strings.Split(clean, "/")
+	for _, part := range parts[:len(parts)-1] {
+		dir = filepath.Join(dir, part)
+		info, err := os.Lstat(dir)
+		switch {
+		case os.IsNotExist(err):
+			if err := os.Mkdir(dir, 0755); err != nil {
+				return "", err
+			}
+		case err != nil:
+			return "", err
+		case info.Mode()&os.ModeSymlink != 0:
+			return "", fmt.Errorf("%s: refusing to write through symlink %s", rel, dir)
+		case !info.IsDir():
+			return "", fmt.Errorf("%s: %s is not a directory", rel, dir)
+		}
+	}
+
+	dst := filepath.Join(dir, parts[len(parts)-1])
+	if info, err := os.Lstat(dst); err == nil && info.Mode()&os.ModeSymlink != 0 {
+		if err := os.Remove(dst); err != nil {
+			return "", err
+		}
+	}
+	return dst, nil
+}
@@ -0,0 +1,168 @@
+package publish_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/davidaparicio/microsvcs/projects/git-sync/internal/publish"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeTree creates files (relative path -> content) under a temp directory.
+func writeTree(t *testing.T, files map[string]string) string {
+	t.Helper()
+	root := t.TempDir()
+	for rel, content := range files {
+		p := filepath.Join(root, filepath.FromSlash(rel))
+		require.NoError(t, os.MkdirAll(filepath.Dir(p), 0755))
+		require.NoError(t, os.WriteFile(p, []byte(content), 0644))
+	}
+	return root
+}
+
+func paths(files []publish.File) map[string]string {
+	out := make(map[string]string, len(files))
+	for _, f := range files {
+		out[f.Path] = string(f.Data)
+	}
+	return out
+}
+
+var envs = []string{"development", "staging", "production"}
+
+func TestBuild_NoEnvironmentCopiesEverything(t *testing.T) {
+	root := writeTree(t, map[string]string{
+		"flags.yaml":            "generic",
+		"flags.production.yaml": "prod",
+		".git/HEAD":             "ref",
+	})
+
+	files, err := publish.Build(root, publish.Options{Environments: envs})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		"flags.yaml":            "generic",
+		"flags.production.yaml": "prod",
+	}, paths(files))
+}
+
+func TestBuild_EnvironmentSelection(t *testing.T) {
+	root := writeTree(t, map[string]string{
+		"flags.yaml":             "generic",
+		"flags.production.yaml":  "prod",
+		"flags.staging.yaml":     "staging",
+		"nested/app.yaml":        "app",
+		"nested/app.staging.yml": "app-staging",
+		"notes.v2.md":            "unrelated dot",
+	})
+
+	files, err := publish.Build(root, publish.Options{Environment: "production", Environments: envs})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		"flags.yaml":      "prod",
+		"nested/app.yaml": "app",
+		"notes.v2.md":     "unrelated dot",
+	}, paths(files))
+
+	for _, f := range files {
+		if f.Path == "flags.yaml" {
+			assert.Equal(t, "flags.production.yaml", f.Source)
+		}
+	}
+}
+
+func TestBuild_SortedOutput(t *testing.T) {
+	root := writeTree(t, map[string]string{"c.yaml": "", "a/b.yaml": "", "b.yaml": ""})
+
+	files, err := publish.Build(root, publish.Options{})
+	require.NoError(t, err)
+	require.Len(t, files, 3)
+	assert.Equal(t, "a/b.yaml", files[0].Path)
+	assert.Equal(t, "b.yaml", files[1].Path)
+	assert.Equal(t, "c.yaml", files[2].Path)
+}
+
+func TestBuild_Templates(t *testing.T) {
+	root := writeTree(t, map[string]string{
+		"flags.yaml.tmpl":           "ns: {{ .Values.namespace }}\nenv: {{ .Environment }}\n",
+		"raw.tmpl.txt":              "{{ untouched }}",
+		"app.production.yaml.tmpl":  "replicas: {{ .Env.REPLICAS }}",
+		"app.development.yaml.tmpl": "ignored",
+		"literal.yaml":              "{{ not a template }}",
+	})
+
+	opts := publish.Options{
+		Environment:  "production",
+		Environments: envs,
+		Templates:    true,
+		Data: map[string]any{
+			"Environment": "production",
+			"Env":         map[string]string{"REPLICAS": "3"},
+			"Values":      map[string]any{"namespace": "color-production"},
+		},
+	}
+
+	files, err := publish.Build(root, opts)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		"flags.yaml":   "ns: color-production\nenv: production\n",
+		"raw.tmpl.txt": "{{ untouched }}",
+		"app.yaml":     "replicas: 3",
+		"literal.yaml": "{{ not a template }}",
+	}, paths(files))
+}
+
+func TestBuild_TemplatesDisabledCopiesVerbatim(t *testing.T) {
+	root := writeTree(t, map[string]string{"flags.yaml.tmpl": "{{ .Values.x }}"})
+
+	files, err := publish.Build(root, publish.Options{})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"flags.yaml.tmpl": "{{ .Values.x }}"}, paths(files))
+}
+
+func TestBuild_TemplateMissingKey(t *testing.T) {
+	root := writeTree(t, map[string]string{"flags.yaml.tmpl": "{{ .Values.missing }}"})
+
+	_, err := publish.Build(root, publish.Options{
+		Templates: true,
+		Data:      map[string]any{"Values": map[string]any{}},
+	})
+	assert.ErrorContains(t, err, "flags.yaml.tmpl")
+}
+
+func TestBuild_ConflictingSources(t *testing.T) {
+	root := writeTree(t, map[string]string{
+		"flags.yaml":      "plain",
+		"flags.yaml.tmpl": "templated",
+	})
+
+	_, err := publish.Build(root, publish.Options{Templates: true})
+	assert.ErrorContains(t, err, "conflicting sources for flags.yaml")
+}
+
+func TestBuild_SingleFile(t *testing.T) {
+	root := writeTree(t, map[string]string{"README.md": "hello"})
+
+	files, err := publish.Build(filepath.Join(root, "README.md"), publish.Options{})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"README.md": "hello"}, paths(files))
+}
+
+func TestWrite(t *testing.T) {
+	target := filepath.Join(t.TempDir(), "out")
+	files := []publish.File{
+		{Path: "flags.yaml", Mode: 0644, Data: []byte("a")},
+		{Path: "nested/run.sh", Mode: 0755, Data: []byte("b")},
+	}
+
+	require.NoError(t, publish.Write(target, files))
+
+	content, err := os.ReadFile(filepath.Join(target, "flags.yaml"))
+	require.NoError(t, err)
+	assert.Equal(t, "a", string(content))
+
+	info, err := os.Stat(filepath.Join(target, "nested", "run.sh"))
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0755), info.Mode().Perm())
+}
@@ -0,0 +1,103 @@
+package publish
+
+import (
+	"bytes"
+	"path"
+	"time"
+
+	"github.com/getsops/sops/v3/aes"
+	sopsage "github.com/getsops/sops/v3/age"
+	"github.com/getsops/sops/v3/cmd/sops/common"
+	"github.com/getsops/sops/v3/cmd/sops/formats"
+	"github.com/getsops/sops/v3/config"
+	"gopkg.in/yaml.v3"
+)
+
+// SOPS support covers YAML and JSON files whose data key is wrapped for age
+// recipients. The data key is opened with the configured identities; the
+// sops library decrypts the values and checks the MAC. The sops metadata is
+// removed before the file is published.
+
+// sopsFormat reports whether name is a YAML or JSON file that may be
+// SOPS-encrypted.
+func sopsFormat(name string) (formats.Format, bool) {
+	switch path.Ext(name) {
+	case ".yaml", ".yml":
+		return formats.Yaml, true
+	case ".json":
+		return formats.Json, true
+	}
+	return formats.Binary, false
+}
+
+// isSOPS reports whether data has a top-level "sops" key with a MAC.
+func isSOPS(data []byte) bool {
+	if !bytes.Contains(data, []byte("sops")) {
+		return false
+	}
+	var doc struct {
+		SOPS *struct {
+			MAC string `yaml:"mac"`
+		} `yaml:"sops"`
+	}
+	return yaml.Unmarshal(data, &doc) == nil && doc.SOPS != nil && doc.SOPS.MAC != ""
+}
+
+// decryptSOPS decrypts a SOPS file. ok is false when data is not SOPS-encrypted.
+func (k *Keys) decryptSOPS(name string, data []byte) ([]byte, bool, error) {
+	format, ok := sopsFormat(name)
+	if !ok || !isSOPS(data) {
+		return nil, false, nil
+	}
+
+	// The library's errors are too detailed to report as they are
+	fail := func(reason string) ([]byte, bool, error) {
+		return nil, true, &decryptError{path: name, reason: reason}
+	}
+
+	store := common.StoreForFormat(format, config.NewStoresConfig())
+	tree, err := store.LoadEncryptedFile(data)
+	if err != nil {
+		return fail("invalid sops metadata")
+	}
+	if len(tree.Metadata.KeyGroups) > 1 {
+		return fail("sops key groups are not supported")
+	}
+
+	var recipients []*sopsage.MasterKey
+	for _, group := range tree.Metadata.KeyGroups {
+		for _, key := range group {
+			if ak, ok := key.(*sopsage.MasterKey); ok {
+				recipients = append(recipients, ak)
+			}
+		}
+	}
+	if len(recipients) == 0 {
+		return fail("sops data key is not encrypted for any age recipient")
+	}
+	var dataKey []byte
+	for _, r := range recipients {
+		if dataKey, err = k.decrypt([]byte(r.EncryptedKey)); err == nil {
+			break
+		}
+	}
+	if dataKey == nil {
+		return fail("no configured age key matches its recipients")
+	}
+
+	cipher := aes.NewCipher()
+	mac, err := tree.Decrypt(dataKey, cipher)
+	if err != nil {
+		return fail("invalid sops file")
+	}
+	want, err := cipher.Decrypt(tree.Metadata.MessageAuthenticationCode, dataKey, tree.Metadata.LastModified.Format(time.RFC3339))
+	if err != nil || want != mac {
+		return fail("MAC mismatch")
+	}
+
+	plain, err := store.EmitPlainFile(tree.Branches)
+	if err != nil {
+		return fail("invalid sops file")
+	}
+	return plain, true, nil
+}
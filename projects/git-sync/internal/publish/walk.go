@@ -0,0 +1,184 @@
+package publish
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// SymlinkPolicy decides what happens to symbolic links found in the source tree.
+type SymlinkPolicy string
+
+const (
+	// SymlinksDereference publishes the content a link points to, provided
+	// it resolves inside the repository.
+	SymlinksDereference SymlinkPolicy = "dereference"
+	// SymlinksPreserve publishes links as links. Only relative links that
+	// resolve inside the source path are accepted, so they stay valid in
+	// the target directory.
+	SymlinksPreserve SymlinkPolicy = "preserve"
+	// SymlinksRefuse fails the build on any link.
+	SymlinksRefuse SymlinkPolicy = "refuse"
+)
+
+// Valid reports whether p is a known policy.
+func (p SymlinkPolicy) Valid() bool {
+	switch p {
+	case SymlinksDereference, SymlinksPreserve, SymlinksRefuse:
+		return true
+	}
+	return false
+}
+
+// ErrOutsideSource is returned when a path or link resolves outside the tree
+// it is allowed to read from.
+var ErrOutsideSource = errors.New("resolves outside the source tree")
+
+// walker collects candidate files from a source tree.
+type walker struct {
+	opts     Options
+	repoRoot string // fully resolved; dereferenced links must stay inside
+	srcRoot  string // fully resolved; preserved links must stay inside
+	add      func(abs, rel, link string, info os.FileInfo)
+	visiting map[string]bool // resolved directories on the current path, for cycle detection
+}
+
+// newWalker resolves root and the repository root, refusing a root that
+// escapes the repository (e.g. GIT_SOURCE_PATH=../../etc).
+func newWalker(root string, opts Options, add func(abs, rel, link string, info os.FileInfo)) (*walker, string, error) {
+	repoRoot := opts.RepoRoot
+	if repoRoot == "" {
+		repoRoot = root
+	}
+	resolvedRepo, err := filepath.EvalSymlinks(repoRoot)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to resolve repository root: %w", err)
+	}
+	resolvedRoot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to stat source path: %w", err)
+	}
+	if !within(resolvedRepo, resolvedRoot) || inGitDir(resolvedRepo, resolvedRoot) {
+		return nil, "", fmt.Errorf("source path %s: %w", root, ErrOutsideSource)
+	}
+
+	return &walker{
+		opts:     opts,
+		repoRoot: resolvedRepo,
+		srcRoot:  resolvedRoot,
+		add:      add,
+		visiting: map[string]bool{},
+	}, resolvedRoot, nil
+}
+
+// walk visits dir (a resolved directory) and publishes its entries under rel.
+func (w *walker) walk(dir, rel string) error {
+	w.visiting[dir] = true
+	defer delete(w.visiting, dir)
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	for _, e := range entries {
+		abs := filepath.Join(dir, e.Name())
+		r := path.Join(rel, e.Name())
+
+		info, err := os.Lstat(abs)
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case info.Mode()&os.ModeSymlink != 0:
+			err = w.link(abs, r)
+		case info.IsDir():
+			// Skip .git directory
+			if e.Name() == ".git" {
+				continue
+			}
+			err = w.walk(abs, r)
+		case info.Mode().IsRegular():
+			w.add(abs, r, "", info)
+		default:
+			err = fmt.Errorf("%s: unsupported file type %s", r, info.Mode().Type())
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// link applies the symlink policy to the link at abs.
+func (w *walker) link(abs, rel string) error {
+	switch w.opts.Symlinks {
+	case SymlinksRefuse:
+		return fmt.Errorf("%s: symlinks are not allowed", rel)
+
+	case SymlinksPreserve:
+		target, err := os.Readlink(abs)
+		if err != nil {
+			return err
+		}
+		if filepath.IsAbs(target) {
+			return fmt.Errorf("%s -> %s: absolute link %w", rel, target, ErrOutsideSource)
+		}
+		if lexical := path.Join(path.Dir(rel), filepath.ToSlash(target)); lexical == ".." || strings.HasPrefix(lexical, "../") {
+			return fmt.Errorf("%s -> %s: %w", rel, target, ErrOutsideSource)
+		}
+		resolved, err := filepath.EvalSymlinks(abs)
+		if err != nil {
+			return fmt.Errorf("%s: broken symlink: %w", rel, err)
+		}
+		if !within(w.srcRoot, resolved) || inGitDir(w.repoRoot, resolved) {
+			return fmt.Errorf("%s -> %s: %w", rel, target, ErrOutsideSource)
+		}
+		w.add(abs, rel, target, nil)
+		return nil
+
+	default:
+		resolved, err := filepath.EvalSymlinks(abs)
+		if err != nil {
+			return fmt.Errorf("%s: broken symlink: %w", rel, err)
+		}
+		if !within(w.repoRoot, resolved) || inGitDir(w.repoRoot, resolved) {
+			return fmt.Errorf("%s: %w", rel, ErrOutsideSource)
+		}
+		info, err := os.Stat(resolved)
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			if w.visiting[resolved] {
+				return fmt.Errorf("%s: symlink cycle", rel)
+			}
+			return w.walk(resolved, rel)
+		}
+		if !info.Mode().IsRegular() {
+			return fmt.Errorf("%s: unsupported file type %s", rel, info.Mode().Type())
+		}
+		w.add(resolved, rel, "", info)
+		return nil
+	}
+}
+
+// inGitDir reports whether p is the .git directory of the checkout at
+// repoRoot or lies beneath it. Its config holds the remote, and possibly
+// credentials, so no link may publish it.
+func inGitDir(repoRoot, p string) bool {
+	return within(filepath.Join(repoRoot, ".git"), p)
+}
+
+// within reports whether p is root or lies beneath it. Both must be clean.
+func within(root, p string) bool {
+	rel, err := filepath.Rel(root, p)
+	if err != nil {
+		return false
+	}
+	return rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) && !filepath.IsAbs(rel)
+}
@@ -0,0 +1,226 @@
+// Package restart triggers rolling restarts of workloads that only read
+// their config at startup, by stamping the published commit on their pod
+// template.
+package restart
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+// Annotation is set on the pod template; changing it rolls the workload.
+const Annotation = "git-sync/commit"
+
+// Workload kinds that can be restarted.
+const (
+	KindDeployment  = "Deployment"
+	KindStatefulSet = "StatefulSet"
+)
+
+// serviceAccountNamespace holds the pod's namespace when running in a cluster.
+const serviceAccountNamespace = "/var/run/secrets/kubernetes.io/serviceaccount/namespace"
+
+// Target is a workload to restart.
+type Target struct {
+	Kind      string `json:"kind"`
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+}
+
+func (t Target) String() string {
+	return strings.ToLower(t.Kind) + "/" + t.Namespace + "/" + t.Name
+}
+
+// ParseTargets parses entries of the form kind/name or namespace/kind/name,
+// where kind is deployment or statefulset (kubectl short names work too).
+func ParseTargets(entries []string, namespace string) ([]Target, error) {
+	targets := make([]Target, 0, len(entries))
+	for _, entry := range entries {
+		parts := strings.Split(entry, "/")
+		ns := namespace
+		if len(parts) == 3 {
+			ns, parts = parts[0], parts[1:]
+		}
+		if len(parts) != 2 || parts[1] == "" || ns == "" {
+			return nil, fmt.Errorf("invalid restart target %q, want [namespace/]kind/name", entry)
+		}
+
+		var kind string
+		switch strings.ToLower(parts[0]) {
+		case "deployment", "deployments", "deploy":
+			kind = KindDeployment
+		case "statefulset", "statefulsets", "sts":
+			kind = KindStatefulSet
+		default:
+			return nil, fmt.Errorf("invalid restart target %q: kind must be deployment or statefulset", entry)
+		}
+		targets = append(targets, Target{Kind: kind, Namespace: ns, Name: parts[1]})
+	}
+	return targets, nil
+}
+
+// CurrentNamespace returns the namespace of the pod git-sync runs in, or
+// "default" outside a cluster.
+func CurrentNamespace() string {
+	if ns, err := os.ReadFile(serviceAccountNamespace); err == nil {
+		if s := strings.TrimSpace(string(ns)); s != "" {
+			return s
+		}
+	}
+	return "default"
+}
+
+// NewInClusterClient returns a clientset authenticated with the pod's
+// service account.
+func NewInClusterClient() (kubernetes.Interface, error) {
+	cfg, err := rest.InClusterConfig()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load in-cluster config: %w", err)
+	}
+	return kubernetes.NewForConfig(cfg)
+}
+
+// Status describes the restart state of one target.
+type Status struct {
+	Target
+	Commit      string    `json:"commit,omitempty"`      // last commit stamped on the workload
+	RestartedAt time.Time `json:"restartedAt,omitempty"` // zero until the first restart
+	Pending     string    `json:"pending,omitempty"`     // commit waiting for the rate limit
+	LastError   string    `json:"lastError,omitempty"`
+}
+
+// Restarter patches targets after publishes that touched watched paths.
+// Each target is restarted at most once per interval; a change arriving
+// sooner is kept pending and applied by a later Notify.
+type Restarter struct {
+	client   kubernetes.Interface
+	paths    []string // watched path prefixes, empty watches everything
+	interval time.Duration
+	now      func() time.Time
+
+	mu     sync.Mutex
+	states map[Target]*Status
+	order  []Target
+}
+
+// New returns a Restarter for targets. paths are slash-separated prefixes
+// relative to the published tree.
+func New(client kubernetes.Interface, targets []Target, paths []string, interval time.Duration) *Restarter {
+	r := &Restarter{
+		client:   client,
+		interval: interval,
+		now:      time.Now,
+		states:   make(map[Target]*Status, len(targets)),
+	}
+	for _, p := range paths {
+		if p = strings.Trim(path.Clean("/"+p), "/"); p != "" {
+			r.paths = append(r.paths, p)
+		}
+	}
+	for _, t := range targets {
+		if _, dup := r.states[t]; !dup {
+			r.states[t] = &Status{Target: t}
+			r.order = append(r.order, t)
+		}
+	}
+	return r
+}
+
+// Watched reports whether any of changed lies under a watched path.
+func (r *Restarter) Watched(changed []string) bool {
+	if len(r.paths) == 0 {
+		return len(changed) > 0
+	}
+	return slices.ContainsFunc(changed, func(p string) bool {
+		return slices.ContainsFunc(r.paths, func(w string) bool {
+			return p == w || strings.HasPrefix(p, w+"/")
+		})
+	})
+}
+
+// Notify is called after every publish with the commit now published and
+// the paths that changed. It restarts targets when watched paths changed,
+// and flushes restarts held back by the rate limit. Errors are recorded per
+// target and returned joined; they never undo the publish.
+func (r *Restarter) Notify(ctx context.Context, commit string, changed []string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	watched := r.Watched(changed)
+	var errs []error
+	for _, t := range r.order {
+		st := r.states[t]
+		if watched {
+			st.Pending = commit
+		}
+		if st.Pending == "" {
+			continue
+		}
+		if !st.RestartedAt.IsZero() && r.now().Sub(st.RestartedAt) < r.interval {
+			continue
+		}
+
+		if err := r.patch(ctx, t, st.Pending); err != nil {
+			st.LastError = err.Error()
+			errs = append(errs, fmt.Errorf("%s: %w", t, err))
+			continue
+		}
+		fmt.Printf("[%s] Restarted %s for commit %s\n", r.now().Format(time.RFC3339), t, st.Pending)
+		st.Commit, st.RestartedAt, st.Pending, st.LastError = st.Pending, r.now(), "", ""
+	}
+	return errors.Join(errs...)
+}
+
+// patch sets the annotation on the pod template, which rolls the workload.
+func (r *Restarter) patch(ctx context.Context, t Target, commit string) error {
+	patch, err := json.Marshal(map[string]any{
+		"spec": map[string]any{
+			"template": map[string]any{
+				"metadata": map[string]any{
+					"annotations": map[string]string{Annotation: commit},
+				},
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	switch t.Kind {
+	case KindDeployment:
+		_, err = r.client.AppsV1().Deployments(t.Namespace).Patch(ctx, t.Name, types.MergePatchType, patch, metav1.PatchOptions{FieldManager: "git-sync"})
+	case KindStatefulSet:
+		_, err = r.client.AppsV1().StatefulSets(t.Namespace).Patch(ctx, t.Name, types.MergePatchType, patch, metav1.PatchOptions{FieldManager: "git-sync"})
+	default:
+		err = fmt.Errorf("unsupported kind %q", t.Kind)
+	}
+	return err
+}
+
+// Status returns the state of every target, in configuration order. A nil
+// Restarter has no targets.
+func (r *Restarter) Status() []Status {
+	if r == nil {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	out := make([]Status, 0, len(r.order))
+	for _, t := range r.order {
+		out = append(out, *r.states[t])
+	}
+	return out
+}
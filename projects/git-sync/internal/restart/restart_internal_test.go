@@ -0,0 +1,142 @@
+package restart
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestParseTargets(t *testing.T) {
+	targets, err := ParseTargets([]string{"deployment/api", "sts/cache", "other/deploy/web"}, "flags")
+	require.NoError(t, err)
+	assert.Equal(t, []Target{
+		{Kind: KindDeployment, Namespace: "flags", Name: "api"},
+		{Kind: KindStatefulSet, Namespace: "flags", Name: "cache"},
+		{Kind: KindDeployment, Namespace: "other", Name: "web"},
+	}, targets)
+
+	for _, bad := range []string{"api", "daemonset/agent", "deployment/", "a/b/c/d"} {
+		_, err := ParseTargets([]string{bad}, "flags")
+		assert.Error(t, err, bad)
+	}
+}
+
+func TestWatched(t *testing.T) {
+	r := New(nil, nil, []string{"flags", "/exporters/"}, 0)
+
+	assert.True(t, r.Watched([]string{"flags/demo.yaml"}))
+	assert.True(t, r.Watched([]string{"README.md", "exporters/otel.yaml"}))
+	assert.False(t, r.Watched([]string{"README.md", "flags-old/demo.yaml"}))
+	assert.False(t, r.Watched(nil))
+
+	all := New(nil, nil, nil, 0)
+	assert.True(t, all.Watched([]string{"anything"}))
+	assert.False(t, all.Watched(nil))
+}
+
+func workloads() []runtime.Object {
+	meta := func(name string) metav1.ObjectMeta { return metav1.ObjectMeta{Name: name, Namespace: "flags"} }
+	return []runtime.Object{
+		&appsv1.Deployment{ObjectMeta: meta("api")},
+		&appsv1.StatefulSet{ObjectMeta: meta("cache")},
+	}
+}
+
+func annotation(t *testing.T, client *fake.Clientset) (deploy, sts string) {
+	t.Helper()
+	ctx := context.Background()
+	d, err := client.AppsV1().Deployments("flags").Get(ctx, "api", metav1.GetOptions{})
+	require.NoError(t, err)
+	s, err := client.AppsV1().StatefulSets("flags").Get(ctx, "cache", metav1.GetOptions{})
+	require.NoError(t, err)
+	return d.Spec.Template.Annotations[Annotation], s.Spec.Template.Annotations[Annotation]
+}
+
+func TestNotify_PatchesPodTemplates(t *testing.T) {
+	client := fake.NewClientset(workloads()...)
+	targets, err := ParseTargets([]string{"deployment/api", "statefulset/cache"}, "flags")
+	require.NoError(t, err)
+	r := New(client, targets, []string{"flags"}, 0)
+	ctx := context.Background()
+
+	// Unwatched changes do nothing
+	require.NoError(t, r.Notify(ctx, "aaa", []string{"docs/README.md"}))
+	assert.Empty(t, client.Actions())
+
+	require.NoError(t, r.Notify(ctx, "bbb", []string{"flags/demo.yaml"}))
+	d, s := annotation(t, client)
+	assert.Equal(t, "bbb", d)
+	assert.Equal(t, "bbb", s)
+
+	for _, st := range r.Status() {
+		assert.Equal(t, "bbb", st.Commit)
+		assert.False(t, st.RestartedAt.IsZero())
+		assert.Empty(t, st.Pending)
+	}
+}
+
+func TestNotify_RateLimitKeepsRestartPending(t *testing.T) {
+	client := fake.NewClientset(workloads()...)
+	targets, err := ParseTargets([]string{"deployment/api"}, "flags")
+	require.NoError(t, err)
+	r := New(client, targets, nil, 5*time.Minute)
+
+	now := time.Date(2026, 1, 29, 10, 0, 0, 0, time.UTC)
+	r.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	require.NoError(t, r.Notify(ctx, "c1", []string{"flags.yaml"}))
+	now = now.Add(time.Minute)
+	require.NoError(t, r.Notify(ctx, "c2", []string{"flags.yaml"}))
+	d, _ := annotation(t, client)
+	assert.Equal(t, "c1", d, "second restart within the interval is held back")
+	assert.Equal(t, "c2", r.Status()[0].Pending)
+
+	// A later sync without changes flushes the pending restart
+	now = now.Add(5 * time.Minute)
+	require.NoError(t, r.Notify(ctx, "c2", nil))
+	d, _ = annotation(t, client)
+	assert.Equal(t, "c2", d)
+	assert.Empty(t, r.Status()[0].Pending)
+}
+
+func TestNotify_ErrorsAreRecordedAndRetried(t *testing.T) {
+	client := fake.NewClientset(workloads()...)
+	failing := true
+	client.PrependReactor("patch", "deployments", func(k8stesting.Action) (bool, runtime.Object, error) {
+		if failing {
+			return true, nil, errors.New("forbidden: cannot patch deployments")
+		}
+		return false, nil, nil
+	})
+
+	targets, err := ParseTargets([]string{"deployment/api", "statefulset/cache", "deployment/missing"}, "flags")
+	require.NoError(t, err)
+	r := New(client, targets, nil, 0)
+	ctx := context.Background()
+
+	err = r.Notify(ctx, "c1", []string{"flags.yaml"})
+	assert.ErrorContains(t, err, "forbidden")
+	_, s := annotation(t, client)
+	assert.Equal(t, "c1", s, "one failing target does not block the others")
+
+	st := r.Status()
+	assert.Equal(t, "c1", st[0].Pending)
+	assert.Contains(t, st[0].LastError, "forbidden")
+
+	failing = false
+	err = r.Notify(ctx, "c1", nil)
+	assert.ErrorContains(t, err, "missing")
+	d, _ := annotation(t, client)
+	assert.Equal(t, "c1", d)
+	assert.Empty(t, r.Status()[0].LastError)
+}
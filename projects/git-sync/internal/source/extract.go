@@ -0,0 +1,291 @@
+package source
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/davidaparicio/microsvcs/projects/git-sync/internal/publish"
+)
+
+// budget caps the bytes written while extracting a fetch, so a compressed
+// bomb trips MAX_FETCH_SIZE instead of filling the disk.
+type budget struct {
+	max  int64 // 0 disables the limit
+	used int64
+}
+
+// copy streams src into dst, charging the bytes to the budget.
+func (b *budget) copy(dst io.Writer, src io.Reader) error {
+	if b.max <= 0 {
+		n, err := io.Copy(dst, src)
+		b.used += n
+		return err
+	}
+	n, err := io.Copy(dst, io.LimitReader(src, b.max-b.used+1))
+	b.used += n
+	if err != nil {
+		return err
+	}
+	if b.used > b.max {
+		return &publish.LimitError{Limit: "fetch-size", Max: b.max, Actual: b.used}
+	}
+	return nil
+}
+
+// extractor writes archive entries under dir. Links are created only after
+// every regular file has been written, and no entry is ever written through a
+// link, so an archive cannot plant a link and then write through it.
+type extractor struct {
+	dir    string
+	budget *budget
+	links  map[string]string
+}
+
+func newExtractor(dir string, b *budget) *extractor {
+	return &extractor{dir: dir, budget: b, links: map[string]string{}}
+}
+
+// clean validates an entry name and returns it relative to dir. Absolute
+// names and names climbing out with ".." are refused (zip-slip).
+func clean(name string) (string, error) {
+	name = strings.ReplaceAll(name, "\\", "/")
+	rel := path.Clean(name)
+	if path.IsAbs(rel) || rel == ".." || strings.HasPrefix(rel, "../") {
+		return "", fmt.Errorf("archive entry %s: %w", name, publish.ErrOutsideSource)
+	}
+	return rel, nil
+}
+
+// parent creates the parent directories of rel, refusing to traverse links.
+func (e *extractor) parent(rel string) (string, error) {
+	dir := e.dir
+	parts := strings.Split(rel, "/")
+	for _, part := range parts[:len(parts)-1] {
+		dir = filepath.Join(dir, part)
+		info, err := os.Lstat(dir)
+		switch {
+		case errors.Is(err, os.ErrNotExist):
+			if err := os.Mkdir(dir, 0755); err != nil {
+				return "", err
+			}
+		case err != nil:
+			return "", err
+		case !info.IsDir():
+			return "", fmt.Errorf("archive entry %s: %s is not a directory", rel, dir)
+		}
+	}
+	return filepath.Join(dir, parts[len(parts)-1]), nil
+}
+
+func (e *extractor) dirEntry(name string) error {
+	rel, err := clean(name)
+	if err != nil || rel == "." {
+		return err
+	}
+	// parent creates every component before the last one
+	_, err = e.parent(rel + "/.")
+	return err
+}
+
+func (e *extractor) file(name string, mode os.FileMode, r io.Reader) error {
+	rel, err := clean(name)
+	if err != nil {
+		return err
+	}
+	dst, err := e.parent(rel)
+	if err != nil {
+		return err
+	}
+
+	perm := os.FileMode(0644)
+	if mode&0111 != 0 {
+		perm = 0755
+	}
+	f, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return fmt.Errorf("archive entry %s: %w", name, err)
+	}
+	if err := e.budget.copy(f, r); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// link records a symlink to create once all files are in place. Whether it
+// may be published is decided later by SYMLINK_POLICY, like for git.
+func (e *extractor) link(name, target string) error {
+	rel, err := clean(name)
+	if err != nil {
+		return err
+	}
+	e.links[rel] = target
+	return nil
+}
+
+// finish creates the recorded links.
+func (e *extractor) finish() error {
+	for rel, target := range e.links {
+		dst, err := e.parent(rel)
+		if err != nil {
+			return err
+		}
+		if err := os.Symlink(target, dst); err != nil {
+			return fmt.Errorf("archive entry %s: %w", rel, err)
+		}
+	}
+	return nil
+}
+
+// extractTar unpacks a tar stream into dir.
+func extractTar(dir string, r io.Reader, b *budget) error {
+	e := newExtractor(dir, b)
+	if err := e.tar(r); err != nil {
+		return err
+	}
+	return e.finish()
+}
+
+// tar writes the entries of a tar stream. Several streams may be layered
+// into one extractor before finish is called.
+func (e *extractor) tar(r io.Reader) error {
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read tar archive: %w", err)
+		}
+
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			err = e.dirEntry(hdr.Name)
+		case tar.TypeReg:
+			err = e.file(hdr.Name, hdr.FileInfo().Mode(), tr)
+		case tar.TypeSymlink:
+			err = e.link(hdr.Name, hdr.Linkname)
+		case tar.TypeXGlobalHeader:
+			continue
+		default:
+			err = fmt.Errorf("archive entry %s: unsupported entry type %q", hdr.Name, hdr.Typeflag)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// extractZip unpacks the zip file at src into dir.
+func extractZip(dir, src string, b *budget) error {
+	zr, err := zip.OpenReader(src)
+	if err != nil {
+		return fmt.Errorf("failed to read zip archive: %w", err)
+	}
+	defer func() { _ = zr.Close() }()
+
+	e := newExtractor(dir, b)
+	for _, zf := range zr.File {
+		mode := zf.Mode()
+		switch {
+		case mode.IsDir():
+			err = e.dirEntry(zf.Name)
+		case mode&os.ModeSymlink != 0:
+			err = e.zipLink(zf)
+		case mode.IsRegular():
+			err = e.zipFile(zf)
+		default:
+			err = fmt.Errorf("archive entry %s: unsupported file type %s", zf.Name, mode.Type())
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return e.finish()
+}
+
+func (e *extractor) zipFile(zf *zip.File) error {
+	rc, err := zf.Open()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = rc.Close() }()
+	return e.file(zf.Name, zf.Mode(), rc)
+}
+
+func (e *extractor) zipLink(zf *zip.File) error {
+	rc, err := zf.Open()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = rc.Close() }()
+	target, err := io.ReadAll(io.LimitReader(rc, 4096))
+	if err != nil {
+		return err
+	}
+	return e.link(zf.Name, string(target))
+}
+
+// extractArchive unpacks the archive at src into dir according to format.
+func extractArchive(dir, src, format string, b *budget) error {
+	if format == formatZip {
+		return extractZip(dir, src, b)
+	}
+
+	f, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = f.Close() }()
+
+	var r io.Reader = f
+	if format == formatTarGz {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return fmt.Errorf("failed to read gzip archive: %w", err)
+		}
+		defer func() { _ = gz.Close() }()
+		r = gz
+	}
+	return extractTar(dir, r, b)
+}
+
+// Archive formats understood by the HTTP source.
+const (
+	formatTar   = "tar"
+	formatTarGz = "tar.gz"
+	formatZip   = "zip"
+)
+
+// archiveFormat picks the format from the URL path, falling back to the
+// response Content-Type.
+func archiveFormat(urlPath, contentType string) (string, error) {
+	switch p := strings.ToLower(urlPath); {
+	case strings.HasSuffix(p, ".tar.gz"), strings.HasSuffix(p, ".tgz"):
+		return formatTarGz, nil
+	case strings.HasSuffix(p, ".tar"):
+		return formatTar, nil
+	case strings.HasSuffix(p, ".zip"):
+		return formatZip, nil
+	}
+
+	mediaType, _, _ := strings.Cut(contentType, ";")
+	switch strings.TrimSpace(mediaType) {
+	case "application/gzip", "application/x-gzip", "application/x-tar+gzip":
+		return formatTarGz, nil
+	case "application/x-tar":
+		return formatTar, nil
+	case "application/zip", "application/x-zip-compressed":
+		return formatZip, nil
+	}
+	return "", fmt.Errorf("cannot tell archive format of %s (Content-Type %q); use a .tar.gz, .tgz, .tar or .zip URL", urlPath, contentType)
+}
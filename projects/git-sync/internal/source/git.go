@@ -0,0 +1,46 @@
+package source
+
+import (
+	"context"
+
+	"github.com/davidaparicio/microsvcs/projects/git-sync/internal/config"
+	"github.com/davidaparicio/microsvcs/projects/git-sync/internal/git"
+)
+
+// gitSource adapts git.Client to the Source interface.
+type gitSource struct {
+	cfg    *config.Config
+	client *git.Client
+}
+
+func newGit(cfg *config.Config) (*gitSource, error) {
+	client, err := git.NewClient(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &gitSource{cfg: cfg, client: client}, nil
+}
+
+func (g *gitSource) Fetch(ctx context.Context) (Revision, error) {
+	commit, err := g.client.Sync(ctx)
+	if err != nil {
+		return Revision{}, err
+	}
+	return Revision{ID: commit.Hash, Author: commit.Author, Time: commit.Time}, nil
+}
+
+func (g *gitSource) WorkDir() string {
+	return g.client.WorkDir()
+}
+
+func (g *gitSource) Location() (string, string) {
+	return RedactURL(g.cfg.RepoURL), g.cfg.Branch
+}
+
+func (g *gitSource) DiskUsage() (int64, error) {
+	return g.client.DiskUsage()
+}
+
+func (g *gitSource) Close() error {
+	return g.client.Close()
+}
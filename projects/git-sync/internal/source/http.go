@@ -0,0 +1,142 @@
+package source
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/davidaparicio/microsvcs/projects/git-sync/internal/config"
+)
+
+// httpSource downloads a tar.gz, tar or zip archive. The ETag of the last
+// download is sent back as If-None-Match, so an unchanged archive costs a
+// single 304 response.
+type httpSource struct {
+	url     string
+	client  *http.Client
+	ws      *workspace
+	maxSize int64
+
+	etag string
+	rev  Revision
+}
+
+func newHTTP(cfg *config.Config) (*httpSource, error) {
+	if _, err := url.Parse(cfg.SourceURL); err != nil {
+		return nil, fmt.Errorf("invalid SOURCE_URL: %w", err)
+	}
+	ws, err := newWorkspace()
+	if err != nil {
+		return nil, err
+	}
+	return &httpSource{
+		url:     cfg.SourceURL,
+		client:  &http.Client{Timeout: 5 * time.Minute},
+		ws:      ws,
+		maxSize: cfg.MaxFetchSize,
+	}, nil
+}
+
+func (h *httpSource) Fetch(ctx context.Context) (Revision, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, h.url, nil)
+	if err != nil {
+		return Revision{}, err
+	}
+	if h.etag != "" && h.rev.ID != "" {
+		req.Header.Set("If-None-Match", h.etag)
+	}
+
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return Revision{}, fmt.Errorf("failed to download archive: %w", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotModified:
+		return h.rev, nil
+	default:
+		return Revision{}, fmt.Errorf("failed to download archive: unexpected status %s", resp.Status)
+	}
+
+	format, err := archiveFormat(resp.Request.URL.Path, resp.Header.Get("Content-Type"))
+	if err != nil {
+		return Revision{}, err
+	}
+
+	// Spool the archive to disk: zip needs random access, and hashing the
+	// body detects changes on servers that do not send an ETag
+	archive := filepath.Join(h.ws.root, "archive")
+	digest, err := h.spool(archive, resp.Body)
+	if err != nil {
+		return Revision{}, err
+	}
+	defer func() { _ = os.Remove(archive) }()
+
+	rev := Revision{ID: digest}
+	if t, err := http.ParseTime(resp.Header.Get("Last-Modified")); err == nil {
+		rev.Time = t.UTC()
+	}
+
+	if digest != h.rev.ID {
+		stage, err := h.ws.stage()
+		if err != nil {
+			return Revision{}, err
+		}
+		if err := extractArchive(stage, archive, format, &budget{max: h.maxSize}); err != nil {
+			return Revision{}, err
+		}
+		if err := h.ws.commit(); err != nil {
+			return Revision{}, err
+		}
+	}
+
+	h.etag = resp.Header.Get("ETag")
+	h.rev = rev
+	return rev, nil
+}
+
+// spool writes body to dst and returns its SHA-256.
+func (h *httpSource) spool(dst string, body io.Reader) (string, error) {
+	f, err := os.Create(dst)
+	if err != nil {
+		return "", err
+	}
+	defer func() { _ = f.Close() }()
+
+	hash := sha256.New()
+	if err := (&budget{max: h.maxSize}).copy(io.MultiWriter(f, hash), body); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), f.Close()
+}
+
+func (h *httpSource) WorkDir() string {
+	return h.ws.dir()
+}
+
+func (h *httpSource) Location() (string, string) {
+	// Pre-signed URLs carry their signature in the query string
+	u, err := url.Parse(RedactURL(h.url))
+	if err != nil {
+		return RedactURL(h.url), ""
+	}
+	u.RawQuery = ""
+	return u.String(), ""
+}
+
+func (h *httpSource) DiskUsage() (int64, error) {
+	return diskUsage(h.ws.dir())
+}
+
+func (h *httpSource) Close() error {
+	return h.ws.close()
+}
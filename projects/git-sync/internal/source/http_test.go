@@ -0,0 +1,200 @@
+package source_test
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	"github.com/davidaparicio/microsvcs/projects/git-sync/internal/config"
+	"github.com/davidaparicio/microsvcs/projects/git-sync/internal/publish"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// entry is an archive member; a non-empty link makes it a symlink.
+type entry struct {
+	name, body, link string
+}
+
+func tarGz(t *testing.T, entries ...entry) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for _, e := range entries {
+		hdr := &tar.Header{Name: e.name, Mode: 0644, Size: int64(len(e.body)), Typeflag: tar.TypeReg}
+		if e.link != "" {
+			hdr = &tar.Header{Name: e.name, Mode: 0777, Linkname: e.link, Typeflag: tar.TypeSymlink}
+		}
+		require.NoError(t, tw.WriteHeader(hdr))
+		_, err := tw.Write([]byte(e.body))
+		require.NoError(t, err)
+	}
+	require.NoError(t, tw.Close())
+	require.NoError(t, gz.Close())
+	return buf.Bytes()
+}
+
+func zipArchive(t *testing.T, entries ...entry) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, e := range entries {
+		w, err := zw.Create(e.name)
+		require.NoError(t, err)
+		_, err = w.Write([]byte(e.body))
+		require.NoError(t, err)
+	}
+	require.NoError(t, zw.Close())
+	return buf.Bytes()
+}
+
+// archiveServer serves *body with an ETag and counts full downloads.
+func archiveServer(t *testing.T, body *[]byte, etag *string) (*httptest.Server, *atomic.Int32) {
+	t.Helper()
+	var downloads atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if *etag != "" {
+			if r.Header.Get("If-None-Match") == *etag {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+			w.Header().Set("ETag", *etag)
+		}
+		downloads.Add(1)
+		_, _ = w.Write(*body)
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &downloads
+}
+
+func TestHTTP_ETagCaching(t *testing.T) {
+	body := tarGz(t, entry{name: "flags.yaml", body: "v1"}, entry{name: "nested/app.yaml", body: "app"})
+	etag := `"v1"`
+	srv, downloads := archiveServer(t, &body, &etag)
+
+	src := newSource(t, &config.Config{SourceType: "http", SourceURL: srv.URL + "/config.tar.gz"})
+
+	rev1, err := src.Fetch(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"flags.yaml": "v1", "nested/app.yaml": "app"}, tree(t, src.WorkDir()))
+
+	// Unchanged: answered with 304, same revision
+	rev2, err := src.Fetch(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, rev1, rev2)
+	assert.Equal(t, int32(1), downloads.Load())
+
+	body = tarGz(t, entry{name: "flags.yaml", body: "v2"})
+	etag = `"v2"`
+	rev3, err := src.Fetch(context.Background())
+	require.NoError(t, err)
+	assert.NotEqual(t, rev1.ID, rev3.ID)
+	assert.Equal(t, map[string]string{"flags.yaml": "v2"}, tree(t, src.WorkDir()))
+	assert.Equal(t, int32(2), downloads.Load())
+}
+
+func TestHTTP_ZipWithoutETag(t *testing.T) {
+	body := zipArchive(t, entry{name: "config/flags.yaml", body: "zip"})
+	etag := ""
+	srv, _ := archiveServer(t, &body, &etag)
+
+	src := newSource(t, &config.Config{SourceType: "http", SourceURL: srv.URL + "/bundle.zip?token=secret"})
+
+	rev, err := src.Fetch(context.Background())
+	require.NoError(t, err)
+	assert.Len(t, rev.ID, 64)
+	assert.Equal(t, map[string]string{"config/flags.yaml": "zip"}, tree(t, src.WorkDir()))
+
+	location, _ := src.Location()
+	assert.Equal(t, srv.URL+"/bundle.zip", location)
+}
+
+func TestHTTP_UnknownFormat(t *testing.T) {
+	body := []byte("plain")
+	etag := ""
+	srv, _ := archiveServer(t, &body, &etag)
+
+	src := newSource(t, &config.Config{SourceType: "http", SourceURL: srv.URL + "/flags.yaml"})
+	_, err := src.Fetch(context.Background())
+	assert.ErrorContains(t, err, "cannot tell archive format")
+}
+
+func TestHTTP_HostileArchives(t *testing.T) {
+	outside := t.TempDir()
+
+	tests := map[string][]byte{
+		"zip-slip tar":       tarGz(t, entry{name: "../../escape.yaml", body: "x"}),
+		"absolute tar":       tarGz(t, entry{name: filepath.Join(outside, "abs.yaml"), body: "x"}),
+		"zip-slip zip":       zipArchive(t, entry{name: "../escape.yaml", body: "x"}),
+		"write through link": tarGz(t, entry{name: "dir", link: outside}, entry{name: "dir/planted.yaml", body: "x"}),
+		"link chain":         tarGz(t, entry{name: "dir", link: outside}, entry{name: "dir/planted", link: "/etc/passwd"}),
+	}
+
+	for name, body := range tests {
+		t.Run(name, func(t *testing.T) {
+			etag := ""
+			format := "/a.tar.gz"
+			if name == "zip-slip zip" {
+				format = "/a.zip"
+			}
+			srv, _ := archiveServer(t, &body, &etag)
+
+			src := newSource(t, &config.Config{SourceType: "http", SourceURL: srv.URL + format})
+			_, err := src.Fetch(context.Background())
+			assert.Error(t, err)
+
+			entries, err := os.ReadDir(outside)
+			require.NoError(t, err)
+			assert.Empty(t, entries, "nothing may be written outside the work directory")
+		})
+	}
+}
+
+func TestHTTP_LinksAreLeftToThePublishPolicy(t *testing.T) {
+	body := tarGz(t, entry{name: "flags.yaml", body: "flags"}, entry{name: "alias.yaml", link: "flags.yaml"})
+	etag := ""
+	srv, _ := archiveServer(t, &body, &etag)
+
+	src := newSource(t, &config.Config{SourceType: "http", SourceURL: srv.URL + "/a.tgz"})
+	_, err := src.Fetch(context.Background())
+	require.NoError(t, err)
+
+	target, err := os.Readlink(filepath.Join(src.WorkDir(), "alias.yaml"))
+	require.NoError(t, err)
+	assert.Equal(t, "flags.yaml", target)
+}
+
+func TestHTTP_FetchSizeLimit(t *testing.T) {
+	// Compresses to a few hundred bytes, expands to 1 MiB
+	body := tarGz(t, entry{name: "bomb", body: string(make([]byte, 1<<20))})
+	etag := ""
+	srv, _ := archiveServer(t, &body, &etag)
+
+	src := newSource(t, &config.Config{SourceType: "http", SourceURL: srv.URL + "/a.tar.gz", MaxFetchSize: 64 << 10})
+	_, err := src.Fetch(context.Background())
+	assert.ErrorIs(t, err, publish.ErrLimitExceeded)
+}
+
+func TestHTTP_FailedFetchKeepsPreviousContent(t *testing.T) {
+	body := tarGz(t, entry{name: "flags.yaml", body: "good"})
+	etag := ""
+	srv, _ := archiveServer(t, &body, &etag)
+
+	src := newSource(t, &config.Config{SourceType: "http", SourceURL: srv.URL + "/a.tar.gz"})
+	_, err := src.Fetch(context.Background())
+	require.NoError(t, err)
+
+	body = tarGz(t, entry{name: "flags.yaml", body: "bad"}, entry{name: "../escape", body: "x"})
+	_, err = src.Fetch(context.Background())
+	require.Error(t, err)
+	assert.Equal(t, map[string]string{"flags.yaml": "good"}, tree(t, src.WorkDir()))
+}
@@ -0,0 +1,153 @@
+package source
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"time"
+
+	"github.com/davidaparicio/microsvcs/projects/git-sync/internal/config"
+	"github.com/davidaparicio/microsvcs/projects/git-sync/internal/publish"
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+)
+
+// OCI annotations read from artifact manifests and layers.
+const (
+	annotationTitle   = "org.opencontainers.image.title"
+	annotationCreated = "org.opencontainers.image.created"
+	annotationAuthors = "org.opencontainers.image.authors"
+)
+
+// ociSource pulls an OCI artifact from a registry. Layers annotated with a
+// title (as pushed by `oras push`) become a file of that name; other layers
+// are unpacked as tar archives, so plain container images work too.
+type ociSource struct {
+	ref     name.Reference
+	opts    []remote.Option
+	ws      *workspace
+	maxSize int64
+	rev     Revision
+}
+
+func newOCI(cfg *config.Config) (*ociSource, error) {
+	var nameOpts []name.Option
+	if cfg.OCIInsecure {
+		nameOpts = append(nameOpts, name.Insecure)
+	}
+	ref, err := name.ParseReference(cfg.SourceURL, nameOpts...)
+	if err != nil {
+		return nil, fmt.Errorf("invalid SOURCE_URL: %w", err)
+	}
+
+	ws, err := newWorkspace()
+	if err != nil {
+		return nil, err
+	}
+	return &ociSource{
+		ref:     ref,
+		opts:    []remote.Option{remote.WithAuthFromKeychain(authn.DefaultKeychain)},
+		ws:      ws,
+		maxSize: cfg.MaxFetchSize,
+	}, nil
+}
+
+func (o *ociSource) Fetch(ctx context.Context) (Revision, error) {
+	opts := append([]remote.Option{remote.WithContext(ctx)}, o.opts...)
+
+	// A HEAD request is enough to tell whether the tag moved
+	desc, err := remote.Head(o.ref, opts...)
+	if err != nil {
+		return Revision{}, fmt.Errorf("failed to resolve %s: %w", o.ref, err)
+	}
+	if desc.Digest.Hex == o.rev.ID {
+		return o.rev, nil
+	}
+
+	// Pull by digest so the content matches the revision we report
+	img, err := remote.Image(o.ref.Context().Digest(desc.Digest.String()), opts...)
+	if err != nil {
+		return Revision{}, fmt.Errorf("failed to pull %s: %w", o.ref, err)
+	}
+	manifest, err := img.Manifest()
+	if err != nil {
+		return Revision{}, fmt.Errorf("failed to read manifest: %w", err)
+	}
+
+	var total int64
+	for _, l := range manifest.Layers {
+		total += l.Size
+	}
+	b := &budget{max: o.maxSize}
+	if b.max > 0 && total > b.max {
+		return Revision{}, &publish.LimitError{Limit: "fetch-size", Max: b.max, Actual: total}
+	}
+
+	stage, err := o.ws.stage()
+	if err != nil {
+		return Revision{}, err
+	}
+	e := newExtractor(stage, b)
+	for _, l := range manifest.Layers {
+		layer, err := img.LayerByDigest(l.Digest)
+		if err != nil {
+			return Revision{}, err
+		}
+
+		if title := l.Annotations[annotationTitle]; title != "" {
+			rc, err := layer.Compressed()
+			if err != nil {
+				return Revision{}, err
+			}
+			err = e.file(path.Clean(title), 0644, rc)
+			_ = rc.Close()
+			if err != nil {
+				return Revision{}, err
+			}
+			continue
+		}
+
+		if !l.MediaType.IsLayer() {
+			return Revision{}, fmt.Errorf("layer %s: unsupported media type %s without a %s annotation", l.Digest, l.MediaType, annotationTitle)
+		}
+		rc, err := layer.Uncompressed()
+		if err != nil {
+			return Revision{}, err
+		}
+		err = e.tar(rc)
+		_ = rc.Close()
+		if err != nil {
+			return Revision{}, err
+		}
+	}
+	if err := e.finish(); err != nil {
+		return Revision{}, err
+	}
+	if err := o.ws.commit(); err != nil {
+		return Revision{}, err
+	}
+
+	rev := Revision{ID: desc.Digest.Hex, Author: manifest.Annotations[annotationAuthors]}
+	if t, err := time.Parse(time.RFC3339, manifest.Annotations[annotationCreated]); err == nil {
+		rev.Time = t.UTC()
+	}
+	o.rev = rev
+	return rev, nil
+}
+
+func (o *ociSource) WorkDir() string {
+	return o.ws.dir()
+}
+
+func (o *ociSource) Location() (string, string) {
+	return o.ref.Context().Name(), o.ref.Identifier()
+}
+
+func (o *ociSource) DiskUsage() (int64, error) {
+	return diskUsage(o.ws.dir())
+}
+
+func (o *ociSource) Close() error {
+	return o.ws.close()
+}
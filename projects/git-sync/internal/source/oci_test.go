@@ -0,0 +1,108 @@
+package source_test
+
+import (
+	"context"
+	"io"
+	"log"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/davidaparicio/microsvcs/projects/git-sync/internal/config"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/static"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// pushArtifact pushes an ORAS-style artifact: one titled layer per file,
+// plus an optional tar.gz layer.
+func pushArtifact(t *testing.T, ref string, files map[string]string, tarLayer []byte) {
+	t.Helper()
+
+	img := mutate.MediaType(empty.Image, types.OCIManifestSchema1)
+	img = mutate.ConfigMediaType(img, "application/vnd.oci.empty.v1+json")
+	for title, body := range files {
+		var err error
+		img, err = mutate.Append(img, mutate.Addendum{
+			Layer:       static.NewLayer([]byte(body), "application/vnd.acme.flags.v1+yaml"),
+			Annotations: map[string]string{"org.opencontainers.image.title": title},
+		})
+		require.NoError(t, err)
+	}
+	if tarLayer != nil {
+		var err error
+		img, err = mutate.Append(img, mutate.Addendum{Layer: static.NewLayer(tarLayer, types.OCILayer)})
+		require.NoError(t, err)
+	}
+	img = mutate.Annotations(img, map[string]string{
+		"org.opencontainers.image.created": "2026-01-02T03:04:05Z",
+		"org.opencontainers.image.authors": "Platform Team",
+	}).(v1.Image)
+
+	r, err := name.ParseReference(ref)
+	require.NoError(t, err)
+	require.NoError(t, remote.Write(r, img))
+}
+
+func newRegistry(t *testing.T) string {
+	t.Helper()
+	srv := httptest.NewServer(registry.New(registry.Logger(log.New(io.Discard, "", 0))))
+	t.Cleanup(srv.Close)
+	return strings.TrimPrefix(srv.URL, "http://")
+}
+
+func TestOCI_PullsArtifact(t *testing.T) {
+	host := newRegistry(t)
+	ref := host + "/config/flags:production"
+	pushArtifact(t, ref, map[string]string{"flags.yaml": "v1"},
+		tarGz(t, entry{name: "nested/app.yaml", body: "app"}))
+
+	src := newSource(t, &config.Config{SourceType: "oci", SourceURL: ref, OCIInsecure: true})
+
+	rev1, err := src.Fetch(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"flags.yaml": "v1", "nested/app.yaml": "app"}, tree(t, src.WorkDir()))
+	assert.Equal(t, "Platform Team", rev1.Author)
+	assert.Equal(t, time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC), rev1.Time)
+
+	location, tag := src.Location()
+	assert.Equal(t, host+"/config/flags", location)
+	assert.Equal(t, "production", tag)
+
+	rev2, err := src.Fetch(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, rev1, rev2)
+
+	// Retagging moves the revision and replaces the content
+	pushArtifact(t, ref, map[string]string{"flags.yaml": "v2"}, nil)
+	rev3, err := src.Fetch(context.Background())
+	require.NoError(t, err)
+	assert.NotEqual(t, rev1.ID, rev3.ID)
+	assert.Equal(t, map[string]string{"flags.yaml": "v2"}, tree(t, src.WorkDir()))
+}
+
+func TestOCI_RejectsEscapingTitle(t *testing.T) {
+	host := newRegistry(t)
+	ref := host + "/config/flags:evil"
+	pushArtifact(t, ref, map[string]string{"../../escape.yaml": "x"}, nil)
+
+	src := newSource(t, &config.Config{SourceType: "oci", SourceURL: ref})
+	_, err := src.Fetch(context.Background())
+	assert.ErrorContains(t, err, "outside the source tree")
+}
+
+func TestOCI_MissingTag(t *testing.T) {
+	host := newRegistry(t)
+
+	src := newSource(t, &config.Config{SourceType: "oci", SourceURL: host + "/config/flags:missing"})
+	_, err := src.Fetch(context.Background())
+	assert.ErrorContains(t, err, "failed to resolve")
+}
@@ -0,0 +1,147 @@
+package source
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+
+	"github.com/davidaparicio/microsvcs/projects/git-sync/internal/config"
+	"github.com/davidaparicio/microsvcs/projects/git-sync/internal/publish"
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// s3Source mirrors the objects under a bucket prefix. It works with AWS S3
+// and S3-compatible stores such as MinIO.
+type s3Source struct {
+	client  *minio.Client
+	bucket  string
+	prefix  string
+	ws      *workspace
+	maxSize int64
+	rev     Revision
+}
+
+func newS3(cfg *config.Config) (*s3Source, error) {
+	u, err := url.Parse(cfg.SourceURL)
+	if err != nil || u.Scheme != "s3" || u.Host == "" {
+		return nil, fmt.Errorf("SOURCE_URL must look like s3://bucket/prefix, got %q", cfg.SourceURL)
+	}
+	prefix := strings.Trim(u.Path, "/")
+	if prefix != "" {
+		prefix += "/"
+	}
+
+	// Credentials come from AWS_* or MINIO_* variables, then instance or
+	// web identity metadata; without any, requests are anonymous
+	client, err := minio.New(cfg.S3Endpoint, &minio.Options{
+		Creds: credentials.NewChainCredentials([]credentials.Provider{
+			&credentials.EnvAWS{},
+			&credentials.EnvMinio{},
+			&credentials.IAM{},
+		}),
+		Secure:       !cfg.S3Insecure,
+		Region:       cfg.S3Region,
+		BucketLookup: minio.BucketLookupPath,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create S3 client: %w", err)
+	}
+
+	ws, err := newWorkspace()
+	if err != nil {
+		return nil, err
+	}
+	return &s3Source{client: client, bucket: u.Host, prefix: prefix, ws: ws, maxSize: cfg.MaxFetchSize}, nil
+}
+
+func (s *s3Source) Fetch(ctx context.Context) (Revision, error) {
+	var objects []minio.ObjectInfo
+	for obj := range s.client.ListObjects(ctx, s.bucket, minio.ListObjectsOptions{Prefix: s.prefix, Recursive: true}) {
+		if obj.Err != nil {
+			return Revision{}, fmt.Errorf("failed to list s3://%s/%s: %w", s.bucket, s.prefix, obj.Err)
+		}
+		// Zero-byte "folder" markers created by consoles
+		if strings.HasSuffix(obj.Key, "/") {
+			continue
+		}
+		objects = append(objects, obj)
+	}
+	slices.SortFunc(objects, func(a, b minio.ObjectInfo) int { return strings.Compare(a.Key, b.Key) })
+
+	// The revision is derived from the listing, so an unchanged prefix is
+	// detected without downloading anything
+	rev := Revision{ID: listingDigest(objects)}
+	var total int64
+	for _, obj := range objects {
+		total += obj.Size
+		if obj.LastModified.After(rev.Time) {
+			rev.Time = obj.LastModified.UTC()
+		}
+	}
+	if rev.ID == s.rev.ID {
+		return s.rev, nil
+	}
+	if s.maxSize > 0 && total > s.maxSize {
+		return Revision{}, &publish.LimitError{Limit: "fetch-size", Max: s.maxSize, Actual: total}
+	}
+
+	stage, err := s.ws.stage()
+	if err != nil {
+		return Revision{}, err
+	}
+	e := newExtractor(stage, &budget{max: s.maxSize})
+	for _, obj := range objects {
+		if err := s.download(ctx, e, obj); err != nil {
+			return Revision{}, err
+		}
+	}
+	if err := s.ws.commit(); err != nil {
+		return Revision{}, err
+	}
+
+	s.rev = rev
+	return rev, nil
+}
+
+func (s *s3Source) download(ctx context.Context, e *extractor, obj minio.ObjectInfo) error {
+	r, err := s.client.GetObject(ctx, s.bucket, obj.Key, minio.GetObjectOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to get %s: %w", obj.Key, err)
+	}
+	defer func() { _ = r.Close() }()
+
+	if err := e.file(strings.TrimPrefix(obj.Key, s.prefix), 0644, r); err != nil {
+		return fmt.Errorf("failed to download %s: %w", obj.Key, err)
+	}
+	return nil
+}
+
+// listingDigest hashes the key, ETag and size of every object.
+func listingDigest(objects []minio.ObjectInfo) string {
+	h := sha256.New()
+	for _, obj := range objects {
+		_, _ = fmt.Fprintf(h, "%s\x00%s\x00%d\n", obj.Key, obj.ETag, obj.Size)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func (s *s3Source) WorkDir() string {
+	return s.ws.dir()
+}
+
+func (s *s3Source) Location() (string, string) {
+	return "s3://" + s.bucket + "/" + s.prefix, ""
+}
+
+func (s *s3Source) DiskUsage() (int64, error) {
+	return diskUsage(s.ws.dir())
+}
+
+func (s *s3Source) Close() error {
+	return s.ws.close()
+}
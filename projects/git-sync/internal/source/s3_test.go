@@ -0,0 +1,183 @@
+package source_test
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	gosync "sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/davidaparicio/microsvcs/projects/git-sync/internal/config"
+	"github.com/davidaparicio/microsvcs/projects/git-sync/internal/publish"
+	"github.com/davidaparicio/microsvcs/projects/git-sync/internal/source"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeS3 is a minimal stand-in for MinIO: one bucket, path-style
+// ListObjectsV2 and GetObject, which is all the S3 source uses.
+type fakeS3 struct {
+	bucket string
+	mu     gosync.Mutex
+	data   map[string]string
+	gets   atomic.Int32
+}
+
+func (f *fakeS3) put(key, body string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.data[key] = body
+}
+
+func etagOf(body string) string {
+	sum := md5.Sum([]byte(body))
+	return `"` + hex.EncodeToString(sum[:]) + `"`
+}
+
+type listResult struct {
+	XMLName     xml.Name `xml:"ListBucketResult"`
+	Name        string
+	Prefix      string
+	KeyCount    int
+	MaxKeys     int
+	IsTruncated bool
+	Contents    []listObject
+}
+
+type listObject struct {
+	Key          string
+	LastModified string
+	ETag         string
+	Size         int
+	StorageClass string
+}
+
+var modTime = time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	if bucket != f.bucket {
+		http.Error(w, "NoSuchBucket", http.StatusNotFound)
+		return
+	}
+
+	if key == "" {
+		prefix := r.URL.Query().Get("prefix")
+		res := listResult{Name: bucket, Prefix: prefix, MaxKeys: 1000}
+		keys := slices.Sorted(func(yield func(string) bool) {
+			for k := range f.data {
+				if strings.HasPrefix(k, prefix) && !yield(k) {
+					return
+				}
+			}
+		})
+		for _, k := range keys {
+			res.Contents = append(res.Contents, listObject{
+				Key: k, LastModified: modTime.Format(time.RFC3339), ETag: etagOf(f.data[k]),
+				Size: len(f.data[k]), StorageClass: "STANDARD",
+			})
+		}
+		res.KeyCount = len(res.Contents)
+		w.Header().Set("Content-Type", "application/xml")
+		_ = xml.NewEncoder(w).Encode(res)
+		return
+	}
+
+	key, _ = url.PathUnescape(key)
+	body, ok := f.data[key]
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		_, _ = w.Write([]byte(`<Error><Code>NoSuchKey</Code></Error>`))
+		return
+	}
+	f.gets.Add(1)
+	w.Header().Set("ETag", etagOf(body))
+	w.Header().Set("Last-Modified", modTime.Format(http.TimeFormat))
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	_, _ = w.Write([]byte(body))
+}
+
+func newFakeS3(t *testing.T, objects map[string]string) (*fakeS3, *config.Config) {
+	t.Helper()
+	t.Setenv("AWS_ACCESS_KEY_ID", "minio")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "minio123")
+
+	f := &fakeS3{bucket: "flags", data: objects}
+	srv := httptest.NewServer(f)
+	t.Cleanup(srv.Close)
+
+	return f, &config.Config{
+		SourceType: "s3",
+		SourceURL:  "s3://flags/production",
+		S3Endpoint: strings.TrimPrefix(srv.URL, "http://"),
+		S3Region:   "us-east-1",
+		S3Insecure: true,
+	}
+}
+
+func TestS3_MirrorsPrefix(t *testing.T) {
+	f, cfg := newFakeS3(t, map[string]string{
+		"production/flags.yaml":      "prod",
+		"production/nested/app.yaml": "app",
+		"production/":                "",
+		"staging/flags.yaml":         "staging",
+	})
+	src := newSource(t, cfg)
+
+	rev1, err := src.Fetch(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"flags.yaml": "prod", "nested/app.yaml": "app"}, tree(t, src.WorkDir()))
+	assert.Equal(t, modTime, rev1.Time)
+	assert.Equal(t, int32(2), f.gets.Load())
+
+	// An unchanged listing downloads nothing
+	rev2, err := src.Fetch(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, rev1, rev2)
+	assert.Equal(t, int32(2), f.gets.Load())
+
+	f.put("production/flags.yaml", "prod v2")
+	rev3, err := src.Fetch(context.Background())
+	require.NoError(t, err)
+	assert.NotEqual(t, rev1.ID, rev3.ID)
+	assert.Equal(t, "prod v2", tree(t, src.WorkDir())["flags.yaml"])
+
+	location, _ := src.Location()
+	assert.Equal(t, "s3://flags/production/", location)
+}
+
+func TestS3_FetchSizeLimit(t *testing.T) {
+	f, cfg := newFakeS3(t, map[string]string{"production/big.bin": strings.Repeat("x", 4096)})
+	cfg.MaxFetchSize = 1024
+	src := newSource(t, cfg)
+
+	_, err := src.Fetch(context.Background())
+	assert.ErrorIs(t, err, publish.ErrLimitExceeded)
+	assert.Zero(t, f.gets.Load(), "the listing alone must trip the limit")
+}
+
+func TestS3_MissingBucket(t *testing.T) {
+	_, cfg := newFakeS3(t, nil)
+	cfg.SourceURL = "s3://missing/"
+	src := newSource(t, cfg)
+
+	_, err := src.Fetch(context.Background())
+	assert.Error(t, err)
+}
+
+func TestS3_InvalidURL(t *testing.T) {
+	_, err := source.New(&config.Config{SourceType: "s3", SourceURL: "https://bucket/prefix", S3Endpoint: "localhost:9000"})
+	assert.ErrorContains(t, err, "s3://bucket/prefix")
+}
@@ -0,0 +1,138 @@
+package source
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/davidaparicio/microsvcs/projects/git-sync/internal/config"
+)
+
+// Backend names accepted by SOURCE_TYPE.
+const (
+	TypeGit  = "git"
+	TypeHTTP = "http"
+	TypeS3   = "s3"
+	TypeOCI  = "oci"
+)
+
+// Revision identifies the content a Source fetched.
+type Revision struct {
+	ID     string    // commit SHA, archive or object-listing digest, manifest digest
+	Author string    // empty when the backend has no notion of an author
+	Time   time.Time // commit or last-modified time, zero if unknown
+}
+
+// Source fetches content into a local directory that the publish pipeline
+// reads from. Implementations are not safe for concurrent use; the Syncer
+// serializes calls.
+type Source interface {
+	// Fetch brings WorkDir up to date and returns the revision it now holds.
+	Fetch(ctx context.Context) (Revision, error)
+	// WorkDir is the root of the fetched content.
+	WorkDir() string
+	// Location returns the URL and ref recorded in the manifest, without credentials.
+	Location() (url, ref string)
+	// DiskUsage returns the bytes the fetched content occupies locally.
+	DiskUsage() (int64, error)
+	// Close releases local storage.
+	Close() error
+}
+
+// New returns the backend selected by cfg.SourceType.
+func New(cfg *config.Config) (Source, error) {
+	switch cfg.SourceType {
+	case "", TypeGit:
+		return newGit(cfg)
+	case TypeHTTP:
+		return newHTTP(cfg)
+	case TypeS3:
+		return newS3(cfg)
+	case TypeOCI:
+		return newOCI(cfg)
+	default:
+		return nil, fmt.Errorf("unknown source type %q", cfg.SourceType)
+	}
+}
+
+// workspace is the local storage shared by the non-git backends. Content is
+// downloaded into a staging directory and swapped in once complete, so a
+// failed download never leaves a half-updated tree behind.
+type workspace struct {
+	root string
+}
+
+func newWorkspace() (*workspace, error) {
+	// Ensure temp directory exists (required for scratch-based containers)
+	if err := os.MkdirAll(os.TempDir(), 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create temp directory: %w", err)
+	}
+	root, err := os.MkdirTemp("", "git-sync-work-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create work directory: %w", err)
+	}
+	if err := os.Mkdir(filepath.Join(root, "current"), 0o755); err != nil {
+		return nil, err
+	}
+	return &workspace{root: root}, nil
+}
+
+// dir is where the last complete download lives.
+func (w *workspace) dir() string {
+	return filepath.Join(w.root, "current")
+}
+
+// stage returns an empty directory to download into.
+func (w *workspace) stage() (string, error) {
+	next := filepath.Join(w.root, "next")
+	if err := os.RemoveAll(next); err != nil {
+		return "", err
+	}
+	return next, os.Mkdir(next, 0o755)
+}
+
+// commit replaces the current content with the staged download.
+func (w *workspace) commit() error {
+	if err := os.RemoveAll(w.dir()); err != nil {
+		return err
+	}
+	return os.Rename(filepath.Join(w.root, "next"), w.dir())
+}
+
+func (w *workspace) close() error {
+	return os.RemoveAll(w.root)
+}
+
+// diskUsage sums the size of the regular files under dir.
+func diskUsage(dir string) (int64, error) {
+	var total int64
+	err := filepath.WalkDir(dir, func(_ string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.Type().IsRegular() {
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			total += info.Size()
+		}
+		return nil
+	})
+	return total, err
+}
+
+// RedactURL drops credentials embedded in a URL so they never reach the
+// manifest or status output.
+func RedactURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil || u.User == nil {
+		return raw
+	}
+	u.User = nil
+	return u.String()
+}
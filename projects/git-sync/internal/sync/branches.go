@@ -0,0 +1,323 @@
+package sync
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/davidaparicio/microsvcs/projects/git-sync/internal/config"
+	"github.com/davidaparicio/microsvcs/projects/git-sync/internal/events"
+	"github.com/davidaparicio/microsvcs/projects/git-sync/internal/git"
+	"github.com/davidaparicio/microsvcs/projects/git-sync/internal/publish"
+	"github.com/davidaparicio/microsvcs/projects/git-sync/internal/source"
+)
+
+// BranchIndexName is the index written at the root of TARGET_PATH in
+// multi-branch mode. Branch directories never start with a dot, so it
+// cannot collide with one.
+const BranchIndexName = ".git-sync-branches.json"
+
+// BranchIndex lists the branches published under TARGET_PATH.
+type BranchIndex struct {
+	Pattern   string        `json:"pattern"`
+	UpdatedAt time.Time     `json:"updatedAt"`
+	Branches  []BranchEntry `json:"branches"`
+}
+
+// BranchEntry is one branch of the index.
+type BranchEntry struct {
+	Branch     string    `json:"branch"`
+	Dir        string    `json:"dir"`              // relative to TARGET_PATH
+	Commit     string    `json:"commit,omitempty"` // empty until the branch first publishes
+	CommitTime time.Time `json:"commitTime,omitzero"`
+	SyncedAt   time.Time `json:"syncedAt,omitzero"`
+	Error      string    `json:"error,omitempty"` // last sync error, the previous content stays published
+}
+
+// BranchDir maps a branch name to its directory under TARGET_PATH. Anything
+// but letters, digits, dots, dashes and underscores becomes a dash, so
+// flags/new-color is published in flags-new-color.
+func BranchDir(branch string) string {
+	dir := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_', r == '.':
+			return r
+		}
+		return '-'
+	}, branch)
+	if dir = strings.TrimLeft(dir, "."); dir == "" {
+		dir = "-"
+	}
+	return dir
+}
+
+// branch is a remote branch followed by its own Syncer.
+type branch struct {
+	name   string
+	dir    string
+	syncer *Syncer
+	head   string // remote head at the last successful sync
+	err    error  // last sync error
+}
+
+// BranchSyncer publishes every remote branch matching GIT_BRANCH_PATTERN
+// into its own directory, for side-by-side previews. Each branch goes
+// through the regular Syncer pipeline; branches that disappear from the
+// remote have their directory removed.
+type BranchSyncer struct {
+	cfg    *config.Config
+	events *events.Bus
+	syncMu sync.Mutex // serializes Sync runs
+
+	// Guarded by syncMu
+	branches map[string]*branch
+	orphans  []string // directories of a previous run, removed unless their branch still exists
+
+	mu         sync.RWMutex // guards the status fields below only
+	lastSync   time.Time
+	syncCount  int64
+	errorCount int64
+	healthy    bool
+	lastError  *errorStatus
+	index      *BranchIndex
+}
+
+// NewBranchSyncer returns a multi-branch syncer for cfg.BranchPattern. The
+// index left by a previous run tells which directories it owns.
+func NewBranchSyncer(cfg *config.Config) (*BranchSyncer, error) {
+	prev, err := ReadBranchIndex(cfg.TargetPath)
+	if err != nil {
+		return nil, err
+	}
+	var orphans []string
+	if prev != nil {
+		for _, e := range prev.Branches {
+			// Only trust directories the index could have produced
+			if e.Dir == BranchDir(e.Branch) {
+				orphans = append(orphans, e.Dir)
+			}
+		}
+	}
+
+	return &BranchSyncer{
+		cfg:      cfg,
+		events:   events.NewBus(cfg.EventBufferSize),
+		branches: map[string]*branch{},
+		orphans:  orphans,
+	}, nil
+}
+
+// Sync lists the matching branches, syncs the ones whose head moved and
+// removes the directories of deleted branches. A failing branch keeps its
+// last published content and does not fail the run; only a failed listing
+// or index write does.
+func (b *BranchSyncer) Sync(ctx context.Context) error {
+	b.syncMu.Lock()
+	defer b.syncMu.Unlock()
+
+	fmt.Printf("[%s] Listing branches matching %s from %s\n",
+		time.Now().Format(time.RFC3339), b.cfg.BranchPattern, source.RedactURL(b.cfg.RepoURL))
+
+	remote, err := git.ListBranches(ctx, b.cfg)
+	if err != nil {
+		b.recordFailure(err)
+		return err
+	}
+
+	owners := map[string]string{} // dir -> branch
+	for _, rb := range remote {
+		dir := BranchDir(rb.Name)
+		if other, ok := owners[dir]; ok {
+			fmt.Fprintf(os.Stderr, "[%s] Skipping branch %s: directory %s is already used by %s\n",
+				time.Now().Format(time.RFC3339), rb.Name, dir, other)
+			continue
+		}
+		owners[dir] = rb.Name
+
+		br, ok := b.branches[rb.Name]
+		if !ok {
+			if br, err = b.add(rb.Name, dir); err != nil {
+				fmt.Fprintf(os.Stderr, "[%s] Failed to follow branch %s: %v\n", time.Now().Format(time.RFC3339), rb.Name, err)
+				continue
+			}
+		}
+		if br.head == rb.Hash && br.err == nil {
+			continue
+		}
+		if err := br.syncer.Sync(ctx); err != nil {
+			br.err = err
+			fmt.Fprintf(os.Stderr, "[%s] Sync of branch %s failed: %v\n", time.Now().Format(time.RFC3339), rb.Name, err)
+			continue
+		}
+		br.head, br.err = rb.Hash, nil
+	}
+
+	stale := b.orphans
+	for name, br := range b.branches {
+		if owners[br.dir] != name {
+			delete(b.branches, name)
+			_ = br.syncer.Close()
+			fmt.Printf("[%s] Branch %s was deleted, removing %s\n", time.Now().Format(time.RFC3339), name, br.dir)
+			stale = append(stale, br.dir)
+		}
+	}
+	b.orphans = nil
+	for _, dir := range stale {
+		if _, ok := owners[dir]; ok {
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(b.cfg.TargetPath, dir)); err != nil {
+			// Keep it for the next run to retry
+			b.orphans = append(b.orphans, dir)
+			fmt.Fprintf(os.Stderr, "[%s] Failed to remove %s: %v\n", time.Now().Format(time.RFC3339), dir, err)
+		}
+	}
+
+	index := b.buildIndex()
+	if err := writeBranchIndex(b.cfg.TargetPath, index); err != nil {
+		err = fmt.Errorf("failed to write %s: %w", BranchIndexName, err)
+		b.recordFailure(err)
+		return err
+	}
+	b.recordSuccess(index)
+
+	fmt.Printf("[%s] Branch sync completed (%d branches)\n", time.Now().Format(time.RFC3339), len(index.Branches))
+	return nil
+}
+
+// add starts following a branch. Its Syncer shares the event bus, so
+// /events carries every branch with its name as ref.
+func (b *BranchSyncer) add(name, dir string) (*branch, error) {
+	cfg := *b.cfg
+	cfg.Branch = name
+	cfg.BranchPattern = ""
+	cfg.TargetPath = filepath.Join(b.cfg.TargetPath, dir)
+
+	s, err := newSyncer(&cfg, b.events)
+	if err != nil {
+		return nil, err
+	}
+	br := &branch{name: name, dir: dir, syncer: s}
+	b.branches[name] = br
+	fmt.Printf("[%s] Following branch %s in %s\n", time.Now().Format(time.RFC3339), name, cfg.TargetPath)
+	return br, nil
+}
+
+// buildIndex describes the followed branches, sorted by name.
+func (b *BranchSyncer) buildIndex() *BranchIndex {
+	index := &BranchIndex{Pattern: b.cfg.BranchPattern, UpdatedAt: time.Now(), Branches: []BranchEntry{}}
+	for _, name := range slices.Sorted(maps.Keys(b.branches)) {
+		br := b.branches[name]
+		e := BranchEntry{Branch: name, Dir: br.dir}
+		// Branch syncers only run under our syncMu, so their trees are stable
+		if m := br.syncer.manifest; m != nil {
+			e.Commit, e.CommitTime = m.Commit, m.CommitTime
+		}
+		br.syncer.mu.RLock()
+		e.SyncedAt = br.syncer.lastSync
+		br.syncer.mu.RUnlock()
+		if br.err != nil {
+			e.Error = br.err.Error()
+		}
+		index.Branches = append(index.Branches, e)
+	}
+	return index
+}
+
+// ReadBranchIndex returns the branch index in target, or nil if there is none.
+func ReadBranchIndex(target string) (*BranchIndex, error) {
+	data, err := os.ReadFile(filepath.Join(target, BranchIndexName))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var index BranchIndex
+	if err := json.Unmarshal(data, &index); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", BranchIndexName, err)
+	}
+	return &index, nil
+}
+
+func writeBranchIndex(target string, index *BranchIndex) error {
+	if err := os.MkdirAll(target, 0755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(index, "", "  ")
+	if err != nil {
+		return err
+	}
+	return publish.WriteAtomic(filepath.Join(target, BranchIndexName), append(data, '\n'), 0644)
+}
+
+func (b *BranchSyncer) recordFailure(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.errorCount++
+	b.lastError = &errorStatus{Class: ErrorClassFetch, Message: err.Error(), Time: time.Now()}
+	b.healthy = false
+	b.events.Publish(events.SyncFailed, events.Failed{Class: ErrorClassFetch, Error: err.Error()})
+}
+
+func (b *BranchSyncer) recordSuccess(index *BranchIndex) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.lastSync = time.Now()
+	b.syncCount++
+	b.healthy = true
+	b.index = index
+}
+
+// IsHealthy reports whether the last listing succeeded. A failing branch
+// shows in status and the index but leaves the instance ready, so one bad
+// preview does not take the others down.
+func (b *BranchSyncer) IsHealthy() bool {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.healthy
+}
+
+func (b *BranchSyncer) GetStatus() map[string]any {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	return map[string]any{
+		"healthy":       b.healthy,
+		"lastSync":      b.lastSync,
+		"syncCount":     b.syncCount,
+		"errorCount":    b.errorCount,
+		"lastError":     b.lastError,
+		"sourceType":    "git",
+		"repoURL":       source.RedactURL(b.cfg.RepoURL),
+		"branchPattern": b.cfg.BranchPattern,
+		"insecureTLS":   b.cfg.GitInsecureSkipTLSVerify,
+		"targetPath":    b.cfg.TargetPath,
+		"branches":      b.index,
+	}
+}
+
+// Events returns the bus shared by every branch.
+func (b *BranchSyncer) Events() *events.Bus {
+	return b.events
+}
+
+// Close releases the work directories of every branch.
+func (b *BranchSyncer) Close() error {
+	b.syncMu.Lock()
+	defer b.syncMu.Unlock()
+	var errs []error
+	for _, br := range b.branches {
+		errs = append(errs, br.syncer.Close())
+	}
+	return errors.Join(errs...)
+}
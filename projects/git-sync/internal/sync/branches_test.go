@@ -0,0 +1,151 @@
+package sync_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/davidaparicio/microsvcs/projects/git-sync/internal/config"
+	"github.com/davidaparicio/microsvcs/projects/git-sync/internal/events"
+	"github.com/davidaparicio/microsvcs/projects/git-sync/internal/sync"
+	gogit "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// commitOnBranch commits files on branch, creating it from main if needed,
+// and checks main out again.
+func commitOnBranch(t *testing.T, repo *gogit.Repository, branch string, files map[string]string) string {
+	t.Helper()
+	w, err := repo.Worktree()
+	require.NoError(t, err)
+
+	ref := plumbing.NewBranchReferenceName(branch)
+	_, err = repo.Reference(ref, false)
+	require.NoError(t, w.Checkout(&gogit.CheckoutOptions{Branch: ref, Create: err != nil}))
+	hash := commitFiles(t, repo, files)
+	require.NoError(t, w.Checkout(&gogit.CheckoutOptions{Branch: plumbing.NewBranchReferenceName("main")}))
+	return hash
+}
+
+func TestBranchDir(t *testing.T) {
+	assert.Equal(t, "flags-new-color", sync.BranchDir("flags/new-color"))
+	assert.Equal(t, "flags-team-a_b.v2", sync.BranchDir("flags/team/a_b.v2"))
+	assert.Equal(t, "-x", sync.BranchDir("../x"))
+	assert.Equal(t, "flags-caf-", sync.BranchDir("flags/café"))
+}
+
+func TestBranchSyncer_PublishesEachMatchingBranch(t *testing.T) {
+	repoDir, repo := newLocalRepo(t, map[string]string{"flags.yaml": "main"})
+	blue := commitOnBranch(t, repo, "flags/blue", map[string]string{"flags.yaml": "blue"})
+	commitOnBranch(t, repo, "flags/red", map[string]string{"flags.yaml": "red"})
+	commitOnBranch(t, repo, "feature/other", map[string]string{"flags.yaml": "other"})
+	target := t.TempDir()
+
+	cfg := &config.Config{
+		RepoURL:       repoDir,
+		BranchPattern: "flags/*",
+		SourcePath:    "/",
+		TargetPath:    target,
+	}
+	syncer, err := sync.NewBranchSyncer(cfg)
+	require.NoError(t, err)
+	defer func() { _ = syncer.Close() }()
+
+	ctx := context.Background()
+	require.NoError(t, syncer.Sync(ctx))
+	assert.True(t, syncer.IsHealthy())
+	assertContent(t, filepath.Join(target, "flags-blue", "flags.yaml"), "blue")
+	assertContent(t, filepath.Join(target, "flags-red", "flags.yaml"), "red")
+	assert.NoDirExists(t, filepath.Join(target, "feature-other"))
+	assert.NoFileExists(t, filepath.Join(target, "flags.yaml"))
+
+	index, err := sync.ReadBranchIndex(target)
+	require.NoError(t, err)
+	require.NotNil(t, index)
+	assert.Equal(t, "flags/*", index.Pattern)
+	require.Len(t, index.Branches, 2)
+	assert.Equal(t, "flags/blue", index.Branches[0].Branch)
+	assert.Equal(t, "flags-blue", index.Branches[0].Dir)
+	assert.Equal(t, blue, index.Branches[0].Commit)
+	assert.Equal(t, "flags/red", index.Branches[1].Branch)
+
+	// A new commit on one branch, and a deleted branch
+	blue = commitOnBranch(t, repo, "flags/blue", map[string]string{"flags.yaml": "blue v2"})
+	require.NoError(t, repo.Storer.RemoveReference(plumbing.NewBranchReferenceName("flags/red")))
+	backlog, _, cancel := syncer.Events().Subscribe(0)
+	cancel()
+	last := backlog[len(backlog)-1].ID
+
+	require.NoError(t, syncer.Sync(ctx))
+	assertContent(t, filepath.Join(target, "flags-blue", "flags.yaml"), "blue v2")
+	assert.NoDirExists(t, filepath.Join(target, "flags-red"))
+
+	index, err = sync.ReadBranchIndex(target)
+	require.NoError(t, err)
+	require.Len(t, index.Branches, 1)
+	assert.Equal(t, blue, index.Branches[0].Commit)
+
+	// Only the branch that moved was synced, and its events name it
+	backlog, _, cancel = syncer.Events().Subscribe(last)
+	cancel()
+	require.Len(t, backlog, 2)
+	assert.Equal(t, events.SyncStarted, backlog[0].Type)
+	assert.Equal(t, events.SyncSucceeded, backlog[1].Type)
+	assert.Equal(t, "flags/blue", backlog[1].Data.(events.Succeeded).Ref)
+}
+
+func TestBranchSyncer_RemovesBranchesDeletedWhileDown(t *testing.T) {
+	repoDir, repo := newLocalRepo(t, map[string]string{"flags.yaml": "main"})
+	commitOnBranch(t, repo, "flags/blue", map[string]string{"flags.yaml": "blue"})
+	commitOnBranch(t, repo, "flags/red", map[string]string{"flags.yaml": "red"})
+	target := t.TempDir()
+	require.NoError(t, os.Mkdir(filepath.Join(target, "unrelated"), 0755))
+
+	cfg := &config.Config{RepoURL: repoDir, BranchPattern: "flags/*", SourcePath: "/", TargetPath: target}
+	first, err := sync.NewBranchSyncer(cfg)
+	require.NoError(t, err)
+	require.NoError(t, first.Sync(context.Background()))
+	require.NoError(t, first.Close())
+
+	require.NoError(t, repo.Storer.RemoveReference(plumbing.NewBranchReferenceName("flags/red")))
+
+	second, err := sync.NewBranchSyncer(cfg)
+	require.NoError(t, err)
+	defer func() { _ = second.Close() }()
+	require.NoError(t, second.Sync(context.Background()))
+
+	assert.DirExists(t, filepath.Join(target, "flags-blue"))
+	assert.NoDirExists(t, filepath.Join(target, "flags-red"))
+	assert.DirExists(t, filepath.Join(target, "unrelated"), "directories git-sync did not create are left alone")
+}
+
+func TestBranchSyncer_FailingBranchKeepsOthersPublished(t *testing.T) {
+	repoDir, repo := newLocalRepo(t, map[string]string{"flags.yaml": "main"})
+	commitOnBranch(t, repo, "flags/blue", map[string]string{"flags.yaml": "blue"})
+	commitOnBranch(t, repo, "flags/huge", map[string]string{"dump.bin": string(make([]byte, 1024))})
+	target := t.TempDir()
+
+	syncer, err := sync.NewBranchSyncer(&config.Config{
+		RepoURL:       repoDir,
+		BranchPattern: "flags/*",
+		SourcePath:    "/",
+		TargetPath:    target,
+		MaxFileSize:   64,
+	})
+	require.NoError(t, err)
+	defer func() { _ = syncer.Close() }()
+
+	require.NoError(t, syncer.Sync(context.Background()))
+	assert.True(t, syncer.IsHealthy())
+	assertContent(t, filepath.Join(target, "flags-blue", "flags.yaml"), "blue")
+
+	index, err := sync.ReadBranchIndex(target)
+	require.NoError(t, err)
+	require.Len(t, index.Branches, 2)
+	assert.Equal(t, "flags/huge", index.Branches[1].Branch)
+	assert.Empty(t, index.Branches[1].Commit)
+	assert.Contains(t, index.Branches[1].Error, "limit")
+}
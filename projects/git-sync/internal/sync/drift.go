@@ -0,0 +1,80 @@
+package sync
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/davidaparicio/microsvcs/projects/git-sync/internal/publish"
+)
+
+// Drift policies for local changes to TARGET_PATH.
+const (
+	DriftReport  = "report"  // record drift in status only
+	DriftRestore = "restore" // rewrite modified or deleted files immediately
+)
+
+// driftStatus is the outcome of the drift checks, exposed in status.
+type driftStatus struct {
+	Policy        string    `json:"policy"`
+	LastCheck     time.Time `json:"lastCheck"`
+	Detected      bool      `json:"detected"`
+	Modified      []string  `json:"modified"`
+	Missing       []string  `json:"missing"`
+	Unexpected    []string  `json:"unexpected"`
+	DriftCount    int64     `json:"driftCount"`    // checks that found drift
+	RestoredFiles int64     `json:"restoredFiles"` // files rewritten under the restore policy
+}
+
+// CheckDrift compares TARGET_PATH with the last published tree. Under the
+// restore policy, modified and deleted files are rewritten before returning.
+func (s *Syncer) CheckDrift() (*publish.Report, error) {
+	// Hold syncMu so a sync cannot publish while the tree is compared
+	s.syncMu.Lock()
+	defer s.syncMu.Unlock()
+
+	if s.manifest == nil {
+		return nil, fmt.Errorf("nothing published yet")
+	}
+
+	keys, err := s.loadKeys()
+	if err != nil {
+		return nil, fmt.Errorf("drift check failed: %w", err)
+	}
+	report, err := publish.Compare(s.cfg.TargetPath, s.manifest, keys)
+	if err != nil {
+		return nil, fmt.Errorf("drift check failed: %w", err)
+	}
+
+	restored := 0
+	if !report.Clean() {
+		fmt.Printf("[%s] Drift detected in %s: modified=%v missing=%v unexpected=%v\n",
+			time.Now().Format(time.RFC3339), s.cfg.TargetPath, report.Modified, report.Missing, report.Unexpected)
+
+		if s.cfg.DriftPolicy == DriftRestore {
+			if err := publish.Repair(s.cfg.TargetPath, s.published, report); err != nil {
+				return nil, fmt.Errorf("drift repair failed: %w", err)
+			}
+			restored = len(report.Modified) + len(report.Missing)
+			fmt.Printf("[%s] Restored %d file(s) from commit %s\n",
+				time.Now().Format(time.RFC3339), restored, shortCommit(s.manifest.Commit))
+		}
+	}
+
+	s.recordDrift(report, restored)
+	return report, nil
+}
+
+func (s *Syncer) recordDrift(report *publish.Report, restored int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.drift.LastCheck = time.Now()
+	s.drift.Detected = !report.Clean()
+	s.drift.Modified = report.Modified
+	s.drift.Missing = report.Missing
+	s.drift.Unexpected = report.Unexpected
+	if s.drift.Detected {
+		s.drift.DriftCount++
+	}
+	s.drift.RestoredFiles += int64(restored)
+}
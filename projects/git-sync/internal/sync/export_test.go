@@ -0,0 +1,8 @@
+package sync
+
+import "github.com/davidaparicio/microsvcs/projects/git-sync/internal/restart"
+
+// SetRestarter swaps the restart action, so tests can use a fake clientset.
+func (s *Syncer) SetRestarter(r *restart.Restarter) {
+	s.restarter = r
+}
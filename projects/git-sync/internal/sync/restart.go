@@ -0,0 +1,48 @@
+package sync
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/davidaparicio/microsvcs/projects/git-sync/internal/config"
+	"github.com/davidaparicio/microsvcs/projects/git-sync/internal/publish"
+	"github.com/davidaparicio/microsvcs/projects/git-sync/internal/restart"
+)
+
+// restartTimeout bounds the Kubernetes API calls made after a publish.
+const restartTimeout = 30 * time.Second
+
+// newRestarter builds the post-publish restart action from the config,
+// using the pod's service account.
+func newRestarter(cfg *config.Config) (*restart.Restarter, error) {
+	namespace := cfg.RestartNamespace
+	if namespace == "" {
+		namespace = restart.CurrentNamespace()
+	}
+	targets, err := restart.ParseTargets(cfg.RestartTargets, namespace)
+	if err != nil {
+		return nil, err
+	}
+	client, err := restart.NewInClusterClient()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Kubernetes client for RESTART_TARGETS: %w", err)
+	}
+	return restart.New(client, targets, cfg.RestartWatchPaths, cfg.RestartMinInterval), nil
+}
+
+// notifyRestarter restarts dependent workloads when the publish from prev
+// to next changed watched files. A failed restart is logged and shows in
+// status; the content is published either way.
+func (s *Syncer) notifyRestarter(ctx context.Context, prev, next *publish.Manifest) {
+	if s.restarter == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(ctx, restartTimeout)
+	defer cancel()
+
+	if err := s.restarter.Notify(ctx, next.Commit, publish.ChangedPaths(prev, next)); err != nil {
+		fmt.Fprintf(os.Stderr, "[%s] Restart failed: %v\n", time.Now().Format(time.RFC3339), err)
+	}
+}
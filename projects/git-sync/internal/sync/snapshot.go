@@ -0,0 +1,243 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/davidaparicio/microsvcs/projects/git-sync/internal/publish"
+)
+
+var (
+	// ErrSnapshotNotFound is returned when no retained snapshot matches a rollback target.
+	ErrSnapshotNotFound = errors.New("snapshot not found")
+	// ErrAmbiguousSnapshot is returned when a commit prefix matches several snapshots.
+	ErrAmbiguousSnapshot = errors.New("commit prefix matches several snapshots")
+)
+
+// snapshot is a published tree kept in memory so it can be republished.
+type snapshot struct {
+	files       []publish.File
+	manifest    *publish.Manifest
+	publishedAt time.Time
+}
+
+// snapshotInfo summarises a snapshot in status.
+type snapshotInfo struct {
+	Commit      string    `json:"commit"`
+	PublishedAt time.Time `json:"publishedAt"`
+}
+
+// pinStatus is set while the target is pinned by a rollback.
+type pinStatus struct {
+	Commit         string    `json:"commit"`
+	Since          time.Time `json:"since"`
+	UpstreamCommit string    `json:"upstreamCommit,omitempty"` // latest commit seen on the branch while pinned
+}
+
+// retain records snap as the current tree, dropping the oldest snapshots
+// beyond SNAPSHOT_RETENTION. A republish of identical content is not
+// stored twice. Callers hold syncMu.
+func (s *Syncer) retain(snap *snapshot) {
+	if n := len(s.snapshots); n > 0 && sameTree(s.snapshots[n-1].manifest, snap.manifest) {
+		s.snapshots[n-1] = snap
+	} else {
+		s.snapshots = append(s.snapshots, snap)
+	}
+
+	if keep := max(s.cfg.SnapshotRetention, 1); len(s.snapshots) > keep {
+		s.snapshots = s.snapshots[len(s.snapshots)-keep:]
+	}
+	// Older trees, decrypted secrets included, are only kept within
+	// SNAPSHOT_MAX_BYTES. The current tree is always kept.
+	for len(s.snapshots) > 1 && s.cfg.SnapshotMaxBytes > 0 && retainedBytes(s.snapshots[:len(s.snapshots)-1]) > s.cfg.SnapshotMaxBytes {
+		s.snapshots = s.snapshots[1:]
+	}
+	s.current = snap
+	s.published, s.manifest = snap.files, snap.manifest
+
+	infos := make([]snapshotInfo, 0, len(s.snapshots))
+	for _, sn := range s.snapshots {
+		infos = append(infos, snapshotInfo{Commit: sn.manifest.Commit, PublishedAt: sn.publishedAt})
+	}
+	s.mu.Lock()
+	s.snapshotInfos = infos
+	s.served, s.servedCurrent = slices.Clone(s.snapshots), snap
+	s.mu.Unlock()
+}
+
+// retainedBytes sums the file data of snapshots.
+func retainedBytes(snapshots []*snapshot) int64 {
+	var n int64
+	for _, sn := range snapshots {
+		n += totalBytes(sn.files)
+	}
+	return n
+}
+
+func sameTree(a, b *publish.Manifest) bool {
+	return a.Commit == b.Commit && maps.Equal(a.Files, b.Files)
+}
+
+// Rollback republishes a retained snapshot and pins the target to it until
+// Unpin is called. The pin is recorded in the target's manifest, so it
+// survives a restart. to is a commit SHA (or unique prefix) or "previous" for
+// the snapshot published before the current one.
+func (s *Syncer) Rollback(to string) (publish.Metadata, error) {
+	s.syncMu.Lock()
+	defer s.syncMu.Unlock()
+
+	snap, err := findSnapshot(s.snapshots, s.current, to)
+	if err != nil {
+		return publish.Metadata{}, err
+	}
+	prev := s.manifest
+
+	m, err := publish.Publish(s.cfg.TargetPath, snap.manifest.Metadata, snap.files)
+	if err == nil {
+		now := time.Now().UTC()
+		m.PinnedAt = &now
+		err = publish.WriteManifest(s.cfg.TargetPath, m)
+	}
+	if err != nil {
+		s.recordFailure(ErrorClassPublish, err)
+		return publish.Metadata{}, fmt.Errorf("rollback failed: %w", err)
+	}
+
+	s.current = snap
+	s.published, s.manifest = snap.files, m
+	s.pinned = true
+
+	s.mu.Lock()
+	s.pin = &pinStatus{Commit: m.Commit, Since: *m.PinnedAt}
+	s.lastCommit = m.Commit
+	s.servedCurrent = snap
+	s.mu.Unlock()
+
+	fmt.Printf("[%s] Rolled back to commit %s, sync is pinned until /unpin\n",
+		time.Now().Format(time.RFC3339), shortCommit(m.Commit))
+	s.notifyRestarter(context.Background(), prev, m)
+	s.publishEvent(prev, m, true)
+	return m.Metadata, nil
+}
+
+// findSnapshot resolves a rollback target among snapshots, oldest first.
+func findSnapshot(snapshots []*snapshot, current *snapshot, to string) (*snapshot, error) {
+	if to == "" {
+		return nil, fmt.Errorf("%w: empty target", ErrSnapshotNotFound)
+	}
+
+	if to == "previous" {
+		for i, sn := range snapshots {
+			if sn == current && i > 0 {
+				return snapshots[i-1], nil
+			}
+		}
+		return nil, fmt.Errorf("%w: no snapshot before the current one", ErrSnapshotNotFound)
+	}
+
+	var match *snapshot
+	for _, sn := range snapshots {
+		if strings.HasPrefix(sn.manifest.Commit, to) {
+			if match != nil && match.manifest.Commit != sn.manifest.Commit {
+				return nil, fmt.Errorf("%w: %s", ErrAmbiguousSnapshot, to)
+			}
+			// Prefer the newest snapshot of a commit (e.g. after a values change)
+			match = sn
+		}
+	}
+	if match == nil {
+		return nil, fmt.Errorf("%w: %s", ErrSnapshotNotFound, to)
+	}
+	return match, nil
+}
+
+// Unpin lets the target follow the branch again from the next sync. It
+// reports whether the target was pinned.
+func (s *Syncer) Unpin() (bool, error) {
+	s.syncMu.Lock()
+	defer s.syncMu.Unlock()
+
+	was := s.pinned
+	if was {
+		unpinned := *s.manifest
+		unpinned.PinnedAt = nil
+		if err := publish.WriteManifest(s.cfg.TargetPath, &unpinned); err != nil {
+			return true, fmt.Errorf("failed to record the unpin: %w", err)
+		}
+		s.manifest = &unpinned
+	}
+	s.pinned = false
+
+	s.mu.Lock()
+	s.pin = nil
+	s.mu.Unlock()
+
+	if was {
+		fmt.Printf("[%s] Unpinned, following branch %s again\n", time.Now().Format(time.RFC3339), s.cfg.Branch)
+	}
+	return was, nil
+}
+
+// restorePin picks up a pin recorded in the target's manifest by a rollback
+// before a restart. The pinned tree is read back from the target, to be
+// served and repaired again; if it has drifted, the pin still holds and
+// drift detection reports the damage.
+func (s *Syncer) restorePin() error {
+	m, err := publish.ReadManifest(s.cfg.TargetPath)
+	if err != nil || m == nil || m.PinnedAt == nil {
+		return err
+	}
+
+	// Without keys, LoadTree fails on secrets and the tree is not retained
+	keys, _ := s.loadKeys()
+	if files, err := publish.LoadTree(s.cfg.TargetPath, m, keys); err != nil {
+		fmt.Fprintf(os.Stderr, "[%s] Pinned tree of commit %s cannot be read back: %v\n",
+			time.Now().Format(time.RFC3339), shortCommit(m.Commit), err)
+		s.manifest = m
+	} else {
+		s.retain(&snapshot{files: files, manifest: m, publishedAt: *m.PinnedAt})
+	}
+	s.pinned = true
+
+	s.mu.Lock()
+	s.pin = &pinStatus{Commit: m.Commit, Since: *m.PinnedAt}
+	s.lastCommit = m.Commit
+	s.mu.Unlock()
+
+	fmt.Printf("[%s] Still pinned to commit %s since %s, sync is pinned until /unpin\n",
+		time.Now().Format(time.RFC3339), shortCommit(m.Commit), m.PinnedAt.Format(time.RFC3339))
+	return nil
+}
+
+// IsPinned reports whether a rollback pinned the target.
+func (s *Syncer) IsPinned() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.pin != nil
+}
+
+// Tree returns the manifest and files currently published, or those of the
+// retained snapshot matching commit (a SHA or unique prefix). It never waits
+// for a sync in progress.
+func (s *Syncer) Tree(commit string) (*publish.Manifest, []publish.File, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	snap := s.servedCurrent
+	if commit != "" {
+		var err error
+		if snap, err = findSnapshot(s.served, s.servedCurrent, commit); err != nil {
+			return nil, nil, err
+		}
+	}
+	if snap == nil {
+		return nil, nil, fmt.Errorf("%w: nothing published yet", ErrSnapshotNotFound)
+	}
+	return snap.manifest, snap.files, nil
+}
@@ -2,77 +2,306 @@ package sync
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"io"
+	"maps"
 	"os"
 	"path/filepath"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/davidaparicio/microsvcs/projects/git-sync/internal/config"
-	"github.com/davidaparicio/microsvcs/projects/git-sync/internal/git"
+	"github.com/davidaparicio/microsvcs/projects/git-sync/internal/events"
+	"github.com/davidaparicio/microsvcs/projects/git-sync/internal/publish"
+	"github.com/davidaparicio/microsvcs/projects/git-sync/internal/restart"
+	"github.com/davidaparicio/microsvcs/projects/git-sync/internal/source"
+	"github.com/davidaparicio/microsvcs/projects/git-sync/internal/tracing"
+	"go.opentelemetry.io/otel"
+	semconv "go.opentelemetry.io/otel/semconv/v1.41.0"
+	"go.opentelemetry.io/otel/trace"
+	"gopkg.in/yaml.v3"
 )
 
+var tracer = otel.Tracer("github.com/davidaparicio/microsvcs/projects/git-sync/internal/sync")
+
 type Syncer struct {
 	cfg    *config.Config
-	git    *git.Client
+	src    source.Source
 	syncMu sync.Mutex // serializes Sync runs (cron may overlap a slow sync)
 
+	restarter *restart.Restarter // nil unless RESTART_TARGETS is set
+	events    *events.Bus
+
 	mu         sync.RWMutex // guards the status fields below only
 	lastSync   time.Time
 	lastCommit string
 	syncCount  int64
 	errorCount int64
 	healthy    bool
+
+	errorsByClass map[string]int64
+	lastError     *errorStatus
+	drift         driftStatus
+
+	pin           *pinStatus
+	snapshotInfos []snapshotInfo
+	served        []*snapshot // copy of snapshots for readers that must not wait on syncMu
+	servedCurrent *snapshot
+	pending       *publish.ChangeSet // last dry-run result
+	lastDryRun    *dryRunStatus
+
+	// Published trees, guarded by syncMu
+	published []publish.File
+	manifest  *publish.Manifest
+	snapshots []*snapshot // oldest first
+	current   *snapshot
+	pinned    bool
 }
 
 func NewSyncer(cfg *config.Config) (*Syncer, error) {
-	gitClient, err := git.NewClient(cfg)
+	return newSyncer(cfg, events.NewBus(cfg.EventBufferSize))
+}
+
+// newSyncer builds a Syncer publishing its events on bus, which the syncers
+// of a BranchSyncer share.
+func newSyncer(cfg *config.Config, bus *events.Bus) (*Syncer, error) {
+	src, err := source.New(cfg)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create git client: %w", err)
+		return nil, fmt.Errorf("failed to create %s source: %w", cfg.SourceType, err)
 	}
 
-	return &Syncer{
-		cfg:     cfg,
-		git:     gitClient,
-		healthy: false,
-	}, nil
+	var restarter *restart.Restarter
+	if len(cfg.RestartTargets) > 0 {
+		if restarter, err = newRestarter(cfg); err != nil {
+			_ = src.Close()
+			return nil, err
+		}
+	}
+
+	s := &Syncer{
+		cfg:       cfg,
+		src:       src,
+		restarter: restarter,
+		events:    bus,
+		healthy:   false,
+		drift:     driftStatus{Policy: cfg.DriftPolicy},
+
+		errorsByClass: map[string]int64{},
+	}
+	if err := s.restorePin(); err != nil {
+		_ = src.Close()
+		return nil, fmt.Errorf("failed to read the target's manifest: %w", err)
+	}
+	return s, nil
 }
 
 func (s *Syncer) Sync(ctx context.Context) error {
-	// Hold syncMu (not mu) for the clone/pull so health checks stay responsive
+	// Hold syncMu (not mu) for the fetch so health checks stay responsive
 	s.syncMu.Lock()
 	defer s.syncMu.Unlock()
 
-	fmt.Printf("[%s] Starting sync from %s (branch: %s)\n",
-		time.Now().Format(time.RFC3339), s.cfg.RepoURL, s.cfg.Branch)
+	location, ref := s.src.Location()
+	ctx, span := tracer.Start(ctx, "sync", trace.WithAttributes(
+		tracing.SourceType.String(s.sourceType()),
+		semconv.VCSRepositoryURLFull(location),
+		semconv.VCSRefHeadName(ref),
+	))
+	err := s.sync(ctx, location, ref)
+	tracing.End(span, err)
+	return err
+}
 
-	// Clone or pull repository
-	commit, err := s.git.Sync(ctx)
+// sync runs one Sync under its root span. Each phase gets a child span:
+// fetch (with the source's own dns, git.fetch and git.checkout spans),
+// validate and copy.
+func (s *Syncer) sync(ctx context.Context, location, ref string) error {
+	span := trace.SpanFromContext(ctx)
+	fmt.Printf("[%s] Starting sync from %s (ref: %s)\n",
+		time.Now().Format(time.RFC3339), location, ref)
+	s.events.Publish(events.SyncStarted, events.Started{Source: s.sourceType(), URL: location, Ref: ref})
+
+	// Clone/pull the repository or download the artifact
+	fetchCtx, fetchSpan := tracer.Start(ctx, "fetch")
+	commit, err := s.src.Fetch(fetchCtx)
+	if err == nil {
+		fetchSpan.SetAttributes(semconv.VCSRefHeadRevision(commit.ID))
+		span.SetAttributes(semconv.VCSRefHeadRevision(commit.ID))
+	}
+	tracing.End(fetchSpan, err)
 	if err != nil {
-		s.recordFailure()
-		return fmt.Errorf("git sync failed: %w", err)
+		s.recordFailure(classifyFetch(err), err)
+		return fmt.Errorf("%s fetch failed: %w", s.sourceType(), err)
+	}
+	if err := s.checkFetchSize(); err != nil {
+		s.recordFailure(ErrorClassLimit, err)
+		return fmt.Errorf("%s fetch failed: %w", s.sourceType(), err)
 	}
 
-	// Copy files from source path to target path
-	if err := s.copyFiles(); err != nil {
-		s.recordFailure()
-		return fmt.Errorf("file copy failed: %w", err)
+	// In dry-run mode, report what publishing would change and stop there
+	if s.cfg.DryRun {
+		if err := s.dryRun(commit); err != nil {
+			s.recordInvalid(commit.ID, classify(err), err)
+			return fmt.Errorf("dry run failed: %w", err)
+		}
+		s.recordDryRun(commit.ID)
+		s.events.Publish(events.SyncNoop, events.Noop{Ref: s.ref(), Commit: commit.ID, Reason: "dry-run"})
+		return nil
+	}
+
+	// While pinned by a rollback, keep fetching but leave the target alone
+	if s.pinned {
+		s.recordPinnedSync(commit.ID)
+		fmt.Printf("[%s] Pinned to commit %s, not publishing %s\n",
+			time.Now().Format(time.RFC3339), shortCommit(s.manifest.Commit), shortCommit(commit.ID))
+		s.events.Publish(events.SyncNoop, events.Noop{Ref: s.ref(), Commit: s.manifest.Commit, Reason: "pinned"})
+		return nil
+	}
+
+	// Render files from source path into target path. Limits are enforced
+	// while rendering, so a breach leaves the last good content published.
+	_, validateSpan := tracer.Start(ctx, "validate")
+	files, err := s.buildFiles()
+	validateSpan.SetAttributes(tracing.FileCount.Int(len(files)), tracing.FileBytes.Int64(totalBytes(files)))
+	tracing.End(validateSpan, err)
+	if err != nil {
+		s.recordInvalid(commit.ID, classify(err), err)
+		return fmt.Errorf("render failed: %w", err)
+	}
+	copyCtx, copySpan := tracer.Start(ctx, "copy", trace.WithAttributes(tracing.FileCount.Int(len(files))))
+	prev, err := s.publishFiles(copyCtx, commit, files)
+	if err == nil {
+		copySpan.SetAttributes(tracing.ChangedCount.Int(len(publish.ChangedPaths(prev, s.manifest))))
+	}
+	tracing.End(copySpan, err)
+	if err != nil {
+		s.recordFailure(ErrorClassPublish, err)
+		return fmt.Errorf("publish failed: %w", err)
 	}
 
-	s.recordSuccess(commit)
+	s.recordSuccess(commit.ID)
+	s.publishEvent(prev, s.manifest, false)
 
 	fmt.Printf("[%s] Sync completed successfully (commit: %s)\n",
-		time.Now().Format(time.RFC3339), shortCommit(commit))
+		time.Now().Format(time.RFC3339), shortCommit(commit.ID))
 
 	return nil
 }
 
-func (s *Syncer) recordFailure() {
+// totalBytes sums the size of the rendered files.
+func totalBytes(files []publish.File) int64 {
+	var n int64
+	for _, f := range files {
+		n += int64(len(f.Data))
+	}
+	return n
+}
+
+// TemplateEnvPrefix marks the environment variables exposed to templates as
+// .Env: TEMPLATE_ENV_REGION is rendered by {{ .Env.REGION }}.
+const TemplateEnvPrefix = "TEMPLATE_ENV_"
+
+// Error classes reported in status, so alerts can tell a flaky remote from
+// content that will never publish.
+const (
+	ErrorClassFetch   = "fetch"
+	ErrorClassRender  = "render"
+	ErrorClassDecrypt = "decrypt"
+	ErrorClassLimit   = "limit_exceeded"
+	ErrorClassPublish = "publish"
+)
+
+func (s *Syncer) recordFailure(class string, err error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 	s.errorCount++
+	s.errorsByClass[class]++
+	s.lastError = &errorStatus{Class: class, Message: err.Error(), Time: time.Now()}
 	s.healthy = false
+	s.events.Publish(events.SyncFailed, events.Failed{Ref: s.ref(), Class: class, Error: err.Error()})
+}
+
+// recordInvalid records a commit whose content was rejected before anything
+// was published: validation.failed names the commit, then sync.failed follows
+// as for any other failure.
+func (s *Syncer) recordInvalid(commit, class string, err error) {
+	s.events.Publish(events.ValidationFailed, events.Failed{Ref: s.ref(), Commit: commit, Class: class, Error: err.Error()})
+	s.recordFailure(class, err)
+}
+
+// publishEvent announces a publish from prev to next: sync.succeeded with
+// the changed files, or sync.noop when no file changed.
+func (s *Syncer) publishEvent(prev, next *publish.Manifest, rollback bool) {
+	changed := publish.ChangedPaths(prev, next)
+	if len(changed) == 0 && !rollback {
+		s.events.Publish(events.SyncNoop, events.Noop{Ref: s.ref(), Commit: next.Commit, Reason: "unchanged"})
+		return
+	}
+	ev := events.Succeeded{Ref: s.ref(), NewCommit: next.Commit, ChangedFiles: changed, Rollback: rollback}
+	if prev != nil {
+		ev.OldCommit = prev.Commit
+	}
+	s.events.Publish(events.SyncSucceeded, ev)
+}
+
+// Events returns the bus carrying the sync lifecycle, for the /events stream.
+func (s *Syncer) Events() *events.Bus {
+	return s.events
+}
+
+// errorStatus describes the most recent failure.
+type errorStatus struct {
+	Class   string    `json:"class"`
+	Message string    `json:"message"`
+	Time    time.Time `json:"time"`
+}
+
+// classifyFetch maps a fetch error to its class. Non-git sources enforce
+// MAX_FETCH_SIZE while downloading.
+func classifyFetch(err error) string {
+	if errors.Is(err, publish.ErrLimitExceeded) {
+		return ErrorClassLimit
+	}
+	return ErrorClassFetch
+}
+
+// classify maps a build error to its class.
+func classify(err error) string {
+	switch {
+	case errors.Is(err, publish.ErrLimitExceeded):
+		return ErrorClassLimit
+	case errors.Is(err, publish.ErrDecrypt):
+		return ErrorClassDecrypt
+	}
+	return ErrorClassRender
+}
+
+func (s *Syncer) recordPinnedSync(upstream string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastSync = time.Now()
+	s.syncCount++
+	s.healthy = true
+	if s.pin != nil {
+		s.pin.UpstreamCommit = upstream
+	}
+}
+
+// dryRunStatus is the commit the last dry run checked. It is reported apart
+// from lastCommit, as nothing was published.
+type dryRunStatus struct {
+	Commit string    `json:"commit"`
+	Time   time.Time `json:"time"`
+}
+
+// recordDryRun records a successful dry run. lastSync and lastCommit stay
+// those of the last publish.
+func (s *Syncer) recordDryRun(commit string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastDryRun = &dryRunStatus{Commit: commit, Time: time.Now()}
+	s.syncCount++
+	s.healthy = true
 }
 
 func (s *Syncer) recordSuccess(commit string) {
@@ -91,91 +320,185 @@ func shortCommit(commit string) string {
 	return commit
 }
 
-func (s *Syncer) copyFiles() error {
-	sourcePath := filepath.Join(s.git.WorkDir(), s.cfg.SourcePath)
-
-	// Ensure target directory exists
-	if err := os.MkdirAll(s.cfg.TargetPath, 0755); err != nil {
-		return fmt.Errorf("failed to create target directory: %w", err)
+// publishFiles writes the rendered files to the target path and records the
+// commit in the target's manifest. It returns the manifest it replaced, nil
+// on first publish.
+func (s *Syncer) publishFiles(ctx context.Context, commit source.Revision, files []publish.File) (*publish.Manifest, error) {
+	// Compare with what is on disk rather than in memory, so a restart of
+	// git-sync does not look like every file changed
+	prev, err := publish.ReadManifest(s.cfg.TargetPath)
+	if err != nil {
+		return nil, err
 	}
 
-	// Check if source is a file or directory
-	sourceInfo, err := os.Stat(sourcePath)
+	location, ref := s.src.Location()
+	m, err := publish.Publish(s.cfg.TargetPath, publish.Metadata{
+		RepoURL:    location,
+		Ref:        ref,
+		Commit:     commit.ID,
+		CommitTime: commit.Time,
+		Author:     commit.Author,
+	}, files)
 	if err != nil {
-		return fmt.Errorf("failed to stat source path: %w", err)
+		return nil, err
 	}
 
-	// If source is a single file, copy it directly
-	if !sourceInfo.IsDir() {
-		targetFile := filepath.Join(s.cfg.TargetPath, filepath.Base(sourcePath))
-		return copyFile(sourcePath, targetFile)
+	s.retain(&snapshot{files: files, manifest: m, publishedAt: time.Now()})
+	s.notifyRestarter(ctx, prev, m)
+	return prev, nil
+}
+
+// checkFetchSize enforces MAX_FETCH_SIZE on the checkout.
+func (s *Syncer) checkFetchSize() error {
+	if s.cfg.MaxFetchSize <= 0 {
+		return nil
 	}
+	size, err := s.src.DiskUsage()
+	if err != nil {
+		return fmt.Errorf("failed to measure checkout: %w", err)
+	}
+	if size > s.cfg.MaxFetchSize {
+		return &publish.LimitError{Limit: "fetch-size", Max: s.cfg.MaxFetchSize, Actual: size}
+	}
+	return nil
+}
 
-	// Walk source directory and copy files
-	return filepath.Walk(sourcePath, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return err
-		}
+// buildFiles renders the source path of the current checkout.
+func (s *Syncer) buildFiles() ([]publish.File, error) {
+	opts, err := s.publishOptions()
+	if err != nil {
+		return nil, err
+	}
+	return publish.Build(filepath.Join(s.src.WorkDir(), s.cfg.SourcePath), opts)
+}
 
-		// Skip .git directory
-		if info.IsDir() && info.Name() == ".git" {
-			return filepath.SkipDir
-		}
+// dryRun computes the change set publishing commit would produce, without
+// touching the target directory.
+func (s *Syncer) dryRun(commit source.Revision) error {
+	files, err := s.buildFiles()
+	if err != nil {
+		return err
+	}
 
-		// Calculate relative path
-		relPath, err := filepath.Rel(sourcePath, path)
-		if err != nil {
-			return err
-		}
+	cs, err := publish.Diff(s.cfg.TargetPath, files)
+	if err != nil {
+		return err
+	}
+	cs.Commit = commit.ID
 
-		targetPath := filepath.Join(s.cfg.TargetPath, relPath)
+	fmt.Printf("[%s] Dry run for commit %s: %d added, %d modified, %d deleted\n",
+		time.Now().Format(time.RFC3339), shortCommit(commit.ID), len(cs.Added), len(cs.Modified), len(cs.Deleted))
+	for _, c := range cs.Added {
+		fmt.Printf("  + %s (%d bytes)\n", c.Path, c.Size)
+	}
+	for _, c := range cs.Modified {
+		fmt.Printf("  ~ %s (%d -> %d bytes)\n", c.Path, c.OldSize, c.Size)
+	}
+	for _, c := range cs.Deleted {
+		fmt.Printf("  - %s (%d bytes)\n", c.Path, c.Size)
+	}
 
-		if info.IsDir() {
-			return os.MkdirAll(targetPath, info.Mode())
-		}
+	s.mu.Lock()
+	s.pending = cs
+	s.mu.Unlock()
+	return nil
+}
 
-		return copyFile(path, targetPath)
-	})
+// PendingChanges returns the change set computed by the last dry run, or nil.
+func (s *Syncer) PendingChanges() *publish.ChangeSet {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.pending
 }
 
-func copyFile(src, dst string) error {
-	sourceFile, err := os.Open(src)
-	if err != nil {
-		return err
+// publishOptions builds the render options from the config. The values file is
+// re-read on every sync so edits to a mounted ConfigMap are picked up.
+func (s *Syncer) publishOptions() (publish.Options, error) {
+	values := map[string]any{}
+	if s.cfg.TemplateValuesFile != "" {
+		data, err := os.ReadFile(s.cfg.TemplateValuesFile)
+		if err != nil {
+			return publish.Options{}, fmt.Errorf("failed to read template values: %w", err)
+		}
+		if err := yaml.Unmarshal(data, &values); err != nil {
+			return publish.Options{}, fmt.Errorf("failed to parse template values: %w", err)
+		}
 	}
-	defer func() { _ = sourceFile.Close() }()
 
-	destFile, err := os.Create(dst)
+	// Keys are reloaded too, so a rotated Secret takes effect on the next sync
+	keys, err := s.loadKeys()
 	if err != nil {
-		return err
+		return publish.Options{}, err
 	}
-	defer func() { _ = destFile.Close() }()
 
-	if _, err := io.Copy(destFile, sourceFile); err != nil {
-		return err
-	}
+	return publish.Options{
+		Environment:  s.cfg.Environment,
+		Environments: s.cfg.Environments,
+		Templates:    s.cfg.TemplateEnabled,
+		Symlinks:     publish.SymlinkPolicy(s.cfg.SymlinkPolicy),
+		RepoRoot:     s.src.WorkDir(),
+		MaxFiles:     s.cfg.MaxFiles,
+		MaxFileSize:  s.cfg.MaxFileSize,
+		Keys:         keys,
+		Data: map[string]any{
+			"Environment": s.cfg.Environment,
+			"Env":         templateEnv(os.Environ()),
+			"Values":      values,
+		},
+	}, nil
+}
 
-	// Preserve file permissions
-	sourceInfo, err := os.Stat(src)
+// loadKeys reads the age keys, or returns nil when decryption is off.
+func (s *Syncer) loadKeys() (*publish.Keys, error) {
+	if s.cfg.AgeKeyFile == "" {
+		return nil, nil
+	}
+	keys, err := publish.LoadKeys(s.cfg.AgeKeyFile)
 	if err != nil {
-		return err
+		return nil, fmt.Errorf("%w: %w", publish.ErrDecrypt, err)
+	}
+	return keys, nil
+}
+
+// templateEnv returns the variables of environ named with TemplateEnvPrefix,
+// without the prefix. Templates come from the repository, so they must not
+// see the rest of the environment, such as credentials.
+func templateEnv(environ []string) map[string]string {
+	env := make(map[string]string)
+	for _, kv := range environ {
+		k, v, ok := strings.Cut(kv, "=")
+		if name, found := strings.CutPrefix(k, TemplateEnvPrefix); ok && found && name != "" {
+			env[name] = v
+		}
 	}
-	return os.Chmod(dst, sourceInfo.Mode())
+	return env
 }
 
 func (s *Syncer) GetStatus() map[string]any {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
+	location, ref := s.src.Location()
 	return map[string]any{
-		"healthy":    s.healthy,
-		"lastSync":   s.lastSync,
-		"lastCommit": s.lastCommit,
-		"syncCount":  s.syncCount,
-		"errorCount": s.errorCount,
-		"repoURL":    s.cfg.RepoURL,
-		"branch":     s.cfg.Branch,
-		"targetPath": s.cfg.TargetPath,
+		"healthy":     s.healthy,
+		"lastSync":    s.lastSync,
+		"lastCommit":  s.lastCommit,
+		"syncCount":   s.syncCount,
+		"errorCount":  s.errorCount,
+		"errors":      maps.Clone(s.errorsByClass),
+		"lastError":   s.lastError,
+		"sourceType":  s.sourceType(),
+		"repoURL":     location,
+		"branch":      ref,
+		"environment": s.cfg.Environment,
+		"dryRun":      s.cfg.DryRun,
+		"lastDryRun":  s.lastDryRun,
+		"targetPath":  s.cfg.TargetPath,
+		"insecureTLS": s.cfg.GitInsecureSkipTLSVerify,
+		"drift":       s.drift,
+		"pinned":      s.pin,
+		"snapshots":   s.snapshotInfos,
+		"restarts":    s.restarter.Status(),
 	}
 }
 
@@ -185,7 +508,21 @@ func (s *Syncer) IsHealthy() bool {
 	return s.healthy
 }
 
-// Close releases the git client's temporary work directory.
+// Close releases the source's temporary work directory.
 func (s *Syncer) Close() error {
-	return s.git.Close()
+	return s.src.Close()
+}
+
+// ref is the branch, tag or key the source follows, as recorded in events.
+func (s *Syncer) ref() string {
+	_, ref := s.src.Location()
+	return ref
+}
+
+// sourceType names the configured backend for logs and status.
+func (s *Syncer) sourceType() string {
+	if s.cfg.SourceType == "" {
+		return source.TypeGit
+	}
+	return s.cfg.SourceType
 }
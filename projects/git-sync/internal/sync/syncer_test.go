@@ -1,11 +1,36 @@
 package sync_test
 
 import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
+	"filippo.io/age"
 	"github.com/davidaparicio/microsvcs/projects/git-sync/internal/config"
+	"github.com/davidaparicio/microsvcs/projects/git-sync/internal/events"
+	"github.com/davidaparicio/microsvcs/projects/git-sync/internal/publish"
+	"github.com/davidaparicio/microsvcs/projects/git-sync/internal/restart"
 	"github.com/davidaparicio/microsvcs/projects/git-sync/internal/sync"
+	"github.com/davidaparicio/microsvcs/projects/git-sync/internal/tracing"
+	gogit "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	semconv "go.opentelemetry.io/otel/semconv/v1.41.0"
+	"go.opentelemetry.io/otel/trace"
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
 )
 
 func TestNewSyncer(t *testing.T) {
@@ -36,3 +61,715 @@ func TestGetStatus(t *testing.T) {
 	assert.Equal(t, "https://github.com/test/repo.git", status["repoURL"])
 	assert.Equal(t, "main", status["branch"])
 }
+
+// newLocalRepo creates a git repository on disk with the given files committed
+// on branch main, so syncs can run without network access.
+func newLocalRepo(t *testing.T, files map[string]string) (string, *gogit.Repository) {
+	t.Helper()
+	dir := t.TempDir()
+	repo, err := gogit.PlainInitWithOptions(dir, &gogit.PlainInitOptions{
+		InitOptions: gogit.InitOptions{DefaultBranch: plumbing.NewBranchReferenceName("main")},
+	})
+	require.NoError(t, err)
+	commitFiles(t, repo, files)
+	return dir, repo
+}
+
+// commitFiles writes files into the repository worktree and commits them.
+func commitFiles(t *testing.T, repo *gogit.Repository, files map[string]string) string {
+	t.Helper()
+	w, err := repo.Worktree()
+	require.NoError(t, err)
+
+	for rel, content := range files {
+		p := filepath.Join(w.Filesystem.Root(), filepath.FromSlash(rel))
+		require.NoError(t, os.MkdirAll(filepath.Dir(p), 0755))
+		require.NoError(t, os.WriteFile(p, []byte(content), 0644))
+	}
+	require.NoError(t, w.AddGlob("."))
+
+	hash, err := w.Commit("update", &gogit.CommitOptions{
+		Author: &object.Signature{Name: "Test", Email: "test@example.com", When: time.Now()},
+	})
+	require.NoError(t, err)
+	return hash.String()
+}
+
+func TestSync_LocalRepoWritesManifest(t *testing.T) {
+	repoDir, _ := newLocalRepo(t, map[string]string{"color/demo-flags.goff.yaml": "color-box: {}\n"})
+	target := t.TempDir()
+
+	syncer, err := sync.NewSyncer(&config.Config{
+		RepoURL:    repoDir,
+		Branch:     "main",
+		SourcePath: "/color",
+		TargetPath: target,
+	})
+	require.NoError(t, err)
+	defer func() { _ = syncer.Close() }()
+
+	require.NoError(t, syncer.Sync(context.Background()))
+
+	m, err := publish.ReadManifest(target)
+	require.NoError(t, err)
+	require.NotNil(t, m)
+	assert.Equal(t, syncer.GetStatus()["lastCommit"], m.Commit)
+	assert.Equal(t, "Test <test@example.com>", m.Author)
+	assert.Equal(t, "main", m.Ref)
+	assert.Contains(t, m.Files, "demo-flags.goff.yaml")
+
+	report, err := publish.Verify(target, nil)
+	require.NoError(t, err)
+	assert.True(t, report.Clean())
+}
+
+func TestCheckDrift(t *testing.T) {
+	for _, policy := range []string{sync.DriftReport, sync.DriftRestore} {
+		t.Run(policy, func(t *testing.T) {
+			repoDir, _ := newLocalRepo(t, map[string]string{"flags.yaml": "color-box: {}\n"})
+			target := t.TempDir()
+
+			syncer, err := sync.NewSyncer(&config.Config{
+				RepoURL:     repoDir,
+				Branch:      "main",
+				SourcePath:  "/",
+				TargetPath:  target,
+				DriftPolicy: policy,
+			})
+			require.NoError(t, err)
+			defer func() { _ = syncer.Close() }()
+
+			_, err = syncer.CheckDrift()
+			assert.Error(t, err, "nothing to compare before the first sync")
+
+			require.NoError(t, syncer.Sync(context.Background()))
+
+			report, err := syncer.CheckDrift()
+			require.NoError(t, err)
+			assert.True(t, report.Clean())
+
+			// Simulate someone editing the flag file inside the pod
+			flags := filepath.Join(target, "flags.yaml")
+			require.NoError(t, os.WriteFile(flags, []byte("hacked"), 0644))
+
+			report, err = syncer.CheckDrift()
+			require.NoError(t, err)
+			assert.Equal(t, []string{"flags.yaml"}, report.Modified)
+
+			content, err := os.ReadFile(flags)
+			require.NoError(t, err)
+			if policy == sync.DriftRestore {
+				assert.Equal(t, "color-box: {}\n", string(content))
+			} else {
+				assert.Equal(t, "hacked", string(content))
+			}
+
+			status := syncer.GetStatus()
+			assert.Contains(t, status, "drift")
+		})
+	}
+}
+
+func TestRollbackAndUnpin(t *testing.T) {
+	repoDir, repo := newLocalRepo(t, map[string]string{"flags.yaml": "v1"})
+	target := t.TempDir()
+	flags := filepath.Join(target, "flags.yaml")
+
+	syncer, err := sync.NewSyncer(&config.Config{
+		RepoURL:           repoDir,
+		Branch:            "main",
+		SourcePath:        "/",
+		TargetPath:        target,
+		SnapshotRetention: 3,
+	})
+	require.NoError(t, err)
+	defer func() { _ = syncer.Close() }()
+
+	ctx := context.Background()
+	require.NoError(t, syncer.Sync(ctx))
+	first := syncer.GetStatus()["lastCommit"].(string)
+
+	commitFiles(t, repo, map[string]string{"flags.yaml": "v2"})
+	require.NoError(t, syncer.Sync(ctx))
+	assertContent(t, flags, "v2")
+
+	_, err = syncer.Rollback("deadbeef")
+	assert.ErrorIs(t, err, sync.ErrSnapshotNotFound)
+
+	meta, err := syncer.Rollback("previous")
+	require.NoError(t, err)
+	assert.Equal(t, first, meta.Commit)
+	assertContent(t, flags, "v1")
+	assert.True(t, syncer.IsPinned())
+
+	m, err := publish.ReadManifest(target)
+	require.NoError(t, err)
+	assert.Equal(t, first, m.Commit)
+
+	// New upstream commits are fetched but not published while pinned
+	third := commitFiles(t, repo, map[string]string{"flags.yaml": "v3"})
+	require.NoError(t, syncer.Sync(ctx))
+	assertContent(t, flags, "v1")
+
+	status := syncer.GetStatus()
+	assert.Equal(t, first, status["lastCommit"])
+	assert.NotNil(t, status["pinned"])
+
+	was, err := syncer.Unpin()
+	require.NoError(t, err)
+	assert.True(t, was)
+	was, err = syncer.Unpin()
+	require.NoError(t, err)
+	assert.False(t, was)
+	require.NoError(t, syncer.Sync(ctx))
+	assertContent(t, flags, "v3")
+	assert.Equal(t, third, syncer.GetStatus()["lastCommit"])
+
+	// A rollback by SHA prefix works as long as the snapshot is retained
+	_, err = syncer.Rollback(first[:10])
+	require.NoError(t, err)
+	assertContent(t, flags, "v1")
+}
+
+func TestRollbackRetention(t *testing.T) {
+	repoDir, repo := newLocalRepo(t, map[string]string{"flags.yaml": "v1"})
+
+	syncer, err := sync.NewSyncer(&config.Config{
+		RepoURL:           repoDir,
+		Branch:            "main",
+		SourcePath:        "/",
+		TargetPath:        t.TempDir(),
+		SnapshotRetention: 2,
+	})
+	require.NoError(t, err)
+	defer func() { _ = syncer.Close() }()
+
+	ctx := context.Background()
+	require.NoError(t, syncer.Sync(ctx))
+	first := syncer.GetStatus()["lastCommit"].(string)
+
+	// Syncing the same commit again must not use up a snapshot slot
+	require.NoError(t, syncer.Sync(ctx))
+	commitFiles(t, repo, map[string]string{"flags.yaml": "v2"})
+	require.NoError(t, syncer.Sync(ctx))
+	commitFiles(t, repo, map[string]string{"flags.yaml": "v3"})
+	require.NoError(t, syncer.Sync(ctx))
+
+	_, err = syncer.Rollback(first)
+	assert.ErrorIs(t, err, sync.ErrSnapshotNotFound)
+	_, err = syncer.Rollback("previous")
+	assert.NoError(t, err)
+}
+
+func TestRollback_PinSurvivesRestart(t *testing.T) {
+	repoDir, repo := newLocalRepo(t, map[string]string{"flags.yaml": "v1"})
+	target := t.TempDir()
+	flags := filepath.Join(target, "flags.yaml")
+	cfg := &config.Config{
+		RepoURL:           repoDir,
+		Branch:            "main",
+		SourcePath:        "/",
+		TargetPath:        target,
+		SnapshotRetention: 3,
+	}
+
+	syncer, err := sync.NewSyncer(cfg)
+	require.NoError(t, err)
+	ctx := context.Background()
+	require.NoError(t, syncer.Sync(ctx))
+	first := syncer.GetStatus()["lastCommit"].(string)
+	commitFiles(t, repo, map[string]string{"flags.yaml": "v2"})
+	require.NoError(t, syncer.Sync(ctx))
+	_, err = syncer.Rollback("previous")
+	require.NoError(t, err)
+	require.NoError(t, syncer.Close())
+
+	m, err := publish.ReadManifest(target)
+	require.NoError(t, err)
+	require.NotNil(t, m.PinnedAt, "the pin is recorded in the manifest")
+
+	// After a restart the branch head is fetched but not published
+	restarted, err := sync.NewSyncer(cfg)
+	require.NoError(t, err)
+	defer func() { _ = restarted.Close() }()
+	assert.True(t, restarted.IsPinned())
+	require.NoError(t, restarted.Sync(ctx))
+	assertContent(t, flags, "v1")
+	assert.Equal(t, first, restarted.GetStatus()["lastCommit"])
+
+	// The pinned tree is read back, so it is served and repaired again
+	tm, files, err := restarted.Tree("")
+	require.NoError(t, err)
+	assert.Equal(t, first, tm.Commit)
+	require.Len(t, files, 1)
+	assert.Equal(t, "v1", string(files[0].Data))
+
+	was, err := restarted.Unpin()
+	require.NoError(t, err)
+	assert.True(t, was)
+	m, err = publish.ReadManifest(target)
+	require.NoError(t, err)
+	assert.Nil(t, m.PinnedAt)
+	require.NoError(t, restarted.Sync(ctx))
+	assertContent(t, flags, "v2")
+}
+
+func TestRollbackRetention_BoundsRetainedBytes(t *testing.T) {
+	repoDir, repo := newLocalRepo(t, map[string]string{"flags.yaml": "v1-" + strings.Repeat("x", 60)})
+
+	syncer, err := sync.NewSyncer(&config.Config{
+		RepoURL:           repoDir,
+		Branch:            "main",
+		SourcePath:        "/",
+		TargetPath:        t.TempDir(),
+		SnapshotRetention: 5,
+		SnapshotMaxBytes:  100,
+	})
+	require.NoError(t, err)
+	defer func() { _ = syncer.Close() }()
+
+	ctx := context.Background()
+	require.NoError(t, syncer.Sync(ctx))
+	first := syncer.GetStatus()["lastCommit"].(string)
+	commitFiles(t, repo, map[string]string{"flags.yaml": "v2-" + strings.Repeat("x", 60)})
+	require.NoError(t, syncer.Sync(ctx))
+	commitFiles(t, repo, map[string]string{"flags.yaml": "v3-" + strings.Repeat("x", 60)})
+	require.NoError(t, syncer.Sync(ctx))
+
+	// Only one older tree of 63 bytes fits in 100 bytes, next to the current one
+	assert.Len(t, syncer.GetStatus()["snapshots"], 2)
+	_, err = syncer.Rollback(first)
+	assert.ErrorIs(t, err, sync.ErrSnapshotNotFound)
+	_, err = syncer.Rollback("previous")
+	assert.NoError(t, err)
+}
+
+func assertContent(t *testing.T, path, want string) {
+	t.Helper()
+	content, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, want, string(content))
+}
+
+func TestSync_FollowsRewrittenHistory(t *testing.T) {
+	repoDir, repo := newLocalRepo(t, map[string]string{"flags.yaml": "v1"})
+	target := t.TempDir()
+
+	syncer, err := sync.NewSyncer(&config.Config{
+		RepoURL:    repoDir,
+		Branch:     "main",
+		SourcePath: "/",
+		TargetPath: target,
+	})
+	require.NoError(t, err)
+	defer func() { _ = syncer.Close() }()
+
+	first, err := repo.Head()
+	require.NoError(t, err)
+	commitFiles(t, repo, map[string]string{"flags.yaml": "v2"})
+	require.NoError(t, syncer.Sync(context.Background()))
+	assertContent(t, filepath.Join(target, "flags.yaml"), "v2")
+
+	// As after a force push: the synced commit is no longer an ancestor
+	w, err := repo.Worktree()
+	require.NoError(t, err)
+	require.NoError(t, w.Reset(&gogit.ResetOptions{Commit: first.Hash(), Mode: gogit.HardReset}))
+	rewritten := commitFiles(t, repo, map[string]string{"flags.yaml": "v3"})
+	require.NoError(t, syncer.Sync(context.Background()))
+	assertContent(t, filepath.Join(target, "flags.yaml"), "v3")
+	assert.Equal(t, rewritten, syncer.GetStatus()["lastCommit"])
+
+	require.NoError(t, os.RemoveAll(repoDir))
+	assert.ErrorContains(t, syncer.Sync(context.Background()), "fetch failed")
+}
+
+func TestSync_DryRunWritesNothing(t *testing.T) {
+	repoDir, _ := newLocalRepo(t, map[string]string{"flags.yaml": "v1", "extra.yaml": "x"})
+	target := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(target, "flags.yaml"), []byte("v0"), 0644))
+
+	syncer, err := sync.NewSyncer(&config.Config{
+		RepoURL:    repoDir,
+		Branch:     "main",
+		SourcePath: "/",
+		TargetPath: target,
+		DryRun:     true,
+	})
+	require.NoError(t, err)
+	defer func() { _ = syncer.Close() }()
+
+	assert.Nil(t, syncer.PendingChanges())
+	require.NoError(t, syncer.Sync(context.Background()))
+
+	cs := syncer.PendingChanges()
+	require.NotNil(t, cs)
+	status := syncer.GetStatus()
+	assert.Empty(t, status["lastCommit"], "nothing was published")
+	assert.True(t, status["lastSync"].(time.Time).IsZero())
+	assert.Contains(t, fmt.Sprintf("%+v", status["lastDryRun"]), "Commit:"+cs.Commit)
+	assert.True(t, syncer.IsHealthy())
+	assert.Equal(t, []publish.Change{{Path: "extra.yaml", Size: 1}}, cs.Added)
+	assert.Equal(t, []publish.Change{{Path: "flags.yaml", Size: 2, OldSize: 2}}, cs.Modified)
+
+	assertContent(t, filepath.Join(target, "flags.yaml"), "v0")
+	assert.NoFileExists(t, filepath.Join(target, "extra.yaml"))
+	assert.NoFileExists(t, filepath.Join(target, publish.ManifestName))
+}
+
+func TestSync_LimitBreachKeepsLastGoodContent(t *testing.T) {
+	repoDir, repo := newLocalRepo(t, map[string]string{"flags.yaml": "v1"})
+	target := t.TempDir()
+
+	syncer, err := sync.NewSyncer(&config.Config{
+		RepoURL:     repoDir,
+		Branch:      "main",
+		SourcePath:  "/",
+		TargetPath:  target,
+		MaxFileSize: 64,
+	})
+	require.NoError(t, err)
+	defer func() { _ = syncer.Close() }()
+
+	require.NoError(t, syncer.Sync(context.Background()))
+
+	// An accidental large binary next to a flag change
+	commitFiles(t, repo, map[string]string{"flags.yaml": "v2", "dump.bin": string(make([]byte, 1024))})
+	err = syncer.Sync(context.Background())
+	assert.ErrorIs(t, err, publish.ErrLimitExceeded)
+
+	assertContent(t, filepath.Join(target, "flags.yaml"), "v1")
+	assert.NoFileExists(t, filepath.Join(target, "dump.bin"))
+
+	status := syncer.GetStatus()
+	assert.False(t, status["healthy"].(bool))
+	assert.Equal(t, int64(1), status["errors"].(map[string]int64)[sync.ErrorClassLimit])
+	assert.Contains(t, fmt.Sprint(status["lastError"]), sync.ErrorClassLimit)
+}
+
+func TestSync_DecryptsWithMountedKeys(t *testing.T) {
+	id, err := age.GenerateX25519Identity()
+	require.NoError(t, err)
+	var encrypted bytes.Buffer
+	w, err := age.Encrypt(&encrypted, id.Recipient())
+	require.NoError(t, err)
+	_, err = w.Write([]byte("apiKey: s3cr3t\n"))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+
+	repoDir, _ := newLocalRepo(t, map[string]string{"exporter.yaml.age": encrypted.String()})
+	keyDir := t.TempDir()
+	keyFile := filepath.Join(keyDir, "keys.txt")
+	require.NoError(t, os.WriteFile(keyFile, []byte(id.String()+"\n"), 0600))
+	target := t.TempDir()
+
+	cfg := &config.Config{
+		RepoURL:    repoDir,
+		Branch:     "main",
+		SourcePath: "/",
+		TargetPath: target,
+		AgeKeyFile: keyDir,
+	}
+	syncer, err := sync.NewSyncer(cfg)
+	require.NoError(t, err)
+	defer func() { _ = syncer.Close() }()
+
+	require.NoError(t, syncer.Sync(context.Background()))
+	assertContent(t, filepath.Join(target, "exporter.yaml"), "apiKey: s3cr3t\n")
+	info, err := os.Stat(filepath.Join(target, "exporter.yaml"))
+	require.NoError(t, err)
+	assert.Equal(t, publish.SecretMode, info.Mode().Perm())
+
+	// A key that no longer matches fails the sync without leaking either key
+	other, err := age.GenerateX25519Identity()
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(keyFile, []byte(other.String()+"\n"), 0600))
+
+	err = syncer.Sync(context.Background())
+	assert.ErrorIs(t, err, publish.ErrDecrypt)
+	assertContent(t, filepath.Join(target, "exporter.yaml"), "apiKey: s3cr3t\n")
+
+	status := fmt.Sprintf("%+v", syncer.GetStatus())
+	assert.Contains(t, status, sync.ErrorClassDecrypt)
+	assert.NotContains(t, status, "AGE-SECRET-KEY")
+	assert.NotContains(t, status, "s3cr3t")
+}
+
+func TestTree_ServesCurrentAndRetainedSnapshots(t *testing.T) {
+	repoDir, repo := newLocalRepo(t, map[string]string{"flags.yaml": "v1"})
+
+	syncer, err := sync.NewSyncer(&config.Config{
+		RepoURL:           repoDir,
+		Branch:            "main",
+		SourcePath:        "/",
+		TargetPath:        t.TempDir(),
+		SnapshotRetention: 3,
+	})
+	require.NoError(t, err)
+	defer func() { _ = syncer.Close() }()
+
+	_, _, err = syncer.Tree("")
+	assert.ErrorIs(t, err, sync.ErrSnapshotNotFound)
+
+	ctx := context.Background()
+	require.NoError(t, syncer.Sync(ctx))
+	first := syncer.GetStatus()["lastCommit"].(string)
+	second := commitFiles(t, repo, map[string]string{"flags.yaml": "v2"})
+	require.NoError(t, syncer.Sync(ctx))
+
+	m, files, err := syncer.Tree("")
+	require.NoError(t, err)
+	assert.Equal(t, second, m.Commit)
+	assert.Equal(t, "v2", string(files[0].Data))
+
+	m, files, err = syncer.Tree(first[:8])
+	require.NoError(t, err)
+	assert.Equal(t, first, m.Commit)
+	assert.Equal(t, "v1", string(files[0].Data))
+
+	// The served tree follows a rollback
+	_, err = syncer.Rollback("previous")
+	require.NoError(t, err)
+	m, _, err = syncer.Tree("")
+	require.NoError(t, err)
+	assert.Equal(t, first, m.Commit)
+}
+
+func TestSync_SecretChangesAreDetected(t *testing.T) {
+	id, err := age.GenerateX25519Identity()
+	require.NoError(t, err)
+	encrypt := func(plain string) string {
+		var buf bytes.Buffer
+		w, err := age.Encrypt(&buf, id.Recipient())
+		require.NoError(t, err)
+		_, err = w.Write([]byte(plain))
+		require.NoError(t, err)
+		require.NoError(t, w.Close())
+		return buf.String()
+	}
+
+	repoDir, repo := newLocalRepo(t, map[string]string{"flags/exporter.yaml.age": encrypt("apiKey: v1\n")})
+	keyDir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(keyDir, "keys.txt"), []byte(id.String()+"\n"), 0600))
+	target := t.TempDir()
+
+	cfg := &config.Config{
+		RepoURL:     repoDir,
+		Branch:      "main",
+		SourcePath:  "/",
+		TargetPath:  target,
+		AgeKeyFile:  keyDir,
+		DriftPolicy: sync.DriftReport,
+	}
+	syncer, err := sync.NewSyncer(cfg)
+	require.NoError(t, err)
+	defer func() { _ = syncer.Close() }()
+
+	client := fake.NewClientset(&appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "api", Namespace: "flags"}})
+	targets, err := restart.ParseTargets([]string{"deployment/api"}, "flags")
+	require.NoError(t, err)
+	syncer.SetRestarter(restart.New(client, targets, []string{"flags"}, 0))
+	commitOf := func() string {
+		d, err := client.AppsV1().Deployments("flags").Get(context.Background(), "api", metav1.GetOptions{})
+		require.NoError(t, err)
+		return d.Spec.Template.Annotations[restart.Annotation]
+	}
+
+	ctx := context.Background()
+	require.NoError(t, syncer.Sync(ctx))
+
+	// Re-encrypting the same plaintext changes nothing
+	commitFiles(t, repo, map[string]string{"flags/exporter.yaml.age": encrypt("apiKey: v1\n")})
+	require.NoError(t, syncer.Sync(ctx))
+	report, err := syncer.CheckDrift()
+	require.NoError(t, err)
+	assert.True(t, report.Clean())
+
+	// A rotated secret restarts its consumers
+	rotation := commitFiles(t, repo, map[string]string{"flags/exporter.yaml.age": encrypt("apiKey: v2\n")})
+	require.NoError(t, syncer.Sync(ctx))
+	assertContent(t, filepath.Join(target, "flags", "exporter.yaml"), "apiKey: v2\n")
+	assert.Equal(t, rotation, commitOf())
+
+	// and a tampered secret is drift
+	require.NoError(t, os.WriteFile(filepath.Join(target, "flags", "exporter.yaml"), []byte("apiKey: evil\n"), 0640))
+	report, err = syncer.CheckDrift()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"flags/exporter.yaml"}, report.Modified)
+}
+
+func TestSync_RestartsWorkloadsOnWatchedChanges(t *testing.T) {
+	repoDir, repo := newLocalRepo(t, map[string]string{"flags/demo.yaml": "v1", "docs/README.md": "v1"})
+	target := t.TempDir()
+
+	syncer, err := sync.NewSyncer(&config.Config{
+		RepoURL:           repoDir,
+		Branch:            "main",
+		SourcePath:        "/",
+		TargetPath:        target,
+		SnapshotRetention: 3,
+	})
+	require.NoError(t, err)
+	defer func() { _ = syncer.Close() }()
+
+	client := fake.NewClientset(&appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "api", Namespace: "flags"}})
+	targets, err := restart.ParseTargets([]string{"deployment/api"}, "flags")
+	require.NoError(t, err)
+	syncer.SetRestarter(restart.New(client, targets, []string{"flags"}, 0))
+
+	commitOf := func() string {
+		d, err := client.AppsV1().Deployments("flags").Get(context.Background(), "api", metav1.GetOptions{})
+		require.NoError(t, err)
+		return d.Spec.Template.Annotations[restart.Annotation]
+	}
+
+	ctx := context.Background()
+	require.NoError(t, syncer.Sync(ctx))
+	first := syncer.GetStatus()["lastCommit"].(string)
+	assert.Equal(t, first, commitOf())
+
+	// Docs-only change: nothing to restart
+	commitFiles(t, repo, map[string]string{"docs/README.md": "v2"})
+	require.NoError(t, syncer.Sync(ctx))
+	assert.Equal(t, first, commitOf())
+
+	flagsChange := commitFiles(t, repo, map[string]string{"flags/demo.yaml": "v2"})
+	require.NoError(t, syncer.Sync(ctx))
+	assert.Equal(t, flagsChange, commitOf())
+
+	// Rolling back the flags restarts again
+	meta, err := syncer.Rollback(first[:10])
+	require.NoError(t, err)
+	assert.Equal(t, meta.Commit, commitOf())
+
+	assert.Len(t, syncer.GetStatus()["restarts"], 1)
+}
+
+func TestSync_PublishesLifecycleEvents(t *testing.T) {
+	repoDir, repo := newLocalRepo(t, map[string]string{"flags.yaml": "v1", "README.md": "v1"})
+
+	syncer, err := sync.NewSyncer(&config.Config{
+		RepoURL:           repoDir,
+		Branch:            "main",
+		SourcePath:        "/",
+		TargetPath:        t.TempDir(),
+		MaxFileSize:       64,
+		SnapshotRetention: 3,
+	})
+	require.NoError(t, err)
+	defer func() { _ = syncer.Close() }()
+
+	ctx := context.Background()
+	require.NoError(t, syncer.Sync(ctx))
+	first := syncer.GetStatus()["lastCommit"].(string)
+	require.NoError(t, syncer.Sync(ctx))
+	second := commitFiles(t, repo, map[string]string{"flags.yaml": "v2"})
+	require.NoError(t, syncer.Sync(ctx))
+	bad := commitFiles(t, repo, map[string]string{"dump.bin": string(make([]byte, 1024))})
+	require.Error(t, syncer.Sync(ctx))
+	_, err = syncer.Rollback(first)
+	require.NoError(t, err)
+
+	backlog, _, cancel := syncer.Events().Subscribe(0)
+	cancel()
+	var types []string
+	for _, ev := range backlog {
+		types = append(types, ev.Type)
+	}
+	assert.Equal(t, []string{
+		events.SyncStarted, events.SyncSucceeded,
+		events.SyncStarted, events.SyncNoop,
+		events.SyncStarted, events.SyncSucceeded,
+		events.SyncStarted, events.ValidationFailed, events.SyncFailed,
+		events.SyncSucceeded,
+	}, types)
+
+	assert.Equal(t, events.Succeeded{Ref: "main", NewCommit: first, ChangedFiles: []string{"README.md", "flags.yaml"}}, backlog[1].Data)
+	assert.Equal(t, events.Noop{Ref: "main", Commit: first, Reason: "unchanged"}, backlog[3].Data)
+	assert.Equal(t, events.Succeeded{Ref: "main", OldCommit: first, NewCommit: second, ChangedFiles: []string{"flags.yaml"}}, backlog[5].Data)
+	invalid := backlog[7].Data.(events.Failed)
+	assert.Equal(t, bad, invalid.Commit)
+	assert.Equal(t, sync.ErrorClassLimit, invalid.Class)
+	assert.Equal(t, events.Succeeded{Ref: "main", OldCommit: second, NewCommit: first, ChangedFiles: []string{"flags.yaml"}, Rollback: true}, backlog[9].Data)
+}
+
+func TestSync_TracesPhasesUnderIncomingTrace(t *testing.T) {
+	// The global provider can only be delegated to once per process
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	repoDir, _ := newLocalRepo(t, map[string]string{"flags.yaml": "v1", "README.md": "hello"})
+
+	syncer, err := sync.NewSyncer(&config.Config{
+		RepoURL:    repoDir,
+		Branch:     "main",
+		SourcePath: "/",
+		TargetPath: t.TempDir(),
+	})
+	require.NoError(t, err)
+	defer func() { _ = syncer.Close() }()
+
+	// As extracted from the traceparent header of a webhook
+	parent := trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    trace.TraceID{1},
+		SpanID:     trace.SpanID{2},
+		TraceFlags: trace.FlagsSampled,
+		Remote:     true,
+	})
+	require.NoError(t, syncer.Sync(trace.ContextWithRemoteSpanContext(context.Background(), parent)))
+
+	spans := map[string]sdktrace.ReadOnlySpan{}
+	for _, s := range recorder.Ended() {
+		assert.Equal(t, parent.TraceID(), s.SpanContext().TraceID(), s.Name())
+		spans[s.Name()] = s
+	}
+	require.Contains(t, spans, "sync")
+	root := spans["sync"]
+	assert.Equal(t, parent.SpanID(), root.Parent().SpanID())
+	for _, name := range []string{"fetch", "validate", "copy"} {
+		require.Contains(t, spans, name)
+		assert.Equal(t, root.SpanContext().SpanID(), spans[name].Parent().SpanID(), name)
+	}
+	assert.Equal(t, spans["fetch"].SpanContext().SpanID(), spans["git.checkout"].Parent().SpanID())
+	assert.Equal(t, spans["fetch"].SpanContext().SpanID(), spans["git.fetch"].Parent().SpanID())
+
+	attrs := func(s sdktrace.ReadOnlySpan) map[attribute.Key]attribute.Value {
+		m := map[attribute.Key]attribute.Value{}
+		for _, kv := range s.Attributes() {
+			m[kv.Key] = kv.Value
+		}
+		return m
+	}
+	rootAttrs := attrs(root)
+	assert.Equal(t, "git", rootAttrs[tracing.SourceType].AsString())
+	assert.Equal(t, "main", rootAttrs[semconv.VCSRefHeadNameKey].AsString())
+	assert.Equal(t, syncer.GetStatus()["lastCommit"], rootAttrs[semconv.VCSRefHeadRevisionKey].AsString())
+	assert.EqualValues(t, 2, attrs(spans["validate"])[tracing.FileCount].AsInt64())
+	assert.EqualValues(t, 7, attrs(spans["validate"])[tracing.FileBytes].AsInt64())
+	assert.EqualValues(t, 2, attrs(spans["copy"])[tracing.ChangedCount].AsInt64())
+}
+
+func TestSync_TemplatesOnlySeePrefixedEnv(t *testing.T) {
+	t.Setenv("TEMPLATE_ENV_REGION", "eu-west-1")
+	t.Setenv("ADMIN_TOKEN", "s3cr3t")
+	repoDir, repo := newLocalRepo(t, map[string]string{"flags.yaml.tmpl": "region: {{ .Env.REGION }}\n"})
+	target := t.TempDir()
+
+	syncer, err := sync.NewSyncer(&config.Config{
+		RepoURL:         repoDir,
+		Branch:          "main",
+		SourcePath:      "/",
+		TargetPath:      target,
+		TemplateEnabled: true,
+	})
+	require.NoError(t, err)
+	defer func() { _ = syncer.Close() }()
+
+	require.NoError(t, syncer.Sync(context.Background()))
+	assertContent(t, filepath.Join(target, "flags.yaml"), "region: eu-west-1\n")
+
+	// A commit cannot render the rest of the environment
+	commitFiles(t, repo, map[string]string{"flags.yaml.tmpl": "token: {{ .Env.ADMIN_TOKEN }}\n"})
+	err = syncer.Sync(context.Background())
+	assert.ErrorContains(t, err, `map has no entry for key "ADMIN_TOKEN"`)
+	assertContent(t, filepath.Join(target, "flags.yaml"), "region: eu-west-1\n")
+	assert.Equal(t, int64(1), syncer.GetStatus()["errors"].(map[string]int64)[sync.ErrorClassRender])
+}
@@ -0,0 +1,83 @@
+// Package tracing configures the OpenTelemetry tracer provider that the
+// sync phases report to, and holds the span attribute keys they share.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/davidaparicio/microsvcs/projects/git-sync/internal/version"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.41.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Exporters accepted by OTEL_TRACES_EXPORTER.
+const (
+	ExporterNone    = "none"
+	ExporterOTLP    = "otlp"
+	ExporterConsole = "console"
+)
+
+// Attribute keys for sync spans, next to the semconv VCS attributes.
+const (
+	SourceType   = attribute.Key("git_sync.source.type")
+	FileCount    = attribute.Key("git_sync.files.count")
+	FileBytes    = attribute.Key("git_sync.files.bytes")
+	ChangedCount = attribute.Key("git_sync.files.changed")
+)
+
+// Setup installs the tracer provider for exporter and the W3C trace context
+// propagator. With ExporterNone (or "") spans are not recorded, but trace
+// context from incoming requests still propagates. The returned function
+// flushes pending spans.
+func Setup(ctx context.Context, exporter string) (shutdown func(context.Context) error, err error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	var exp sdktrace.SpanExporter
+	switch exporter {
+	case "", ExporterNone:
+		return func(context.Context) error { return nil }, nil
+	case ExporterOTLP:
+		// Endpoint, headers and TLS come from the standard OTEL_EXPORTER_OTLP_* variables
+		exp, err = otlptracehttp.New(ctx)
+	case ExporterConsole:
+		exp, err = stdouttrace.New(stdouttrace.WithWriter(os.Stdout), stdouttrace.WithPrettyPrint())
+	default:
+		return nil, fmt.Errorf("unknown traces exporter %q", exporter)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create %s trace exporter: %w", exporter, err)
+	}
+
+	// OTEL_SERVICE_NAME and OTEL_RESOURCE_ATTRIBUTES override the defaults
+	res, err := resource.New(ctx,
+		resource.WithAttributes(semconv.ServiceName("git-sync"), semconv.ServiceVersion(version.Version)),
+		resource.WithFromEnv(),
+		resource.WithHost(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build trace resource: %w", err)
+	}
+
+	tp := sdktrace.NewTracerProvider(sdktrace.WithBatcher(exp), sdktrace.WithResource(res))
+	otel.SetTracerProvider(tp)
+	return tp.Shutdown, nil
+}
+
+// End records err on span, if any, and ends it.
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -2,46 +2,109 @@ package main
 
 import (
 	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
 	"github.com/davidaparicio/microsvcs/projects/git-sync/internal/config"
+	"github.com/davidaparicio/microsvcs/projects/git-sync/internal/events"
+	"github.com/davidaparicio/microsvcs/projects/git-sync/internal/files"
+	"github.com/davidaparicio/microsvcs/projects/git-sync/internal/publish"
 	"github.com/davidaparicio/microsvcs/projects/git-sync/internal/sync"
+	"github.com/davidaparicio/microsvcs/projects/git-sync/internal/tracing"
 	"github.com/davidaparicio/microsvcs/projects/git-sync/internal/version"
 	"github.com/labstack/echo/v4"
 	"github.com/robfig/cron/v3"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
 )
 
 func main() {
+	cfg := config.LoadFromEnv()
+
+	// "git-sync verify" checks TARGET_PATH against its manifest and exits;
+	// it skips the version banner so its stdout stays valid JSON
+	if len(os.Args) > 1 && os.Args[1] == "verify" {
+		os.Exit(runVerify(cfg))
+	}
+
+	// "git-sync once [--dry-run]" syncs a single time and exits
+	if len(os.Args) > 1 && os.Args[1] == "once" {
+		fs := flag.NewFlagSet("once", flag.ExitOnError)
+		dryRun := fs.Bool("dry-run", cfg.DryRun, "fetch and report pending changes without writing to TARGET_PATH")
+		_ = fs.Parse(os.Args[2:])
+		cfg.SyncOnce = true
+		cfg.DryRun = *dryRun
+	}
+
+	// A one-off dry run prints its change set as JSON: everything else,
+	// including what the syncer logs, goes to stderr so stdout can be piped
+	report := os.Stdout
+	if cfg.SyncOnce && cfg.DryRun {
+		os.Stdout = os.Stderr
+	}
+
 	version.PrintVersion()
 
-	cfg := config.LoadFromEnv()
 	if err := cfg.Validate(); err != nil {
 		fmt.Fprintf(os.Stderr, "Configuration error: %v\n", err)
 		os.Exit(1)
 	}
 
-	// Initialize syncer
-	syncer, err := sync.NewSyncer(cfg)
+	shutdownTracing, err := tracing.Setup(context.Background(), cfg.TracesExporter)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to set up tracing: %v\n", err)
+		os.Exit(1)
+	}
+	defer func() {
+		// Flush spans still queued in the batcher
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := shutdownTracing(ctx); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to flush traces: %v\n", err)
+		}
+	}()
+
+	// Initialize syncer. In multi-branch mode syncer stays nil, as rollback,
+	// dry run, drift checks and the file server assume a single tree.
+	var (
+		runner service
+		syncer *sync.Syncer
+	)
+	if cfg.BranchPattern != "" {
+		runner, err = sync.NewBranchSyncer(cfg)
+	} else {
+		syncer, err = sync.NewSyncer(cfg)
+		runner = syncer
+	}
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Failed to initialize syncer: %v\n", err)
 		os.Exit(1)
 	}
-	defer func() { _ = syncer.Close() }()
+	defer func() { _ = runner.Close() }()
 
 	// Initial sync
 	fmt.Println("Performing initial sync...")
-	if err := syncer.Sync(context.Background()); err != nil {
+	if err := runner.Sync(context.Background()); err != nil {
 		fmt.Fprintf(os.Stderr, "Initial sync failed: %v\n", err)
 		os.Exit(1)
 	}
 
 	// If SYNC_ONCE is true, exit after initial sync
 	if cfg.SyncOnce {
+		if cfg.DryRun {
+			enc := json.NewEncoder(report)
+			enc.SetIndent("", "  ")
+			_ = enc.Encode(syncer.PendingChanges())
+		}
 		fmt.Println("SYNC_ONCE is enabled, exiting after initial sync")
 		return
 	}
@@ -50,7 +113,7 @@ func main() {
 	c := cron.New()
 	_, err = c.AddFunc(cfg.SyncInterval, func() {
 		ctx := context.Background()
-		if err := syncer.Sync(ctx); err != nil {
+		if err := runner.Sync(ctx); err != nil {
 			fmt.Fprintf(os.Stderr, "Sync failed: %v\n", err)
 		}
 	})
@@ -58,6 +121,18 @@ func main() {
 		fmt.Fprintf(os.Stderr, "Failed to schedule sync: %v\n", err)
 		os.Exit(1)
 	}
+	// A dry run never publishes, so there is nothing to check for drift
+	if syncer != nil && !cfg.DryRun && cfg.DriftPolicy != "" && cfg.DriftPolicy != "off" {
+		_, err = c.AddFunc(cfg.DriftInterval, func() {
+			if _, err := syncer.CheckDrift(); err != nil {
+				fmt.Fprintf(os.Stderr, "Drift check failed: %v\n", err)
+			}
+		})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to schedule drift check: %v\n", err)
+			os.Exit(1)
+		}
+	}
 	c.Start()
 	defer c.Stop()
 
@@ -66,10 +141,28 @@ func main() {
 	// HTTP server for health checks
 	e := echo.New()
 	e.HideBanner = true
-	e.GET("/healthz", healthzHandler(syncer))
-	e.GET("/readyz", readyzHandler(syncer))
-	e.GET("/metrics", metricsHandler(syncer))
+	e.GET("/healthz", healthzHandler(runner))
+	e.GET("/readyz", readyzHandler(runner))
+	e.GET("/metrics", metricsHandler(runner))
+	e.GET("/status", metricsHandler(runner))
 	e.GET("/version", versionHandler)
+	e.GET("/events", events.Handler(runner.Events()))
+	// Shutdown waits for open requests, which an event stream never finishes
+	e.Server.RegisterOnShutdown(runner.Events().Close)
+
+	// Per route rather than a group: an echo group's middleware also runs for
+	// paths that match no route, which would answer 401 instead of 404
+	admin := requireAdminToken(cfg.AdminToken)
+	e.POST("/sync", syncHandler(runner), admin)
+	if syncer != nil {
+		e.GET("/dry-run", dryRunHandler(syncer))
+		if cfg.FileServerEnabled {
+			e.Match([]string{http.MethodGet, http.MethodHead}, "/files/*", files.Handler(syncer))
+		}
+
+		e.POST("/rollback", rollbackHandler(syncer), admin)
+		e.POST("/unpin", unpinHandler(syncer), admin)
+	}
 
 	// Graceful shutdown
 	go func() {
@@ -92,7 +185,48 @@ func main() {
 	}
 }
 
-func healthzHandler(syncer *sync.Syncer) echo.HandlerFunc {
+// service is what the scheduler and the health endpoints need from either
+// a Syncer or a multi-branch BranchSyncer.
+type service interface {
+	Sync(ctx context.Context) error
+	IsHealthy() bool
+	GetStatus() map[string]any
+	Events() *events.Bus
+	Close() error
+}
+
+// runVerify reports tampering or drift in the target directory. It exits 0
+// when the tree matches the manifest, 1 on drift and 2 if it cannot check.
+func runVerify(cfg *config.Config) int {
+	// Secrets are only checked against their HMAC with the age keys
+	var keys *publish.Keys
+	if cfg.AgeKeyFile != "" {
+		k, err := publish.LoadKeys(cfg.AgeKeyFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Verify failed: %v\n", err)
+			return 2
+		}
+		keys = k
+	}
+
+	report, err := publish.Verify(cfg.TargetPath, keys)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Verify failed: %v\n", err)
+		return 2
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(report)
+
+	if !report.Clean() {
+		fmt.Fprintf(os.Stderr, "%s does not match the manifest for commit %s\n", cfg.TargetPath, report.Commit)
+		return 1
+	}
+	return 0
+}
+
+func healthzHandler(syncer service) echo.HandlerFunc {
 	return func(c echo.Context) error {
 		if syncer.IsHealthy() {
 			return c.NoContent(http.StatusNoContent)
@@ -101,7 +235,7 @@ func healthzHandler(syncer *sync.Syncer) echo.HandlerFunc {
 	}
 }
 
-func readyzHandler(syncer *sync.Syncer) echo.HandlerFunc {
+func readyzHandler(syncer service) echo.HandlerFunc {
 	return func(c echo.Context) error {
 		if syncer.IsHealthy() {
 			return c.JSON(http.StatusOK, map[string]string{"status": "ready"})
@@ -110,13 +244,98 @@ func readyzHandler(syncer *sync.Syncer) echo.HandlerFunc {
 	}
 }
 
-func metricsHandler(syncer *sync.Syncer) echo.HandlerFunc {
+func metricsHandler(syncer service) echo.HandlerFunc {
 	return func(c echo.Context) error {
 		status := syncer.GetStatus()
 		return c.JSON(http.StatusOK, status)
 	}
 }
 
+func dryRunHandler(syncer *sync.Syncer) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		cs := syncer.PendingChanges()
+		if cs == nil {
+			return c.JSON(http.StatusNotFound, map[string]string{"error": "no dry run result (DRY_RUN is not enabled)"})
+		}
+		return c.JSON(http.StatusOK, cs)
+	}
+}
+
+// requireAdminToken guards state-changing endpoints with a bearer token.
+// Without ADMIN_TOKEN they are disabled, as the service is exposed by an Ingress.
+func requireAdminToken(token string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if token == "" {
+				return c.JSON(http.StatusForbidden, map[string]string{"error": "admin endpoints are disabled (ADMIN_TOKEN is not set)"})
+			}
+			got := strings.TrimPrefix(c.Request().Header.Get("Authorization"), "Bearer ")
+			if subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+				return c.JSON(http.StatusUnauthorized, map[string]string{"error": "invalid or missing bearer token"})
+			}
+			return next(c)
+		}
+	}
+}
+
+func rollbackHandler(syncer *sync.Syncer) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		meta, err := syncer.Rollback(c.QueryParam("to"))
+		switch {
+		case errors.Is(err, sync.ErrSnapshotNotFound):
+			return c.JSON(http.StatusNotFound, map[string]string{"error": err.Error()})
+		case errors.Is(err, sync.ErrAmbiguousSnapshot):
+			return c.JSON(http.StatusConflict, map[string]string{"error": err.Error()})
+		case err != nil:
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		}
+		return c.JSON(http.StatusOK, map[string]any{"pinned": true, "commit": meta.Commit})
+	}
+}
+
+func unpinHandler(syncer *sync.Syncer) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		was, err := syncer.Unpin()
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		}
+		if !was {
+			return c.JSON(http.StatusOK, map[string]any{"pinned": false})
+		}
+
+		// Catch up with the branch now rather than at the next cron tick
+		ctx := requestTraceContext(c.Request())
+		go func() {
+			if err := syncer.Sync(ctx); err != nil {
+				fmt.Fprintf(os.Stderr, "Sync after unpin failed: %v\n", err)
+			}
+		}()
+		return c.JSON(http.StatusAccepted, map[string]any{"pinned": false})
+	}
+}
+
+// syncHandler triggers a sync, typically from a push webhook. The sync runs
+// in the background, as a fetch can outlast the sender's timeout, and its
+// spans join the trace of the webhook request when it carries a traceparent.
+func syncHandler(runner service) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		ctx := requestTraceContext(c.Request())
+		go func() {
+			if err := runner.Sync(ctx); err != nil {
+				fmt.Fprintf(os.Stderr, "Triggered sync failed: %v\n", err)
+			}
+		}()
+		return c.JSON(http.StatusAccepted, map[string]any{"triggered": true})
+	}
+}
+
+// requestTraceContext returns a context carrying the trace context of req
+// but not its cancellation, for work that outlives the request.
+func requestTraceContext(req *http.Request) context.Context {
+	ctx := context.WithoutCancel(req.Context())
+	return otel.GetTextMapPropagator().Extract(ctx, propagation.HeaderCarrier(req.Header))
+}
+
 func versionHandler(c echo.Context) error {
 	return c.JSON(http.StatusOK, map[string]string{
 		"version":   version.Version,
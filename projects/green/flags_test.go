@@ -0,0 +1,60 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRequestedFlags(t *testing.T) {
+	e := echo.New()
+	flags := func(query string) []string {
+		req := httptest.NewRequest(http.MethodGet, "/"+query, nil)
+		return requestedFlags(e.NewContext(req, httptest.NewRecorder()))
+	}
+
+	assert.Equal(t, []string{defaultFlag}, flags(""))
+	assert.Equal(t, []string{defaultFlag}, flags("?flag="))
+	assert.Equal(t, []string{"dark-mode"}, flags("?flag=dark-mode"))
+	assert.Equal(t, []string{"color-box", "dark-mode", "beta"}, flags("?flag=color-box&flag=dark-mode,beta&flag=color-box"))
+}
+
+func TestFlagTypeAndCellClass(t *testing.T) {
+	assert.Equal(t, "boolean", flagType(true))
+	assert.Equal(t, "string", flagType("red"))
+	assert.Equal(t, "number", flagType(3))
+	assert.Equal(t, "number", flagType(0.5))
+	assert.Equal(t, "json", flagType(map[string]any{"a": 1}))
+
+	assert.Equal(t, "on", cellClass(true))
+	assert.Equal(t, "off", cellClass(false))
+	assert.Equal(t, "red", cellClass("red"))
+}
+
+func TestFlagErrorStatus(t *testing.T) {
+	assert.Equal(t, http.StatusNotFound, flagErrorStatus(fmt.Errorf("%w %q", errUnknownFlag, "x")))
+	assert.Equal(t, http.StatusBadRequest, flagErrorStatus(fmt.Errorf("%w: x", errUnsupportedFlag)))
+	assert.Equal(t, http.StatusServiceUnavailable, flagErrorStatus(fmt.Errorf("cache not ready")))
+}
+
+func TestTemplate_RendersFlagList(t *testing.T) {
+	tmpl := template.Must(template.ParseGlob("assets/view/*.html"))
+	var out bytes.Buffer
+	require.NoError(t, tmpl.ExecuteTemplate(&out, "flags.html", FlagsPageData{
+		Flags: []flagInfo{
+			{Key: "color-box", Type: "string", Default: "grey", Renderable: true},
+			{Key: "limits", Type: "json", Default: "map[max:3]"},
+		},
+		DefaultFlag: "color-box",
+	}))
+	assert.Contains(t, out.String(), `<a href="./?flag=color-box">color-box</a> (default)`)
+	assert.Contains(t, out.String(), `<input type="checkbox" name="flag" value="color-box">`)
+	assert.NotContains(t, out.String(), `value="limits"`, "only renderable flags can be picked")
+}
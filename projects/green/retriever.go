@@ -0,0 +1,209 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/thomaspoignant/go-feature-flag/retriever"
+	"github.com/thomaspoignant/go-feature-flag/retriever/fileretriever"
+	"github.com/thomaspoignant/go-feature-flag/retriever/githubretriever"
+	"github.com/thomaspoignant/go-feature-flag/retriever/gitlabretriever"
+	"github.com/thomaspoignant/go-feature-flag/retriever/httpretriever"
+)
+
+// serviceAccountDir is where Kubernetes mounts the pod's service account.
+const serviceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+
+// retrieverKinds are the values of -retriever.
+var retrieverKinds = []string{"file", "http", "github", "gitlab", "configmap"}
+
+// retrieverConfig says where go-feature-flag reads the flag config from.
+// Only the fields of the selected Kind are used.
+type retrieverConfig struct {
+	Kind    string
+	Timeout time.Duration
+
+	File string // file
+
+	URL string // http
+
+	Repo    string // github and gitlab, as owner/name
+	Branch  string
+	Path    string
+	BaseURL string // gitlab
+
+	Namespace string // configmap
+	ConfigMap string
+	Key       string
+}
+
+// String describes the source of the flag config for the startup log.
+func (c retrieverConfig) String() string {
+	switch c.Kind {
+	case "file":
+		return c.File
+	case "http":
+		return c.URL
+	case "github", "gitlab":
+		return fmt.Sprintf("%s %s@%s:%s", c.Kind, c.Repo, c.Branch, c.Path)
+	case "configmap":
+		return fmt.Sprintf("configmap %s/%s[%s]", c.Namespace, c.ConfigMap, c.Key)
+	}
+	return c.Kind
+}
+
+// retriever returns the go-feature-flag retriever of c. The github and
+// gitlab retrievers authenticate with GITHUB_TOKEN and GITLAB_TOKEN.
+func (c retrieverConfig) retriever() (retriever.Retriever, error) {
+	switch c.Kind {
+	case "file":
+		return &fileretriever.Retriever{Path: c.File}, nil
+	case "http":
+		if c.URL == "" {
+			return nil, errors.New("-retriever=http needs -httpURL")
+		}
+		return &httpretriever.Retriever{URL: c.URL, Timeout: c.Timeout}, nil
+	case "github", "gitlab":
+		if c.Repo == "" || c.Path == "" {
+			return nil, fmt.Errorf("-retriever=%s needs -gitRepo and -gitPath", c.Kind)
+		}
+		if c.Kind == "gitlab" {
+			return &gitlabretriever.Retriever{
+				BaseURL:        c.BaseURL,
+				RepositorySlug: c.Repo,
+				Branch:         c.Branch,
+				FilePath:       c.Path,
+				GitlabToken:    os.Getenv("GITLAB_TOKEN"),
+				Timeout:        c.Timeout,
+			}, nil
+		}
+		return &githubretriever.Retriever{
+			RepositorySlug: c.Repo,
+			Branch:         c.Branch,
+			FilePath:       c.Path,
+			GithubToken:    os.Getenv("GITHUB_TOKEN"),
+			Timeout:        c.Timeout,
+		}, nil
+	case "configmap":
+		if c.ConfigMap == "" || c.Namespace == "" {
+			return nil, errors.New("-retriever=configmap needs -configMap and a namespace")
+		}
+		return newConfigMapRetriever(c.Namespace, c.ConfigMap, c.Key, c.Timeout)
+	}
+	return nil, fmt.Errorf("unknown retriever %q, want one of %s", c.Kind, strings.Join(retrieverKinds, ", "))
+}
+
+// configMapRetriever reads the flag config from one key of a ConfigMap
+// through the Kubernetes API, as the pod's service account, which needs get
+// on that ConfigMap. Talking to the API directly keeps client-go out of the
+// binary.
+type configMapRetriever struct {
+	url       string
+	key       string
+	tokenFile string
+	client    *http.Client
+}
+
+func newConfigMapRetriever(namespace, name, key string, timeout time.Duration) (*configMapRetriever, error) {
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, errors.New("the configmap retriever only runs in a Kubernetes pod")
+	}
+	ca, err := os.ReadFile(filepath.Join(serviceAccountDir, "ca.crt"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the cluster CA: %w", err)
+	}
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(ca) {
+		return nil, errors.New("no certificate in the cluster CA")
+	}
+	return &configMapRetriever{
+		url:       fmt.Sprintf("https://%s/api/v1/namespaces/%s/configmaps/%s", net.JoinHostPort(host, port), url.PathEscape(namespace), url.PathEscape(name)),
+		key:       key,
+		tokenFile: filepath.Join(serviceAccountDir, "token"),
+		client: &http.Client{
+			Timeout:   timeout,
+			Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots}},
+		},
+	}, nil
+}
+
+// Retrieve implements retriever.Retriever. The token is read on every call,
+// as Kubernetes rotates it.
+func (r *configMapRetriever) Retrieve(ctx context.Context) ([]byte, error) {
+	token, err := os.ReadFile(r.tokenFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the service account token: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, r.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	req.Header.Set("Accept", "application/json")
+
+	res, err := r.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = res.Body.Close() }()
+	if res.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(res.Body, 512))
+		return nil, fmt.Errorf("GET %s: %s: %s", r.url, res.Status, strings.TrimSpace(string(body)))
+	}
+
+	var configMap struct {
+		Data map[string]string `json:"data"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&configMap); err != nil {
+		return nil, fmt.Errorf("failed to decode the configmap: %w", err)
+	}
+	data, ok := configMap.Data[r.key]
+	if !ok {
+		return nil, fmt.Errorf("the configmap has no key %q", r.key)
+	}
+	return []byte(data), nil
+}
+
+// recordingRetriever keeps the last flag config its retriever returned, so
+// /api/split reads the config in use without fetching it again.
+type recordingRetriever struct {
+	retriever.Retriever
+
+	mu   sync.Mutex
+	data []byte
+}
+
+// Retrieve implements retriever.Retriever.
+func (r *recordingRetriever) Retrieve(ctx context.Context) ([]byte, error) {
+	data, err := r.Retriever.Retrieve(ctx)
+	if err == nil {
+		r.mu.Lock()
+		r.data = data
+		r.mu.Unlock()
+	}
+	return data, err
+}
+
+// last returns the flag config of the last successful Retrieve.
+func (r *recordingRetriever) last(context.Context) ([]byte, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.data == nil {
+		return nil, errors.New("the flag config has not been retrieved yet")
+	}
+	return r.data, nil
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thomaspoignant/go-feature-flag/retriever/fileretriever"
+	"github.com/thomaspoignant/go-feature-flag/retriever/githubretriever"
+	"github.com/thomaspoignant/go-feature-flag/retriever/gitlabretriever"
+	"github.com/thomaspoignant/go-feature-flag/retriever/httpretriever"
+)
+
+func TestRetrieverConfig_Retriever(t *testing.T) {
+	t.Setenv("GITHUB_TOKEN", "gh-token")
+	t.Setenv("GITLAB_TOKEN", "gl-token")
+
+	r, err := retrieverConfig{Kind: "file", File: "flags.yaml"}.retriever()
+	require.NoError(t, err)
+	assert.Equal(t, &fileretriever.Retriever{Path: "flags.yaml"}, r)
+
+	r, err = retrieverConfig{Kind: "http", URL: "https://flags.example.com/demo.yaml", Timeout: time.Second}.retriever()
+	require.NoError(t, err)
+	assert.Equal(t, &httpretriever.Retriever{URL: "https://flags.example.com/demo.yaml", Timeout: time.Second}, r)
+
+	git := retrieverConfig{Repo: "davidaparicio/microsvcs", Branch: "main", Path: "projects/red/demo-flags.goff.yaml", BaseURL: "https://gitlab.example.com", Timeout: time.Second}
+	git.Kind = "github"
+	r, err = git.retriever()
+	require.NoError(t, err)
+	assert.Equal(t, &githubretriever.Retriever{
+		RepositorySlug: "davidaparicio/microsvcs",
+		Branch:         "main",
+		FilePath:       "projects/red/demo-flags.goff.yaml",
+		GithubToken:    "gh-token",
+		Timeout:        time.Second,
+	}, r)
+	git.Kind = "gitlab"
+	r, err = git.retriever()
+	require.NoError(t, err)
+	assert.Equal(t, "gl-token", r.(*gitlabretriever.Retriever).GitlabToken)
+	assert.Equal(t, "https://gitlab.example.com", r.(*gitlabretriever.Retriever).BaseURL)
+
+	for _, c := range []retrieverConfig{
+		{Kind: "http"},
+		{Kind: "github", Repo: "davidaparicio/microsvcs"},
+		{Kind: "configmap", Namespace: "red"},
+		{Kind: "s3"},
+	} {
+		_, err := c.retriever()
+		assert.Error(t, err, c.Kind)
+	}
+}
+
+func TestConfigMapRetriever(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer sa-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.Path {
+		case "/api/v1/namespaces/red/configmaps/flags":
+			_, _ = w.Write([]byte(`{"kind": "ConfigMap", "data": {"demo-flags.goff.yaml": "color-box: {}\n"}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`{"kind": "Status", "reason": "NotFound"}`))
+		}
+	}))
+	defer srv.Close()
+
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	require.NoError(t, os.WriteFile(tokenFile, []byte("sa-token\n"), 0o600))
+	r := &configMapRetriever{
+		url:       srv.URL + "/api/v1/namespaces/red/configmaps/flags",
+		key:       "demo-flags.goff.yaml",
+		tokenFile: tokenFile,
+		client:    srv.Client(),
+	}
+	data, err := r.Retrieve(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "color-box: {}\n", string(data))
+
+	r.key = "other.yaml"
+	_, err = r.Retrieve(context.Background())
+	assert.ErrorContains(t, err, `no key "other.yaml"`)
+
+	r.url = srv.URL + "/api/v1/namespaces/red/configmaps/missing"
+	_, err = r.Retrieve(context.Background())
+	assert.ErrorContains(t, err, "404")
+}
+
+type fakeRetriever struct {
+	data []byte
+	err  error
+}
+
+func (f *fakeRetriever) Retrieve(context.Context) ([]byte, error) {
+	return f.data, f.err
+}
+
+func TestRecordingRetriever_KeepsLastConfig(t *testing.T) {
+	fake := &fakeRetriever{}
+	r := &recordingRetriever{Retriever: fake}
+	_, err := r.last(context.Background())
+	assert.Error(t, err, "nothing retrieved yet")
+
+	fake.data = []byte("v1")
+	_, err = r.Retrieve(context.Background())
+	require.NoError(t, err)
+	fake.data, fake.err = nil, errors.New("unreachable")
+	_, err = r.Retrieve(context.Background())
+	require.Error(t, err)
+
+	data, err := r.last(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "v1", string(data), "a failed read keeps the previous config")
+}
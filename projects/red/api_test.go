@@ -0,0 +1,17 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSummarize(t *testing.T) {
+	colors := map[string]string{"user0": "red", "user1": "red", "user2": "grey"}
+	assert.Equal(t, map[string]variationSummary{
+		"red":  {Count: 2, Percentage: 66.67},
+		"grey": {Count: 1, Percentage: 33.33},
+	}, summarize(colors))
+
+	assert.Empty(t, summarize(map[string]string{}))
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExplanation_Summarize(t *testing.T) {
+	tests := []struct {
+		e    explanation
+		want string
+	}{
+		{
+			explanation{Key: "k42", User: "user42", Value: "red", Variation: "red_var", Reason: "TARGETING_MATCH", Rule: "beta-testers"},
+			`user42 got red (red_var): targeting rule "beta-testers" matched`,
+		},
+		{
+			explanation{Key: "k42", Value: "grey", Variation: "default_var", Reason: "TARGETING_MATCH_SPLIT"},
+			"k42 got grey (default_var): a targeting rule matched and split its users",
+		},
+		{
+			explanation{Key: "k42", Value: "red", Variation: "red_var", Reason: "SPLIT"},
+			"k42 got red (red_var): the default rule split the users by percentage",
+		},
+		{
+			explanation{Key: "k42", Value: "on", Variation: "enabled", Reason: "DEFAULT"},
+			"k42 got on (enabled): no targeting rule matched, so the default rule applied",
+		},
+		{
+			explanation{Key: "k42", Value: "grey", Reason: "ERROR", ErrorCode: "TYPE_MISMATCH"},
+			"k42 got grey: the evaluation failed with TYPE_MISMATCH, so the default value applied",
+		},
+		{
+			explanation{Key: "k42", Value: "grey", Reason: "UNKNOWN"},
+			"k42 got grey: go-feature-flag gave the reason UNKNOWN",
+		},
+	}
+	for _, tt := range tests {
+		tt.e.summarize()
+		assert.Equal(t, tt.want, tt.e.Summary, tt.e.Reason)
+	}
+}
+
+func TestExplainHandler_NeedsUser(t *testing.T) {
+	e := echo.New()
+	req := httptest.NewRequest(http.MethodGet, "/api/explain", nil)
+	rec := httptest.NewRecorder()
+	assert.NoError(t, explainHandler(e.NewContext(req, rec)))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Contains(t, rec.Body.String(), "missing ?user=")
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bytes"
+	"html/template"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func keys(users []user) []string {
+	out := make([]string, len(users))
+	for i, u := range users {
+		out[i] = u.Context.GetKey()
+	}
+	return out
+}
+
+func TestGeneratePopulation_IsStablePerSeed(t *testing.T) {
+	a := generatePopulation(100, 7, nil)
+	require.Len(t, a, 100)
+	assert.Equal(t, "user0", a[0].Name)
+	assert.Equal(t, "user99", a[99].Name)
+	assert.Equal(t, keys(a), keys(generatePopulation(100, 7, nil)), "same seed, same keys")
+	assert.NotEqual(t, keys(a), keys(generatePopulation(100, 8, nil)))
+	assert.Equal(t, keys(a)[:10], keys(generatePopulation(10, 7, nil)), "growing the population keeps existing keys")
+}
+
+func TestParseDistributions(t *testing.T) {
+	dists, err := parseDistributions(defaultAttributes)
+	require.NoError(t, err)
+	require.Len(t, dists, 4)
+	assert.Equal(t, "country", dists[0].Name)
+	assert.Equal(t, []any{"FR", "US", "DE", "JP"}, dists[0].Values)
+	assert.Equal(t, []float64{30, 30, 20, 20}, dists[0].Weights)
+	assert.Equal(t, []any{true, false}, dists[2].Values, "booleans")
+
+	dists, err = parseDistributions("")
+	require.NoError(t, err)
+	assert.Empty(t, dists)
+
+	for _, spec := range []string{"country", "=FR:1", "key=a:1", "country=FR", "country=FR:x", "country=FR:-1", "country=FR:0"} {
+		_, err := parseDistributions(spec)
+		assert.Error(t, err, spec)
+	}
+}
+
+func TestGeneratePopulation_Attributes(t *testing.T) {
+	dists, err := parseDistributions("country=FR:75,US:25;beta=true:0,false:1;email=example.com:1")
+	require.NoError(t, err)
+	users := generatePopulation(2000, 7, dists)
+
+	assert.Equal(t, users[42].Attributes, generatePopulation(100, 7, dists)[42].Attributes, "same seed, same attributes")
+	assert.Equal(t, "user3@example.com", users[3].Attributes["email"])
+	assert.Equal(t, "FR", users[3].Context.GetCustom()["country"], "attributes are in the evaluation context")
+
+	fr := 0
+	for _, u := range users {
+		assert.Equal(t, false, u.Attributes["beta"])
+		if u.Attributes["country"] == "FR" {
+			fr++
+		}
+	}
+	assert.InDelta(t, 1500, fr, 100)
+}
+
+func TestLoadPopulation(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		p := filepath.Join(dir, name)
+		require.NoError(t, os.WriteFile(p, []byte(content), 0o600))
+		return p
+	}
+
+	users, err := loadPopulation(write("users.txt", "# QA accounts\nalice\n\nbob\n"))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"alice", "bob"}, keys(users))
+	assert.Equal(t, "user1", users[1].Name)
+
+	users, err = loadPopulation(write("users.json", `["alice", "bob"]`))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"alice", "bob"}, keys(users))
+
+	users, err = loadPopulation(write("objects.json", `[{"key": "alice", "country": "FR", "beta": true}, {"key": "bob"}]`))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"alice", "bob"}, keys(users))
+	assert.Equal(t, map[string]any{"country": "FR", "beta": true}, users[0].Attributes)
+	assert.Equal(t, true, users[0].Context.GetCustom()["beta"])
+	assert.Nil(t, users[1].Attributes)
+
+	users, err = loadPopulation(write("cohort.csv", "key,country,beta\nalice,FR,true\nbob,,false\n"))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"alice", "bob"}, keys(users))
+	assert.Equal(t, map[string]any{"country": "FR", "beta": true}, users[0].Attributes)
+	assert.Equal(t, map[string]any{"beta": false}, users[1].Attributes, "empty cells are left unset")
+	_, err = loadPopulation(write("nokey.csv", "id,country\nalice,FR\n"))
+	assert.ErrorContains(t, err, "key")
+
+	_, err = loadPopulation(write("dup.txt", "alice\nalice\n"))
+	assert.ErrorContains(t, err, "duplicate")
+	_, err = loadPopulation(write("empty.txt", "# nobody\n"))
+	assert.ErrorContains(t, err, "no users")
+	_, err = loadPopulation(filepath.Join(dir, "missing.txt"))
+	assert.Error(t, err)
+}
+
+func TestGridRows(t *testing.T) {
+	rows := gridRows(generatePopulation(2*gridColumns+3, 1, nil))
+	require.Len(t, rows, 3)
+	assert.Len(t, rows[0], gridColumns)
+	require.Len(t, rows[2], 3)
+	assert.Equal(t, "user102", rows[2][2].Name)
+}
+
+func TestGridGroups(t *testing.T) {
+	users := []user{
+		newUser(0, "a", map[string]any{"country": "FR"}),
+		newUser(1, "b", map[string]any{"country": "US"}),
+		newUser(2, "c", nil),
+		newUser(3, "d", map[string]any{"country": "FR", "plan": "pro"}),
+	}
+	assert.Equal(t, []string{"country", "plan"}, attributeNames(users))
+
+	groups := gridGroups(users, "")
+	require.Len(t, groups, 1)
+	assert.Empty(t, groups[0].Label)
+	assert.Equal(t, 4, groups[0].Count)
+
+	groups = gridGroups(users, "country")
+	require.Len(t, groups, 3)
+	assert.Equal(t, "country = FR", groups[0].Label)
+	assert.Equal(t, 2, groups[0].Count)
+	assert.Equal(t, "user3", groups[0].Rows[0][1].Name)
+	assert.Equal(t, "country = US", groups[1].Label)
+	assert.Equal(t, "no country", groups[2].Label, "users without the attribute come last")
+
+	assert.Equal(t, "user3 (d)\ncountry: FR\nplan: pro", groups[0].Rows[0][1].Title)
+}
+
+func TestTemplate_RendersPopulation(t *testing.T) {
+	tmpl := template.Must(template.ParseGlob("assets/view/*.html"))
+	users := []user{
+		newUser(0, "a", map[string]any{"plan": "free"}),
+		newUser(1, "b", map[string]any{"plan": "pro"}),
+		newUser(2, "c", map[string]any{"plan": "free"}),
+	}
+	var out bytes.Buffer
+	require.NoError(t, tmpl.ExecuteTemplate(&out, "template.html", PageData{
+		Panels:     []gridPanel{{Flag: "color-box", Users: map[string]string{"user0": "red", "user1": "grey", "user2": "red"}}},
+		Groups:     gridGroups(users, "plan"),
+		GroupLinks: groupLinks(nil, attributeNames(users), "plan"),
+	}))
+	assert.Contains(t, out.String(), "<td id=\"color-box/user1\" class=\"grey\" title=\"user1 (b)\nplan: pro\">")
+	assert.Equal(t, 3, strings.Count(out.String(), "<td "))
+	assert.Contains(t, out.String(), "plan = free (2 users)")
+	assert.Contains(t, out.String(), `<a href="?">none</a>`)
+	assert.NotContains(t, out.String(), "<caption>", "a single grid has no caption")
+
+	// One grid per flag, side by side
+	out.Reset()
+	require.NoError(t, tmpl.ExecuteTemplate(&out, "template.html", PageData{
+		Panels: []gridPanel{
+			{Flag: "color-box", Users: map[string]string{"user0": "red", "user1": "grey", "user2": "red"}},
+			{Flag: "dark-mode", Users: map[string]string{"user0": "on", "user1": "off", "user2": "off"}},
+		},
+		Groups: gridGroups(users, ""),
+	}))
+	assert.Equal(t, 6, strings.Count(out.String(), "<td "))
+	assert.Contains(t, out.String(), `<td id="dark-mode/user0" class="on"`)
+	assert.Contains(t, out.String(), "<caption>dark-mode</caption>")
+}
+
+func TestGroupLinks(t *testing.T) {
+	assert.Nil(t, groupLinks(nil, nil, ""))
+
+	query := url.Values{"flag": {"color-box", "dark-mode"}}
+	links := groupLinks(query, []string{"country", "plan"}, "plan")
+	assert.Equal(t, []groupLink{
+		{Label: "none", Href: "?flag=color-box&flag=dark-mode"},
+		{Label: "country", Href: "?flag=color-box&flag=dark-mode&groupBy=country"},
+		{Label: "plan", Href: "?flag=color-box&flag=dark-mode&groupBy=plan", Current: true},
+	}, links)
+	assert.NotContains(t, query, "groupBy", "the query is left untouched")
+}
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"math"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"gopkg.in/yaml.v3"
+)
+
+// significance is the p-value under which the observed split is reported
+// as not fitting the configured percentages.
+const significance = 0.05
+
+var errNoSplit = errors.New("no percentage split in the default rule")
+
+// defaultRuleReasons are the go-feature-flag reasons of a variation served by
+// the default rule. Only these users are drawn from the percentages.
+var defaultRuleReasons = []string{"SPLIT", "DEFAULT", "STATIC"}
+
+// flagDefinition is the part of a go-feature-flag flag that the split check
+// needs. YAML decoding also accepts JSON flag files.
+type flagDefinition struct {
+	Variations  map[string]any `yaml:"variations"`
+	DefaultRule *struct {
+		Percentage map[string]float64 `yaml:"percentage"`
+	} `yaml:"defaultRule"`
+}
+
+// splitVariation compares one color of the split with what users got.
+// Several variations may share a color, such as grey_var and default_var.
+type splitVariation struct {
+	Color              string   `json:"color"`
+	Variations         []string `json:"variations,omitempty"`
+	Weight             float64  `json:"weight"`
+	ExpectedPercentage float64  `json:"expectedPercentage"`
+	Observed           int      `json:"observed"`
+	ObservedPercentage float64  `json:"observedPercentage"`
+	Deviation          float64  `json:"deviation"` // percentage points
+}
+
+// splitOutcome is the color one user got and the reason go-feature-flag
+// gave for it.
+type splitOutcome struct {
+	Color  string
+	Reason string
+}
+
+// splitReport is the result of comparing the observed distribution of a
+// flag with its defaultRule.percentage weights.
+type splitReport struct {
+	Flag             string           `json:"flag"`
+	Users            int              `json:"users"`
+	Tested           int              `json:"tested"` // users served by the default rule
+	WeightsTotal     float64          `json:"weightsTotal"`
+	Warnings         []string         `json:"warnings,omitempty"`
+	Variations       []splitVariation `json:"variations"`
+	ChiSquared       float64          `json:"chiSquared"`
+	DegreesOfFreedom int              `json:"degreesOfFreedom"`
+	PValue           float64          `json:"pValue"`
+	Fits             bool             `json:"fits"`
+}
+
+// splitHandler compares the color of every user with the percentages of the
+// flag given with ?flag=, in the flag file returned by read.
+func splitHandler(read func(context.Context) ([]byte, error)) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		data, err := read(c.Request().Context())
+		if err != nil {
+			return c.JSON(http.StatusServiceUnavailable, map[string]string{"error": err.Error()})
+		}
+		key := requestedFlag(c)
+		weights, err := parseSplit(data, key)
+		if errors.Is(err, errNoSplit) {
+			return c.JSON(http.StatusNotFound, map[string]string{"error": err.Error()})
+		}
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		}
+		outcomes, err := evaluateSplit(key)
+		if err != nil {
+			return c.JSON(flagErrorStatus(err), map[string]string{"error": err.Error()})
+		}
+		report := compareSplit(weights, outcomes)
+		report.Flag = key
+		return c.JSON(http.StatusOK, report)
+	}
+}
+
+// evaluateSplit evaluates flag for every user with variation details, so the
+// users a targeting rule served can be told apart.
+func evaluateSplit(flag string) (map[string]splitOutcome, error) {
+	outcomes := make(map[string]splitOutcome, len(users))
+	for _, u := range users {
+		e, err := explainFlag(flag, u.Context)
+		if err != nil {
+			return nil, err
+		}
+		outcomes[u.Name] = splitOutcome{Color: e.Value, Reason: e.Reason}
+	}
+	return outcomes, nil
+}
+
+// parseSplit returns the configured weight of each variation value of flag,
+// as the grid shows values rather than variation names, with booleans as on
+// and off.
+func parseSplit(data []byte, flag string) ([]splitVariation, error) {
+	var flags map[string]flagDefinition
+	if err := yaml.Unmarshal(data, &flags); err != nil {
+		return nil, fmt.Errorf("failed to parse flag file: %w", err)
+	}
+	def, ok := flags[flag]
+	if !ok {
+		return nil, fmt.Errorf("flag %q is not in the flag file", flag)
+	}
+	if def.DefaultRule == nil || len(def.DefaultRule.Percentage) == 0 {
+		return nil, fmt.Errorf("flag %q: %w", flag, errNoSplit)
+	}
+
+	var split []splitVariation
+	for name, weight := range def.DefaultRule.Percentage {
+		value, ok := def.Variations[name]
+		if !ok {
+			return nil, fmt.Errorf("flag %q: percentage refers to unknown variation %q", flag, name)
+		}
+		color := cellClass(value)
+		i := slices.IndexFunc(split, func(v splitVariation) bool { return v.Color == color })
+		if i < 0 {
+			split = append(split, splitVariation{Color: color})
+			i = len(split) - 1
+		}
+		split[i].Variations = append(split[i].Variations, name)
+		split[i].Weight += weight
+	}
+	for i := range split {
+		slices.Sort(split[i].Variations)
+	}
+	return split, nil
+}
+
+// compareSplit normalises the weights and tests the colors of the users the
+// default rule served against them with a chi-squared goodness-of-fit test.
+// Users served by targeting rules, or given the default value after an
+// error, are left out, whatever their color.
+func compareSplit(split []splitVariation, outcomes map[string]splitOutcome) splitReport {
+	report := splitReport{Users: len(outcomes)}
+	observed := make(map[string]int)
+	skipped := make(map[string]int)
+	for _, o := range outcomes {
+		if !slices.Contains(defaultRuleReasons, o.Reason) {
+			skipped[o.Reason]++
+			continue
+		}
+		observed[o.Color]++
+		report.Tested++
+	}
+	for _, reason := range slices.Sorted(maps.Keys(skipped)) {
+		report.Warnings = append(report.Warnings, fmt.Sprintf("%d users got their color with the reason %s and are not tested", skipped[reason], reason))
+	}
+
+	for _, v := range split {
+		report.WeightsTotal += v.Weight
+	}
+	if report.WeightsTotal != 100 {
+		report.Warnings = append(report.Warnings, fmt.Sprintf("percentages sum to %g, not 100", report.WeightsTotal))
+	}
+
+	variations := slices.Clone(split)
+	for _, color := range slices.Sorted(maps.Keys(observed)) {
+		if !slices.ContainsFunc(variations, func(v splitVariation) bool { return v.Color == color }) {
+			variations = append(variations, splitVariation{Color: color})
+			report.Warnings = append(report.Warnings, fmt.Sprintf("%d users got %s, which is not in the split", observed[color], color))
+		}
+	}
+
+	// Only default rule users whose color is part of the split count
+	inSplit := 0
+	for _, v := range split {
+		if v.Weight > 0 {
+			inSplit += observed[v.Color]
+		}
+	}
+	tested := 0
+	for i := range variations {
+		v := &variations[i]
+		v.Observed = observed[v.Color]
+		if report.WeightsTotal > 0 {
+			v.ExpectedPercentage = round2(v.Weight / report.WeightsTotal * 100)
+		}
+		if report.Tested > 0 {
+			v.ObservedPercentage = round2(float64(v.Observed) / float64(report.Tested) * 100)
+		}
+		v.Deviation = round2(v.ObservedPercentage - v.ExpectedPercentage)
+
+		if v.Weight <= 0 {
+			if v.Observed > 0 && len(v.Variations) > 0 {
+				report.Warnings = append(report.Warnings, fmt.Sprintf("%d users got %s, which has a weight of 0", v.Observed, v.Color))
+			}
+			continue
+		}
+		expected := float64(inSplit) * v.Weight / report.WeightsTotal
+		if expected > 0 {
+			report.ChiSquared += math.Pow(float64(v.Observed)-expected, 2) / expected
+			tested++
+		}
+	}
+	slices.SortFunc(variations, func(a, b splitVariation) int {
+		if a.Weight != b.Weight {
+			return cmp.Compare(b.Weight, a.Weight)
+		}
+		return strings.Compare(a.Color, b.Color)
+	})
+	report.Variations = variations
+
+	report.PValue = 1
+	if tested > 1 {
+		report.DegreesOfFreedom = tested - 1
+		report.PValue = math.Round(chiSquaredSurvival(report.ChiSquared, report.DegreesOfFreedom)*10000) / 10000
+	}
+	report.ChiSquared = round2(report.ChiSquared)
+	report.Fits = report.PValue >= significance
+	return report
+}
+
+// chiSquaredSurvival returns P(X >= x) for a chi-squared distribution with df
+// degrees of freedom: the regularized upper incomplete gamma Q(df/2, x/2),
+// computed by series below a+1 and by continued fraction above.
+func chiSquaredSurvival(x float64, df int) float64 {
+	if x <= 0 {
+		return 1
+	}
+	a, x := float64(df)/2, x/2
+	lg, _ := math.Lgamma(a)
+	prefix := math.Exp(-x + a*math.Log(x) - lg)
+
+	if x < a+1 {
+		sum, term := 1/a, 1/a
+		for n := 1; n < 1000; n++ {
+			term *= x / (a + float64(n))
+			sum += term
+			if term < sum*1e-15 {
+				break
+			}
+		}
+		return 1 - sum*prefix
+	}
+
+	// Modified Lentz's method
+	const tiny = 1e-300
+	b := x + 1 - a
+	c := 1 / tiny
+	d := 1 / b
+	h := d
+	for i := 1; i < 1000; i++ {
+		an := -float64(i) * (float64(i) - a)
+		b += 2
+		d = an*d + b
+		if math.Abs(d) < tiny {
+			d = tiny
+		}
+		c = b + an/c
+		if math.Abs(c) < tiny {
+			c = tiny
+		}
+		d = 1 / d
+		del := d * c
+		h *= del
+		if math.Abs(del-1) < 1e-15 {
+			break
+		}
+	}
+	return prefix * h
+}
+
+func round2(f float64) float64 {
+	return math.Round(f*100) / 100
+}
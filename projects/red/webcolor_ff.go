@@ -2,55 +2,33 @@ package main
 
 import (
 	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"html/template"
 	"io"
 	"log"
-	"math"
 	"net/http"
+	"net/url"
 	"os"
+	"os/signal"
 	"runtime"
+	"slices"
 	"strings"
-	"sync"
+	"syscall"
 	"time"
 
 	"github.com/davidaparicio/microsvcs/projects/red/internal/name"
 	"github.com/davidaparicio/microsvcs/projects/red/internal/version"
-	"github.com/google/uuid"
 	"github.com/labstack/echo/v4"
 	ffclient "github.com/thomaspoignant/go-feature-flag"
-	"github.com/thomaspoignant/go-feature-flag/ffcontext"
-	"github.com/thomaspoignant/go-feature-flag/retriever/fileretriever"
+	"github.com/thomaspoignant/go-feature-flag/notifier"
 )
 
-var users = make(map[string]ffcontext.EvaluationContext, 2500)
+// users is the population shown in the grid, in grid order.
+var users []user
 
-// renderMetrics tracks server-side rendering performance
-type renderMetrics struct {
-	mu           sync.Mutex
-	requestCount int64
-	totalMs      float64
-	lastMs       float64
-	maxMs        float64
-	minMs        float64
-}
-
-func (m *renderMetrics) record(ms float64) {
-	m.mu.Lock()
-	defer m.mu.Unlock()
-	m.requestCount++
-	m.totalMs += ms
-	m.lastMs = ms
-	if ms > m.maxMs {
-		m.maxMs = ms
-	}
-	if m.minMs == 0 || ms < m.minMs {
-		m.minMs = ms
-	}
-}
-
-var metrics = &renderMetrics{}
+var metrics = newMetrics(ffclient.GetCacheRefreshDate)
 
 // KPIData holds rendering speed metrics
 type KPIData struct {
@@ -59,7 +37,10 @@ type KPIData struct {
 
 // PageData holds all data to be rendered in the template
 type PageData struct {
-	Users      map[string]string
+	Panels     []gridPanel // one grid per requested flag
+	Groups     []gridGroup // layout shared by every panel
+	GroupLinks []groupLink
+	Visitor    visitorInfo // who the page is rendered for
 	SystemInfo SystemInfo
 	KPI        KPIData
 }
@@ -81,44 +62,113 @@ type SystemInfo struct {
 func main() {
 	version.PrintVersion()
 
-	configFile := flag.String("configFile", "/app/config/demo-flags.goff.yaml", "path to feature flags file")
+	var source retrieverConfig
+	flag.StringVar(&source.Kind, "retriever", "file", "where to read the flag config: file, http, github, gitlab or configmap")
+	flag.StringVar(&source.File, "configFile", "/app/config/demo-flags.goff.yaml", "path to feature flags file")
+	flag.StringVar(&source.URL, "httpURL", "", "URL of the flag config, for -retriever=http")
+	flag.StringVar(&source.Repo, "gitRepo", "", "repository holding the flag config, as owner/name, for -retriever=github or gitlab")
+	flag.StringVar(&source.Branch, "gitBranch", "main", "branch of -gitRepo")
+	flag.StringVar(&source.Path, "gitPath", "", "path of the flag config in -gitRepo")
+	flag.StringVar(&source.BaseURL, "gitlabURL", "https://gitlab.com", "GitLab instance, for -retriever=gitlab")
+	flag.StringVar(&source.ConfigMap, "configMap", "", "ConfigMap holding the flag config, for -retriever=configmap")
+	flag.StringVar(&source.Key, "configMapKey", "demo-flags.goff.yaml", "key of the flag config in -configMap")
+	flag.StringVar(&source.Namespace, "configMapNamespace", "", "namespace of -configMap (default: the pod's)")
+	flag.DurationVar(&source.Timeout, "retrieverTimeout", 10*time.Second, "timeout of one read of the flag config, except from a file")
+	pollingInterval := flag.Duration("pollingInterval", time.Second, "how often the flag config is read")
+	populationSize := flag.Int("users", 2500, "number of generated users")
+	seed := flag.Int64("seed", 1, "seed for the generated user keys; replicas with the same seed show the same grid")
+	attributes := flag.String("attributes", defaultAttributes, "distributions of the generated user attributes, as name=value:weight,...;name=... (empty for none)")
+	usersFile := flag.String("usersFile", "", "file with the users: keys one per line, a JSON array of keys or objects, or a CSV with a key column (overrides -users, -seed and -attributes)")
+	flag.StringVar(&defaultFlag, "flag", defaultFlag, "flag rendered when a request does not name one with ?flag=")
 	flag.Parse()
 
+	if *pollingInterval < time.Second {
+		log.Fatalf("-pollingInterval must be at least 1s, got %s", *pollingInterval)
+	}
+	if source.Namespace == "" {
+		source.Namespace = name.GetNamespace()
+	}
+	retriever, err := source.retriever()
+	if err != nil {
+		log.Fatalf("Invalid flag config source: %v", err)
+	}
+	config := &recordingRetriever{Retriever: retriever}
+	fmt.Printf("Reading flags from %s every %s.\n", source, *pollingInterval)
+
+	grids := newGridRegistry(evaluateFlag)
 	if err := ffclient.Init(ffclient.Config{
-		PollingInterval: 1 * time.Second,
+		PollingInterval: *pollingInterval,
 		Context:         context.Background(),
-		Retriever: &fileretriever.Retriever{
-			Path: *configFile,
-		},
+		Retriever:       config,
+		Notifiers:       []notifier.Notifier{grids},
 	}); err != nil {
 		log.Fatalf("Failed to initialize feature flag client: %v", err)
 	}
 
 	e := echo.New()
 	e.HideBanner = true
+	e.Use(metrics.middleware())
 	e.Static("/js", "assets/js")
 	e.Static("/css", "assets/css")
 	// Instantiate a template registry and register all html files inside the view folder
 	e.Renderer = &TemplateRegistry{templates: template.Must(template.ParseGlob("assets/view/*.html"))}
 
 	// init users
-	for i := 0; i < 2500; i++ {
-		id := uuid.New()
-		u := ffcontext.NewEvaluationContext(id.String())
-		users[fmt.Sprintf("user%d", i)] = u
+	if *usersFile != "" {
+		var err error
+		if users, err = loadPopulation(*usersFile); err != nil {
+			log.Fatalf("Failed to load users: %v", err)
+		}
+	} else {
+		if *populationSize < 1 {
+			log.Fatalf("-users must be at least 1, got %d", *populationSize)
+		}
+		dists, err := parseDistributions(*attributes)
+		if err != nil {
+			log.Fatalf("Invalid -attributes: %v", err)
+		}
+		users = generatePopulation(*populationSize, *seed, dists)
+	}
+	fmt.Printf("Evaluating %s for %d users.\n", defaultFlag, len(users))
+	if _, err := grids.get(defaultFlag); err != nil {
+		log.Printf("Failed to evaluate the default flag: %v", err)
 	}
 
 	e.GET("/", apiHandler)
+	e.GET("/flags", flagsHandler)
 	e.GET("/version", versionHandler)
 	e.GET("/healthz", healthzHandler)
-	e.GET("/metrics", metricsHandler)
+	e.GET("/metrics", metrics.handler())
+	e.GET("/events", eventsHandler(grids))
+	e.GET("/api/colors", colorsHandler)
+	e.GET("/api/colors/summary", colorsSummaryHandler)
+	e.GET("/api/split", splitHandler(config.last))
+	e.GET("/api/me", meHandler)
+	e.GET("/api/explain", explainHandler)
 
 	port := os.Getenv("PORT")
 	if port == "" {
 		port = "8080"
 	}
 	fmt.Printf("Starting HTTP server (%s/%s) listening on port %s.\n", runtime.GOOS, runtime.GOARCH, port)
-	e.Logger.Fatal(e.Start(":" + port))
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		if err := e.Start(":" + port); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			e.Logger.Fatal(err)
+		}
+	}()
+	<-ctx.Done()
+
+	fmt.Println("Shutting down.")
+	// /events streams never end on their own, Shutdown would wait on them
+	grids.close()
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := e.Shutdown(shutdownCtx); err != nil {
+		log.Printf("Failed to shut down the HTTP server: %v", err)
+	}
+	ffclient.Close()
 }
 
 type TemplateRegistry struct {
@@ -151,23 +201,54 @@ func getCircle(color string) string {
 func apiHandler(c echo.Context) error {
 	start := time.Now()
 
-	// Get user color variations
-	mapToRender := make(map[string]string, 2500)
-	for k, user := range users {
-		color, err := ffclient.StringVariation("color-box", user, "grey")
+	// Get the variations of every requested flag
+	flags := requestedFlags(c)
+	panels := make([]gridPanel, 0, len(flags))
+	for _, key := range flags {
+		colors, err := evaluateFlag(key)
 		if err != nil {
-			log.Printf("Feature flag evaluation error for %s: %v", k, err)
+			return echo.NewHTTPError(flagErrorStatus(err), err.Error())
 		}
-		mapToRender[k] = color
+		panels = append(panels, gridPanel{Flag: key, Users: colors})
 	}
 
-	serverRenderMs := float64(time.Since(start).Microseconds()) / 1000.0
-	metrics.record(serverRenderMs)
+	elapsed := time.Since(start)
+	metrics.renderDuration.Observe(elapsed.Seconds())
+	serverRenderMs := float64(elapsed.Microseconds()) / 1000.0
 	kpi := KPIData{
 		ServerRenderMs: serverRenderMs,
 	}
 
-	// Get system information
+	// Group the grid by a user attribute, to see targeting rules at work
+	attributes := attributeNames(users)
+	groupBy := c.QueryParam("groupBy")
+	if !slices.Contains(attributes, groupBy) {
+		groupBy = ""
+	}
+
+	// The group by links keep the requested flags
+	query := url.Values{}
+	if c.QueryParams().Has("flag") {
+		query["flag"] = flags
+	}
+	if as := c.QueryParam("as"); as != "" {
+		query.Set("as", as)
+	}
+
+	pageData := PageData{
+		Panels:     panels,
+		Groups:     gridGroups(users, groupBy),
+		GroupLinks: groupLinks(query, attributes, groupBy),
+		Visitor:    describeVisitor(c, flags),
+		SystemInfo: systemInfo(c),
+		KPI:        kpi,
+	}
+
+	return c.Render(http.StatusOK, "template.html", pageData)
+}
+
+// systemInfo describes the pod serving the request.
+func systemInfo(c echo.Context) SystemInfo {
 	hostname := name.GetHostname()
 	namespace := name.GetNamespace()
 	displayName := ""
@@ -179,7 +260,7 @@ func apiHandler(c echo.Context) error {
 	podColor := strings.SplitN(hostname, "-", 2)[0]
 	circles := getCircle(namespace) + getCircle(podColor)
 
-	sysInfo := SystemInfo{
+	return SystemInfo{
 		DisplayName:    displayName,
 		OS:             runtime.GOOS,
 		Arch:           runtime.GOARCH,
@@ -191,14 +272,6 @@ func apiHandler(c echo.Context) error {
 		ServiceVersion: version.Version,
 		ServiceCommit:  version.GitCommit,
 	}
-
-	pageData := PageData{
-		Users:      mapToRender,
-		SystemInfo: sysInfo,
-		KPI:        kpi,
-	}
-
-	return c.Render(http.StatusOK, "template.html", pageData)
 }
 
 func versionHandler(c echo.Context) error {
@@ -209,35 +282,6 @@ func versionHandler(c echo.Context) error {
 	return c.JSON(http.StatusOK, response)
 }
 
-func metricsHandler(c echo.Context) error {
-	metrics.mu.Lock()
-	count := metrics.requestCount
-	totalMs := metrics.totalMs
-	lastMs := metrics.lastMs
-	maxMs := metrics.maxMs
-	minMs := metrics.minMs
-	metrics.mu.Unlock()
-
-	avgMs := 0.0
-	if count > 0 {
-		avgMs = math.Round(totalMs/float64(count)*100) / 100
-	}
-
-	out := fmt.Sprintf(
-		"# HELP http_render_duration_milliseconds Server-side page render duration in milliseconds.\n"+
-			"# TYPE http_render_duration_milliseconds gauge\n"+
-			"http_render_duration_milliseconds{stat=\"last\"} %.2f\n"+
-			"http_render_duration_milliseconds{stat=\"avg\"} %.2f\n"+
-			"http_render_duration_milliseconds{stat=\"min\"} %.2f\n"+
-			"http_render_duration_milliseconds{stat=\"max\"} %.2f\n"+
-			"# HELP http_render_requests_total Total number of page render requests.\n"+
-			"# TYPE http_render_requests_total counter\n"+
-			"http_render_requests_total %d\n",
-		lastMs, avgMs, minMs, maxMs, count,
-	)
-	return c.String(http.StatusOK, out)
-}
-
 func healthzHandler(c echo.Context) error {
 	return c.NoContent(http.StatusOK)
 }
@@ -91,13 +91,13 @@ func TestPageDataStructure(t *testing.T) {
 	}
 
 	pageData := PageData{
-		Users:      map[string]string{"user1": "red"},
+		Panels:     []gridPanel{{Flag: "color-box", Users: map[string]string{"user1": "red"}}},
 		SystemInfo: sysInfo,
 	}
 
 	assert.NotNil(t, pageData)
 	assert.Equal(t, "test-pod", pageData.SystemInfo.DisplayName)
-	assert.Equal(t, "red", pageData.Users["user1"])
+	assert.Equal(t, "red", pageData.Panels[0].Users["user1"])
 }
 
 func TestSystemInfoStructure(t *testing.T) {
@@ -1 +1,127 @@
-setTimeout(function () { location.reload(1); }, 5000)
\ No newline at end of file
+// Patch grid cells in place from the /events stream of each grid's flag: a
+// "snapshot" with every cell on connect, then "cells" with the ones that
+// changed on a flag refresh.
+function paint(flag, user, color) {
+    var cell = document.getElementById(flag + "/" + user);
+    if (cell && cell.className !== color) {
+        cell.className = color;
+    }
+}
+
+// showSplit fills the split check panel from /api/split, comparing the
+// observed colors with the flag's configured percentages.
+function showSplit(flag) {
+    fetch("api/split?flag=" + encodeURIComponent(flag)).then(function (res) {
+        return res.ok ? res.json() : null;
+    }).then(function (report) {
+        var panel = document.getElementById("split-panel");
+        if (!panel || !report) {
+            return;
+        }
+        var parts = report.variations.map(function (v) {
+            var sign = v.deviation > 0 ? "+" : "";
+            return v.color + " " + v.expectedPercentage + "% → " + v.observedPercentage + "% (" + sign + v.deviation + ")";
+        });
+        var text = "Split check: " + parts.join(" · ") +
+            " — χ² = " + report.chiSquared + ", df = " + report.degreesOfFreedom + ", p = " + report.pValue +
+            (report.fits ? " ✓" : " ✗ does not fit the configured percentages");
+        if (report.warnings) {
+            text += " ⚠ " + report.warnings.join("; ");
+        }
+        panel.textContent = text;
+        panel.hidden = false;
+    }).catch(function () {});
+}
+
+// showMe updates the visitor's own variations from /api/me, keeping the
+// ?as= of the page.
+function showMe() {
+    fetch("api/me" + location.search).then(function (res) {
+        return res.ok ? res.json() : null;
+    }).then(function (me) {
+        if (!me) {
+            return;
+        }
+        me.variations.forEach(function (v) {
+            var el = document.querySelector('#visitor .variation[data-flag="' + CSS.escape(v.flag) + '"]');
+            if (el) {
+                el.querySelector(".swatch").className = "swatch " + v.color;
+                el.querySelector("strong").textContent = v.color;
+            }
+        });
+    }).catch(function () {});
+}
+
+// watch streams the grid of flag. The split check is only shown for a
+// single grid.
+function watch(flag, split) {
+    var source = new EventSource("events?flag=" + encodeURIComponent(flag));
+    source.addEventListener("snapshot", function (e) {
+        var cells = JSON.parse(e.data);
+        Object.keys(cells).forEach(function (user) { paint(flag, user, cells[user]); });
+        if (split) {
+            showSplit(flag);
+        }
+        showMe();
+    });
+    source.addEventListener("cells", function (e) {
+        JSON.parse(e.data).forEach(function (c) { paint(flag, c.user, c.color); });
+        if (split) {
+            showSplit(flag);
+        }
+        showMe();
+    });
+}
+
+// explain shows why the user of a grid cell got their variation, from
+// /api/explain, in a tooltip under the cell.
+function explain(cell) {
+    var tooltip = document.getElementById("explain-tooltip");
+    var slash = cell.id.lastIndexOf("/");
+    if (!tooltip || slash < 0) {
+        return;
+    }
+    var query = "flag=" + encodeURIComponent(cell.id.slice(0, slash)) + "&user=" + encodeURIComponent(cell.id.slice(slash + 1));
+    fetch("api/explain?" + query).then(function (res) {
+        return res.json();
+    }).then(function (e) {
+        var lines = e.error ? [e.error] : [e.summary, "Reason: " + e.reason + (e.rule ? ", rule " + e.rule : "")];
+        if (e.errorCode) {
+            lines.push("Error: " + e.errorCode + (e.errorDetails ? " (" + e.errorDetails + ")" : ""));
+        }
+        if (e.version) {
+            lines.push("Flag version: " + e.version);
+        }
+        tooltip.replaceChildren();
+        lines.forEach(function (text, i) {
+            var line = document.createElement(i === 0 ? "strong" : "div");
+            line.textContent = text;
+            tooltip.appendChild(line);
+        });
+        var rect = cell.getBoundingClientRect();
+        tooltip.style.left = (rect.left + window.scrollX) + "px";
+        tooltip.style.top = (rect.bottom + window.scrollY + 4) + "px";
+        tooltip.hidden = false;
+    }).catch(function () {});
+}
+
+document.addEventListener("click", function (e) {
+    var cell = e.target.closest("table[data-flag] td");
+    if (cell) {
+        explain(cell);
+    } else if (!e.target.closest("#explain-tooltip")) {
+        document.getElementById("explain-tooltip").hidden = true;
+    }
+});
+document.addEventListener("keydown", function (e) {
+    if (e.key === "Escape") {
+        document.getElementById("explain-tooltip").hidden = true;
+    }
+});
+
+if (window.EventSource) {
+    var grids = document.querySelectorAll("table[data-flag]");
+    grids.forEach(function (table) { watch(table.dataset.flag, grids.length === 1); });
+} else {
+    setTimeout(function () { location.reload(1); }, 5000);
+}
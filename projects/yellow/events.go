@@ -0,0 +1,261 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/thomaspoignant/go-feature-flag/notifier"
+)
+
+// subscriberQueue is how many grid updates a slow /events client may fall
+// behind before it is disconnected. The browser reconnects on its own and
+// starts again from a full snapshot.
+const subscriberQueue = 16
+
+// cellChange is one grid cell whose variation changed.
+type cellChange struct {
+	User  string `json:"user"`
+	Color string `json:"color"`
+}
+
+// gridRegistry holds one gridBroker per flag that has been streamed. Brokers
+// are created on first use and refreshed whenever go-feature-flag reloads the
+// flag config, so each flag is evaluated once per change however many pages
+// show it.
+type gridRegistry struct {
+	evaluate func(flag string) (map[string]string, error)
+
+	mu     sync.Mutex
+	grids  map[string]*gridBroker
+	reload int // counts Notify calls, see get
+	closed bool
+}
+
+func newGridRegistry(evaluate func(flag string) (map[string]string, error)) *gridRegistry {
+	return &gridRegistry{
+		evaluate: evaluate,
+		grids:    map[string]*gridBroker{},
+	}
+}
+
+// get returns the broker of flag, evaluating it if it is new. Flags that
+// cannot be evaluated get no broker. The evaluation runs without the lock, so
+// it does not hold up Notify or the other flags' streams.
+func (r *gridRegistry) get(flag string) (*gridBroker, error) {
+	r.mu.Lock()
+	b, ok := r.grids[flag]
+	reload := r.reload
+	r.mu.Unlock()
+	if ok {
+		return b, nil
+	}
+
+	cells, err := r.evaluate(flag)
+	if err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	// Another request may have added the flag meanwhile
+	if b, ok := r.grids[flag]; ok {
+		r.mu.Unlock()
+		return b, nil
+	}
+	b = newGridBroker(func() (map[string]string, error) { return r.evaluate(flag) })
+	b.cells = cells
+	if r.closed {
+		b.close()
+	}
+	r.grids[flag] = b
+	stale := r.reload != reload
+	r.mu.Unlock()
+
+	// A config reload during the evaluation did not see this broker
+	if stale {
+		b.refresh()
+	}
+	return b, nil
+}
+
+// Notify implements notifier.Notifier. go-feature-flag calls it after a
+// polling refresh changed the flag config.
+func (r *gridRegistry) Notify(notifier.DiffCache) error {
+	r.mu.Lock()
+	r.reload++
+	grids := make([]*gridBroker, 0, len(r.grids))
+	for _, b := range r.grids {
+		grids = append(grids, b)
+	}
+	r.mu.Unlock()
+
+	for _, b := range grids {
+		b.refresh()
+	}
+	return nil
+}
+
+// close ends every open stream, so a server shutdown does not wait on them.
+func (r *gridRegistry) close() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.closed = true
+	for _, b := range r.grids {
+		b.close()
+	}
+}
+
+// gridBroker keeps the last evaluated grid of one flag and pushes the cells
+// that changed to its /events subscribers.
+type gridBroker struct {
+	evaluate func() (map[string]string, error)
+
+	mu     sync.Mutex
+	cells  map[string]string
+	subs   map[chan []cellChange]struct{}
+	closed bool
+}
+
+func newGridBroker(evaluate func() (map[string]string, error)) *gridBroker {
+	return &gridBroker{
+		evaluate: evaluate,
+		cells:    map[string]string{},
+		subs:     map[chan []cellChange]struct{}{},
+	}
+}
+
+// refresh re-evaluates every user and sends the cells that changed since the
+// previous evaluation to every subscriber. A failed evaluation, such as for a
+// flag removed from the config, keeps the previous grid.
+func (b *gridBroker) refresh() {
+	cells, err := b.evaluate()
+	if err != nil {
+		log.Printf("Failed to refresh grid: %v", err)
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var changed []cellChange
+	for user, color := range cells {
+		if b.cells[user] != color {
+			changed = append(changed, cellChange{User: user, Color: color})
+		}
+	}
+	b.cells = cells
+	if len(changed) == 0 {
+		return
+	}
+	slices.SortFunc(changed, func(a, b cellChange) int { return strings.Compare(a.User, b.User) })
+
+	for ch := range b.subs {
+		select {
+		case ch <- changed:
+		default:
+			delete(b.subs, ch)
+			close(ch)
+		}
+	}
+}
+
+// subscribe returns a copy of the current grid and a channel receiving the
+// cells that change after it. cancel must be called when done.
+func (b *gridBroker) subscribe() (snapshot map[string]string, updates <-chan []cellChange, cancel func()) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	snapshot = make(map[string]string, len(b.cells))
+	for user, color := range b.cells {
+		snapshot[user] = color
+	}
+	ch := make(chan []cellChange, subscriberQueue)
+	if b.closed {
+		close(ch)
+		return snapshot, ch, func() {}
+	}
+	b.subs[ch] = struct{}{}
+	return snapshot, ch, func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		if _, ok := b.subs[ch]; ok {
+			delete(b.subs, ch)
+			close(ch)
+		}
+	}
+}
+
+// close ends every open stream, so a server shutdown does not wait on them.
+func (b *gridBroker) close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.closed = true
+	for ch := range b.subs {
+		delete(b.subs, ch)
+		close(ch)
+	}
+}
+
+// eventsHandler streams the grid updates of the flag given with ?flag= as
+// server-sent events: a "snapshot" event with every cell when the client
+// connects, then a "cells" event with the cells that changed on each flag
+// config refresh.
+func eventsHandler(r *gridRegistry) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		b, err := r.get(requestedFlag(c))
+		if err != nil {
+			return echo.NewHTTPError(flagErrorStatus(err), err.Error())
+		}
+		snapshot, updates, cancel := b.subscribe()
+		defer cancel()
+
+		res := c.Response()
+		res.Header().Set(echo.HeaderContentType, "text/event-stream")
+		res.Header().Set(echo.HeaderCacheControl, "no-cache")
+		res.Header().Set("X-Accel-Buffering", "no")
+		res.WriteHeader(http.StatusOK)
+
+		if err := writeEvent(res, "snapshot", snapshot); err != nil {
+			return nil
+		}
+
+		// Comments keep idle connections open through proxies
+		keepAlive := time.NewTicker(15 * time.Second)
+		defer keepAlive.Stop()
+		for {
+			select {
+			case <-c.Request().Context().Done():
+				return nil
+			case changed, ok := <-updates:
+				if !ok {
+					return nil
+				}
+				if err := writeEvent(res, "cells", changed); err != nil {
+					return nil
+				}
+			case <-keepAlive.C:
+				if _, err := fmt.Fprint(res, ": keep-alive\n\n"); err != nil {
+					return nil
+				}
+				res.Flush()
+			}
+		}
+	}
+}
+
+func writeEvent(res *echo.Response, event string, data any) error {
+	b, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(res, "event: %s\ndata: %s\n\n", event, b); err != nil {
+		return err
+	}
+	res.Flush()
+	return nil
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thomaspoignant/go-feature-flag/notifier"
+)
+
+func TestGridBroker_SendsOnlyChangedCells(t *testing.T) {
+	grid := map[string]string{"user0": "red", "user1": "grey", "user2": "red"}
+	b := newGridBroker(func() (map[string]string, error) { return grid, nil })
+	b.refresh()
+
+	snapshot, updates, cancel := b.subscribe()
+	defer cancel()
+	assert.Equal(t, grid, snapshot)
+
+	grid = map[string]string{"user0": "red", "user1": "red", "user2": "blue"}
+	b.refresh()
+	assert.Equal(t, []cellChange{{User: "user1", Color: "red"}, {User: "user2", Color: "blue"}}, <-updates)
+
+	// A refresh that changes nothing sends nothing, nor does one that fails
+	b.refresh()
+	b.evaluate = func() (map[string]string, error) { return nil, errors.New("flag removed") }
+	b.refresh()
+	select {
+	case changed := <-updates:
+		t.Fatalf("unexpected update %v", changed)
+	default:
+	}
+}
+
+func TestGridRegistry_OneBrokerPerFlag(t *testing.T) {
+	grids := map[string]map[string]string{
+		"color-box": {"user0": "red"},
+		"dark-mode": {"user0": "off"},
+	}
+	evaluations := 0
+	r := newGridRegistry(func(flag string) (map[string]string, error) {
+		evaluations++
+		cells, ok := grids[flag]
+		if !ok {
+			return nil, fmt.Errorf("%w: %q", errUnknownFlag, flag)
+		}
+		return cells, nil
+	})
+	defer r.close()
+
+	colors, err := r.get("color-box")
+	require.NoError(t, err)
+	again, err := r.get("color-box")
+	require.NoError(t, err)
+	assert.Same(t, colors, again)
+	_, err = r.get("missing")
+	assert.ErrorIs(t, err, errUnknownFlag)
+
+	darkMode, err := r.get("dark-mode")
+	require.NoError(t, err)
+	_, updates, cancel := darkMode.subscribe()
+	defer cancel()
+
+	grids["dark-mode"] = map[string]string{"user0": "on"}
+	require.NoError(t, r.Notify(notifier.DiffCache{}))
+	assert.Equal(t, []cellChange{{User: "user0", Color: "on"}}, <-updates)
+	assert.Equal(t, 5, evaluations, "three first uses and one refresh per broker")
+}
+
+func TestGridRegistry_EvaluatesWithoutTheLock(t *testing.T) {
+	evaluating := make(chan struct{})
+	release := make(chan struct{})
+	var mu sync.Mutex
+	color, slow := "red", true
+	r := newGridRegistry(func(flag string) (map[string]string, error) {
+		mu.Lock()
+		wait := flag == "slow-flag" && slow
+		if wait {
+			slow = false
+		}
+		cells := map[string]string{"user0": color}
+		mu.Unlock()
+		if wait {
+			close(evaluating)
+			<-release
+		}
+		return cells, nil
+	})
+	defer r.close()
+
+	got := make(chan *gridBroker)
+	go func() {
+		b, err := r.get("slow-flag")
+		assert.NoError(t, err)
+		got <- b
+	}()
+	<-evaluating
+
+	// Neither other flags nor a reload wait on the slow evaluation
+	_, err := r.get("color-box")
+	require.NoError(t, err)
+	mu.Lock()
+	color = "blue"
+	mu.Unlock()
+	require.NoError(t, r.Notify(notifier.DiffCache{}))
+
+	// and the grid evaluated before the reload is refreshed once added
+	close(release)
+	snapshot, _, cancel := (<-got).subscribe()
+	defer cancel()
+	assert.Equal(t, map[string]string{"user0": "blue"}, snapshot)
+}
+
+func TestGridBroker_DropsSlowSubscriber(t *testing.T) {
+	n := 0
+	b := newGridBroker(func() (map[string]string, error) {
+		n++
+		return map[string]string{"user0": strings.Repeat("x", n)}, nil
+	})
+	_, updates, cancel := b.subscribe()
+	defer cancel()
+
+	for i := 0; i <= subscriberQueue; i++ {
+		b.refresh()
+	}
+	received := 0
+	for range updates {
+		received++
+	}
+	assert.Equal(t, subscriberQueue, received, "the channel is closed once the queue is full")
+}
+
+func TestEventsHandler_StreamsSnapshotThenChanges(t *testing.T) {
+	grid := map[string]string{"user0": "red", "user1": "grey"}
+	r := newGridRegistry(func(flag string) (map[string]string, error) {
+		if flag != "color-box" {
+			return nil, fmt.Errorf("%w: %q", errUnknownFlag, flag)
+		}
+		return grid, nil
+	})
+
+	e := echo.New()
+	e.GET("/events", eventsHandler(r))
+	srv := httptest.NewServer(e)
+	defer srv.Close()
+	defer r.close()
+
+	missing, err := http.Get(srv.URL + "/events?flag=missing")
+	require.NoError(t, err)
+	_ = missing.Body.Close()
+	assert.Equal(t, http.StatusNotFound, missing.StatusCode)
+
+	res, err := http.Get(srv.URL + "/events?flag=color-box")
+	require.NoError(t, err)
+	defer func() { _ = res.Body.Close() }()
+	assert.Equal(t, "text/event-stream", res.Header.Get("Content-Type"))
+
+	lines := make(chan string)
+	go func() {
+		scanner := bufio.NewScanner(res.Body)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+		close(lines)
+	}()
+	next := func() string {
+		select {
+		case l := <-lines:
+			return l
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for an event")
+			return ""
+		}
+	}
+
+	assert.Equal(t, "event: snapshot", next())
+	assert.Equal(t, `data: {"user0":"red","user1":"grey"}`, next())
+	assert.Empty(t, next())
+
+	grid = map[string]string{"user0": "red", "user1": "green"}
+	require.NoError(t, r.Notify(notifier.DiffCache{}))
+	assert.Equal(t, "event: cells", next())
+	assert.Equal(t, `data: [{"user":"user1","color":"green"}]`, next())
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	ffclient "github.com/thomaspoignant/go-feature-flag"
+	"github.com/thomaspoignant/go-feature-flag/ffcontext"
+)
+
+// ruleNameMetadata is the metadata key under which go-feature-flag returns
+// the name of the targeting rule that served a variation.
+const ruleNameMetadata = "evaluatedRuleName"
+
+// explanation is why one user got their variation of a flag.
+type explanation struct {
+	Flag         string         `json:"flag"`
+	Key          string         `json:"key"`
+	User         string         `json:"user,omitempty"` // grid cell, if the key is in the population
+	Attributes   map[string]any `json:"attributes,omitempty"`
+	Value        string         `json:"value"`     // cell class
+	Variation    string         `json:"variation"` // variation name in the flag config
+	Reason       string         `json:"reason"`
+	Rule         string         `json:"rule,omitempty"`
+	ErrorCode    string         `json:"errorCode,omitempty"`
+	ErrorDetails string         `json:"errorDetails,omitempty"`
+	Version      string         `json:"version,omitempty"`
+	Summary      string         `json:"summary"`
+}
+
+// explainFlag evaluates a string or boolean flag for one context with
+// go-feature-flag's variation details. A failed evaluation is explained, with
+// its error code, rather than returned as an error.
+func explainFlag(key string, ctx ffcontext.Context) (explanation, error) {
+	kind, err := renderableType(key)
+	if err != nil {
+		return explanation{}, err
+	}
+	e := explanation{Flag: key, Key: ctx.GetKey()}
+	if kind == "boolean" {
+		res, _ := ffclient.BoolVariationDetails(key, ctx, false)
+		e.Value, e.Variation, e.Version = cellClass(res.Value), res.VariationType, res.Version
+		e.Reason, e.ErrorCode, e.ErrorDetails = string(res.Reason), string(res.ErrorCode), res.ErrorDetails
+		e.Rule, _ = res.Metadata[ruleNameMetadata].(string)
+	} else {
+		res, _ := ffclient.StringVariationDetails(key, ctx, "grey")
+		e.Value, e.Variation, e.Version = res.Value, res.VariationType, res.Version
+		e.Reason, e.ErrorCode, e.ErrorDetails = string(res.Reason), string(res.ErrorCode), res.ErrorDetails
+		e.Rule, _ = res.Metadata[ruleNameMetadata].(string)
+	}
+	return e, nil
+}
+
+// why says in words how go-feature-flag picked the variation.
+func (e explanation) why() string {
+	switch e.Reason {
+	case "TARGETING_MATCH":
+		if e.Rule != "" {
+			return fmt.Sprintf("targeting rule %q matched", e.Rule)
+		}
+		return "a targeting rule matched"
+	case "TARGETING_MATCH_SPLIT":
+		if e.Rule != "" {
+			return fmt.Sprintf("targeting rule %q matched and split its users", e.Rule)
+		}
+		return "a targeting rule matched and split its users"
+	case "SPLIT":
+		return "the default rule split the users by percentage"
+	case "DEFAULT":
+		return "no targeting rule matched, so the default rule applied"
+	case "STATIC":
+		return "the default rule serves every user the same variation"
+	case "DISABLED":
+		return "the flag is disabled, so the default value applied"
+	case "OFFLINE":
+		return "go-feature-flag is offline, so the default value applied"
+	case "ERROR":
+		return fmt.Sprintf("the evaluation failed with %s, so the default value applied", e.ErrorCode)
+	}
+	return fmt.Sprintf("go-feature-flag gave the reason %s", e.Reason)
+}
+
+// summarize fills the one line summary shown in the grid tooltip.
+func (e *explanation) summarize() {
+	who := e.Key
+	if e.User != "" {
+		who = e.User
+	}
+	got := e.Value
+	if e.Variation != "" {
+		got += " (" + e.Variation + ")"
+	}
+	e.Summary = fmt.Sprintf("%s got %s: %s", who, got, e.why())
+}
+
+// explainHandler explains why the user given with ?user=, by key or grid name
+// (user42), got their variation of the flag given with ?flag=. As with ?as=,
+// a key outside the population is evaluated without attributes.
+func explainHandler(c echo.Context) error {
+	ref := c.QueryParam("user")
+	if ref == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "missing ?user="})
+	}
+	var ctx ffcontext.Context = ffcontext.NewEvaluationContext(ref)
+	u, inPopulation := lookupUser(ref)
+	if inPopulation {
+		ctx = u.Context
+	}
+
+	e, err := explainFlag(requestedFlag(c), ctx)
+	if err != nil {
+		return c.JSON(flagErrorStatus(err), map[string]string{"error": err.Error()})
+	}
+	if inPopulation {
+		e.User, e.Attributes = u.Name, u.Attributes
+	}
+	e.summarize()
+	return c.JSON(http.StatusOK, e)
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	ffclient "github.com/thomaspoignant/go-feature-flag"
+	"github.com/thomaspoignant/go-feature-flag/ffcontext"
+)
+
+var (
+	errUnknownFlag     = errors.New("unknown flag")
+	errUnsupportedFlag = errors.New("unsupported flag type")
+)
+
+// defaultFlag is the flag rendered when a request does not name one with
+// ?flag=. It is set with -flag.
+var defaultFlag = "color-box"
+
+// gridPanel is the grid of one flag: the cell class of every user.
+type gridPanel struct {
+	Flag  string
+	Users map[string]string
+}
+
+// flagInfo describes one flag of the config on the index page.
+type flagInfo struct {
+	Key        string
+	Type       string
+	Default    string // value of the default variation
+	Version    string
+	Disabled   bool
+	Renderable bool
+}
+
+// FlagsPageData holds the data rendered in the flag index page
+type FlagsPageData struct {
+	Flags       []flagInfo
+	DefaultFlag string
+	SystemInfo  SystemInfo
+}
+
+// requestedFlags returns the flags listed with ?flag=, repeated or comma
+// separated, or the default flag.
+func requestedFlags(c echo.Context) []string {
+	var flags []string
+	for _, v := range c.QueryParams()["flag"] {
+		for key := range strings.SplitSeq(v, ",") {
+			key = strings.TrimSpace(key)
+			if key != "" && !slices.Contains(flags, key) {
+				flags = append(flags, key)
+			}
+		}
+	}
+	if len(flags) == 0 {
+		return []string{defaultFlag}
+	}
+	return flags
+}
+
+// requestedFlag returns the first flag of ?flag=, or the default flag.
+func requestedFlag(c echo.Context) string {
+	return requestedFlags(c)[0]
+}
+
+// flagErrorStatus is the HTTP status for an error of evaluateFlag.
+func flagErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, errUnknownFlag):
+		return http.StatusNotFound
+	case errors.Is(err, errUnsupportedFlag):
+		return http.StatusBadRequest
+	default:
+		return http.StatusServiceUnavailable
+	}
+}
+
+// flagType names the type of a flag from the value of one of its variations.
+func flagType(value any) string {
+	switch value.(type) {
+	case bool:
+		return "boolean"
+	case string:
+		return "string"
+	case int, int64, float64:
+		return "number"
+	default:
+		return "json"
+	}
+}
+
+// cellClass is the grid cell class of a variation value: the value itself,
+// which for color flags is a color, or "on" and "off" for booleans.
+func cellClass(value any) string {
+	if on, ok := value.(bool); ok {
+		if on {
+			return "on"
+		}
+		return "off"
+	}
+	return fmt.Sprint(value)
+}
+
+// renderableType returns the type of a flag the grid can render, "string" or
+// "boolean".
+func renderableType(key string) (string, error) {
+	flags, err := ffclient.GetFlagsFromCache()
+	if err != nil {
+		return "", err
+	}
+	f, ok := flags[key]
+	if !ok {
+		return "", fmt.Errorf("%w %q", errUnknownFlag, key)
+	}
+	kind := flagType(f.GetVariationValue(f.GetDefaultVariation()))
+	if kind != "boolean" && kind != "string" {
+		return "", fmt.Errorf("%w: %q is a %s flag, the grid renders string and boolean flags", errUnsupportedFlag, key, kind)
+	}
+	return kind, nil
+}
+
+// cellEvaluator returns a function evaluating a string or boolean flag for
+// one context, as the class of a grid cell. A failed evaluation returns the
+// default, grey or off, along with the error.
+func cellEvaluator(key string) (func(ffcontext.Context) (string, error), error) {
+	kind, err := renderableType(key)
+	if err != nil {
+		return nil, err
+	}
+	if kind == "boolean" {
+		return func(ctx ffcontext.Context) (string, error) {
+			on, err := ffclient.BoolVariation(key, ctx, false)
+			return cellClass(on), err
+		}, nil
+	}
+	return func(ctx ffcontext.Context) (string, error) {
+		return ffclient.StringVariation(key, ctx, "grey")
+	}, nil
+}
+
+// evaluateFlag returns the cell class of every user for a string or boolean
+// flag.
+func evaluateFlag(key string) (map[string]string, error) {
+	evaluate, err := cellEvaluator(key)
+	if err != nil {
+		return nil, err
+	}
+	colors := make(map[string]string, len(users))
+	for _, u := range users {
+		color, err := evaluate(u.Context)
+		if err != nil {
+			log.Printf("Feature flag evaluation error for %s: %v", u.Name, err)
+			metrics.evaluationErrors.WithLabelValues(key).Inc()
+		}
+		colors[u.Name] = color
+	}
+	metrics.countEvaluations(key, colors)
+	return colors, nil
+}
+
+// listFlags describes every flag of the config, sorted by key.
+func listFlags() ([]flagInfo, error) {
+	flags, err := ffclient.GetFlagsFromCache()
+	if err != nil {
+		return nil, err
+	}
+	infos := make([]flagInfo, 0, len(flags))
+	for key, f := range flags {
+		value := f.GetVariationValue(f.GetDefaultVariation())
+		kind := flagType(value)
+		infos = append(infos, flagInfo{
+			Key:        key,
+			Type:       kind,
+			Default:    fmt.Sprint(value),
+			Version:    f.GetVersion(),
+			Disabled:   f.IsDisable(),
+			Renderable: kind == "boolean" || kind == "string",
+		})
+	}
+	slices.SortFunc(infos, func(a, b flagInfo) int { return strings.Compare(a.Key, b.Key) })
+	return infos, nil
+}
+
+// flagsHandler renders the index page, which links to the grid of every flag.
+func flagsHandler(c echo.Context) error {
+	flags, err := listFlags()
+	if err != nil {
+		return echo.NewHTTPError(http.StatusServiceUnavailable, err.Error())
+	}
+	return c.Render(http.StatusOK, "flags.html", FlagsPageData{
+		Flags:       flags,
+		DefaultFlag: defaultFlag,
+		SystemInfo:  systemInfo(c),
+	})
+}